@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/etkecc/agru/internal/bundle"
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/hostlimit"
+	"github.com/etkecc/agru/internal/installer"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/parser"
+	"github.com/etkecc/agru/internal/runner"
+	"github.com/etkecc/agru/internal/utils"
+)
+
+// createBundle runs the bundle command: installs the roles (with includes and dependencies) and collections
+// from the requirements file into a tmp dir and packs them with their lockfile into the bundle at the path. Returns the exit code
+func createBundle(ctx context.Context, cfg config, r runner.Runner, g *galaxy.Client, c *cache.Cache, hosts *hostlimit.Limiter, p *parser.Parser, command, path string) int {
+	if command != "create" || path == "" {
+		utils.Log("ERROR: usage: agru bundle create out.tar")
+		return 1
+	}
+
+	entries, installOnly, err := p.ParseFile(cfg.requirementsPath)
+	if err != nil {
+		utils.Log("ERROR:", err)
+		return 1
+	}
+	merged := p.MergeFiles(entries, installOnly)
+	collections, err := p.ParseCollections(cfg.requirementsPath)
+	if err != nil {
+		utils.Log("ERROR:", err)
+		return 1
+	}
+	dir, err := os.MkdirTemp("", "agru-bundle-*")
+	if err != nil {
+		utils.Log("ERROR: creating tmp dir:", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	collectionsPath := filepath.Join(dir, bundle.CollectionsDir)
+	inst := installer.New(r, g, filepath.Join(dir, bundle.RolesDir), collectionsPath, cfg.limit, hosts, cfg.cleanup, cfg.noDeps, false, c, "")
+	if cfg.frozen {
+		lockPath := models.LockPath(cfg.requirementsPath)
+		lock, err := models.ReadLock(lockPath)
+		if err != nil {
+			utils.Log("ERROR:", err)
+			return 1
+		}
+		if err := lock.Apply(merged); err != nil {
+			utils.Log(fmt.Sprintf("ERROR: %s doesn't match %s:\n%v", cfg.requirementsPath, lockPath, err))
+			return 1
+		}
+		inst.SetLock(lock)
+	}
+	if err := inst.InstallMissing(ctx, merged, collections, nil); err != nil {
+		utils.Log("ERROR:", err)
+		return 1
+	}
+	lock, err := models.NewLock(merged, inst.FS())
+	if err != nil {
+		utils.Log("ERROR:", err)
+		return 1
+	}
+	if deps := inst.Dependencies(); deps != nil {
+		if err := lock.AddDependencies(deps, inst.FS()); err != nil {
+			utils.Log("ERROR:", err)
+			return 1
+		}
+	}
+	if err := lock.AddCollections(collections, os.DirFS(collectionsPath)); err != nil {
+		utils.Log("ERROR:", err)
+		return 1
+	}
+	if err := lock.Write(filepath.Join(dir, bundle.LockName)); err != nil {
+		utils.Log("ERROR:", err)
+		return 1
+	}
+	if err := bundle.Pack(dir, path); err != nil {
+		utils.Log("ERROR:", err)
+		return 1
+	}
+
+	for _, role := range lock.Roles {
+		fmt.Printf("%s@%s %s\n", role.Name, role.Version, role.Commit)
+	}
+	for _, collection := range lock.Collections {
+		fmt.Printf("%s@%s\n", collection.Name, collection.Version)
+	}
+	utils.Log(fmt.Sprintf("%d roles and %d collections bundled into %s", len(lock.Roles), len(lock.Collections), path))
+	return 0
+}
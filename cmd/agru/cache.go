@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/utils"
+)
+
+// manageCache runs the cache command (list, prune, or clean) and returns the exit code
+func manageCache(ctx context.Context, c *cache.Cache, command string, maxAge time.Duration) int {
+	if c == nil {
+		utils.Log("ERROR: the cache is disabled, set -cache-dir")
+		return 1
+	}
+
+	switch command {
+	case "list":
+		items, err := c.List(ctx)
+		if err != nil {
+			utils.Log("ERROR:", err)
+			return 1
+		}
+		var total int64
+		for _, item := range items {
+			total += item.Size
+			fmt.Printf("%s  %s  %s  last used %s\n", item.Kind, item.Source, formatSize(item.Size), item.LastUsed.Format(time.DateTime))
+		}
+		utils.Log(fmt.Sprintf("%d items, %s in %s", len(items), formatSize(total), c.Dir()))
+	case "prune":
+		pruned, err := c.Prune(ctx, maxAge)
+		for _, item := range pruned {
+			utils.Log(fmt.Sprintf("removed %s %s (%s)", item.Kind, item.Source, formatSize(item.Size)))
+		}
+		if err != nil {
+			utils.Log("ERROR:", err)
+			return 1
+		}
+		utils.Log(fmt.Sprintf("%d items removed", len(pruned)))
+	case "clean":
+		if err := c.Clean(); err != nil {
+			utils.Log("ERROR:", err)
+			return 1
+		}
+		utils.Log("removed", c.Dir())
+	default:
+		utils.Log(fmt.Sprintf("ERROR: unknown cache command %q, expected list, prune, or clean", command))
+		return 1
+	}
+	return 0
+}
+
+// formatSize returns the human-readable size, e.g. 1.5 MiB
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -1,27 +1,52 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
 
 	tea "charm.land/bubbletea/v2"
 
+	"github.com/etkecc/agru/internal/bundle"
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/hostlimit"
 	"github.com/etkecc/agru/internal/installer"
 	"github.com/etkecc/agru/internal/parser"
 	"github.com/etkecc/agru/internal/runner"
 	"github.com/etkecc/agru/internal/tui"
 	"github.com/etkecc/agru/internal/utils"
+	"github.com/etkecc/agru/internal/versions"
 )
 
 // version is set by goreleaser via -X main.version={{.Version}} at release build time.
 var version = ""
 
 type config struct {
-	rolesPath, requirementsPath, deleteInstalled                                           string
-	limit                                                                                  int
-	listInstalled, installMissing, updateRequirementsFile, cleanup, verbose, keep, version bool
+	rolesPath, collectionsPath, requirementsPath, deleteInstalled, galaxyServer, updatePolicy, cacheDir, fromBundle                 string
+	offline                                                                                                                         offlineFlag
+	hostLimits                                                                                                                      hostlimit.Limits
+	limit, retries                                                                                                                  int
+	cacheMaxAge, timeout, gitTimeout                                                                                                time.Duration
+	listInstalled, installMissing, updateRequirementsFile, allowPrerelease, frozen, cleanup, noDeps, atomic, verbose, keep, version bool
+	args                                                                                                                            []string // command and its arguments, e.g. cache prune
+}
+
+// commandArgs is the max number of the positional arguments of the commands, including the command itself
+var commandArgs = map[string]int{"verify": 1, "bundle": 3, "cache": 2}
+
+// arg returns the i'th positional argument, empty if there is no such argument
+func (cfg config) arg(i int) string {
+	if i >= len(cfg.args) {
+		return ""
+	}
+	return cfg.args[i]
 }
 
 func getVersion() string {
@@ -61,44 +86,236 @@ func main() {
 		fmt.Println(getVersion())
 		return
 	}
-	r := runner.New()
-	p := parser.New(r)
-	inst := installer.New(r, cfg.rolesPath, cfg.limit, cfg.cleanup)
+	policy := versions.Policy{Update: cfg.updatePolicy, AllowPrerelease: cfg.allowPrerelease}
+	if err := policy.Validate(); err != nil {
+		utils.Log("ERROR:", err)
+		os.Exit(1)
+	}
+	if cfg.frozen && cfg.updateRequirementsFile {
+		utils.Log("ERROR: -frozen and -u can't be used together")
+		os.Exit(1)
+	}
+	if cfg.offline.mode == cache.OfflineOn && cfg.updateRequirementsFile {
+		utils.Log("ERROR: -offline and -u can't be used together")
+		os.Exit(1)
+	}
+	if cfg.fromBundle != "" && cfg.updateRequirementsFile {
+		utils.Log("ERROR: -from-bundle and -u can't be used together")
+		os.Exit(1)
+	}
+	if cfg.offline.mode != cache.OfflineOff && cfg.cacheDir == "" {
+		utils.Log("ERROR: -offline requires the cache, set -cache-dir")
+		os.Exit(1)
+	}
+
+	// the running git commands and downloads are stopped on SIGINT/SIGTERM and after the -timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.timeout, fmt.Errorf("-timeout %s exceeded: %w", cfg.timeout, context.DeadlineExceeded))
+		defer cancel()
+	}
+
+	retries := &retryLog{verbose: cfg.verbose, retries: cfg.retries}
+	r := runner.NewRetryRunner(runner.New(cfg.gitTimeout), runner.DefaultRetryPolicy(cfg.retries), retries.log)
+	var c *cache.Cache
+	if cfg.cacheDir != "" {
+		c = cache.New(r, cfg.cacheDir, cfg.offline.mode)
+	}
+	g := galaxy.New(galaxyServers(cfg.galaxyServer)...)
+	hosts := hostlimit.New(cfg.hostLimits) // shared by the checks and installs
+	p := parser.New(r, g, os.DirFS(cfg.rolesPath), policy, cfg.limit, hosts)
+	inst := installer.New(r, g, cfg.rolesPath, cfg.collectionsPath, cfg.limit, hosts, cfg.cleanup, cfg.noDeps, cfg.atomic, c, "")
+	switch cfg.arg(0) {
+	case "verify":
+		os.Exit(verify(cfg.requirementsPath, p, inst))
+	case "bundle":
+		os.Exit(createBundle(ctx, cfg, r, g, c, hosts, p, cfg.arg(1), cfg.arg(2)))
+	case "cache":
+		os.Exit(manageCache(ctx, c, cfg.arg(1), cfg.cacheMaxAge))
+	}
+
+	var unpackedDir string
+	if cfg.fromBundle != "" {
+		var err error
+		unpackedDir, err = bundle.Unpack(cfg.fromBundle)
+		if err != nil {
+			utils.Log("ERROR:", err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(unpackedDir)
+		inst = installer.New(r, g, cfg.rolesPath, cfg.collectionsPath, cfg.limit, hosts, cfg.cleanup, cfg.noDeps, cfg.atomic, c, unpackedDir)
+	}
 
 	tuiCfg := tui.Config{
 		RequirementsPath: cfg.requirementsPath,
 		RolesPath:        cfg.rolesPath,
+		CollectionsPath:  cfg.collectionsPath,
 		DeleteName:       cfg.deleteInstalled,
 		Limit:            cfg.limit,
 		ListInstalled:    cfg.listInstalled,
 		InstallMissing:   cfg.installMissing,
 		UpdateFile:       cfg.updateRequirementsFile,
+		Frozen:           cfg.frozen,
 		Cleanup:          cfg.cleanup,
+		Atomic:           cfg.atomic,
 		Verbose:          cfg.verbose,
 		Keep:             cfg.keep,
 	}
 
-	prog := tea.NewProgram(tui.New(tuiCfg, p, inst))
-	if _, err := prog.Run(); err != nil {
+	model := tui.New(ctx, tuiCfg, p, inst)
+	program := tea.NewProgram(model)
+	retries.program = program // set before the checks and installs start
+	_, err := program.Run()
+	// stop the checks and installs that are still running (e.g. after 'q'), and wait until they clean up
+	stop()
+	model.Wait()
+	if err != nil {
 		utils.Log("ERROR:", err)
+		if unpackedDir != "" {
+			os.RemoveAll(unpackedDir) // os.Exit doesn't run the deferred funcs
+		}
 		os.Exit(1)
 	}
 }
 
+// galaxyServers returns Galaxy servers in priority order: the -s server if it was set explicitly,
+// otherwise servers from ANSIBLE_GALAXY_SERVER_LIST with the -s default as the fallback
+func galaxyServers(server string) []galaxy.Server {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "s" {
+			explicit = true
+		}
+	})
+	if explicit {
+		return []galaxy.Server{{URL: server}}
+	}
+	return galaxy.ServersFromEnv(server)
+}
+
+// defaultGalaxyServer returns the Galaxy server from ANSIBLE_GALAXY_SERVER env var, or the public Galaxy server
+func defaultGalaxyServer() string {
+	if server := os.Getenv("ANSIBLE_GALAXY_SERVER"); server != "" {
+		return server
+	}
+	return galaxy.DefaultServer
+}
+
 func parseFlags() config {
 	var cfg config
 	flag.StringVar(&cfg.requirementsPath, "r", "requirements.yml", "ansible-galaxy requirements file")
 	flag.StringVar(&cfg.rolesPath, "p", "roles/galaxy/", "path to install roles")
+	flag.StringVar(&cfg.collectionsPath, "cp", "collections/", "path to install collections (as ansible_collections/namespace/name)")
 	flag.StringVar(&cfg.deleteInstalled, "d", "", "delete installed role, all other flags are ignored")
-	flag.IntVar(&cfg.limit, "limit", 0, "limit the number of parallel downloads (affects roles installation only). 0 - no limit (default)")
+	flag.StringVar(&cfg.galaxyServer, "s", defaultGalaxyServer(), "Ansible Galaxy API server URL, used for roles referenced by namespace.name")
+	flag.IntVar(&cfg.limit, "limit", 0, "limit the number of parallel checks and downloads. 0 - no limit (default)")
+	cfg.hostLimits = hostlimit.DefaultLimits()
+	flag.Var(cfg.hostLimits, "host-limit", "limit the number of parallel checks and downloads per host, as host=limit pairs, e.g. github.com=8,gitlab.example.com=2, where * is any other host, 0 - no limit (can be repeated)")
 	flag.BoolVar(&cfg.listInstalled, "l", false, "list installed roles")
 	flag.BoolVar(&cfg.installMissing, "i", true, "install missing roles")
 	flag.BoolVar(&cfg.updateRequirementsFile, "u", false, "update requirements file if newer versions are available")
+	flag.StringVar(&cfg.updatePolicy, "update-policy", versions.UpdateMajor, "default update policy for -u: major, minor or patch (can be overridden per role with the update key)")
+	flag.BoolVar(&cfg.allowPrerelease, "allow-prerelease", false, "allow -u to update to pre-release versions (can be overridden per role with the allow_prerelease key)")
+	flag.BoolVar(&cfg.frozen, "frozen", false, "install the exact commits from the lockfile (requirements.lock), fail if it doesn't match the requirements file")
+	flag.StringVar(&cfg.cacheDir, "cache-dir", cache.DefaultDir(), "cache dir for git mirrors and downloaded archives, empty to disable the cache")
+	flag.DurationVar(&cfg.timeout, "timeout", 0, "stop the whole run after that time, 0 - no timeout (default)")
+	flag.DurationVar(&cfg.gitTimeout, "git-timeout", 10*time.Minute, "stop a single git (or hg) command, e.g. clone, after that time, 0 - no timeout")
+	flag.IntVar(&cfg.retries, "retries", 5, "retry git (and hg) commands failed with network errors (e.g. DNS errors, HTTP 429/5xx, timeouts) up to that many times, with exponential backoff, 0 - no retries")
+	flag.DurationVar(&cfg.cacheMaxAge, "cache-max-age", 30*24*time.Hour, "remove cached mirrors and archives not used for longer than that with the cache prune command")
+	cfg.offline.mode = cache.OfflineOff
+	flag.Var(&cfg.offline, "offline", "install from the cache only, without network access; -offline=auto falls back to the cache when the network fails")
+	flag.StringVar(&cfg.fromBundle, "from-bundle", "", "install the roles and collections from the bundle (created with the bundle create command), without network access")
 	flag.BoolVar(&cfg.cleanup, "c", true, "cleanup temporary files")
+	flag.BoolVar(&cfg.noDeps, "no-deps", false, "don't install role dependencies from meta/main.yml and meta/requirements.yml")
+	flag.BoolVar(&cfg.atomic, "atomic", false, "if any role or collection fails to install, restore all roles and collections replaced during the run to their previous versions")
 	flag.BoolVar(&cfg.verbose, "verbose", false, "verbose output")
 	flag.BoolVar(&cfg.keep, "k", false, "keep TUI open after completion until 'q'")
 	flag.BoolVar(&cfg.version, "v", false, "print version and exit")
 	flag.BoolVar(&cfg.version, "version", false, "print version and exit")
 	flag.Parse()
+	cfg.args = parseArgs(flag.CommandLine)
+	if err := validateArgs(cfg.args); err != nil {
+		utils.Log("ERROR:", err)
+		flag.Usage()
+		os.Exit(2)
+	}
 	return cfg
 }
+
+// parseArgs returns the positional arguments of the parsed flag set, parsing the flags between and after them,
+// e.g. agru cache prune -cache-max-age 168h, as the flag package stops parsing at the first positional argument
+func parseArgs(fs *flag.FlagSet) []string {
+	var args []string
+	for fs.NArg() > 0 {
+		args = append(args, fs.Arg(0))
+		if err := fs.Parse(fs.Args()[1:]); err != nil { // the flag set exits or reports the error itself
+			return nil
+		}
+	}
+	return args
+}
+
+// validateArgs checks that the positional arguments are a known command with its arguments
+func validateArgs(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	maxArgs, ok := commandArgs[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, expected verify, bundle, or cache", args[0])
+	}
+	if len(args) > maxArgs {
+		return fmt.Errorf("unexpected arguments of the %s command: %s", args[0], strings.Join(args[maxArgs:], " "))
+	}
+	return nil
+}
+
+// retryLog logs the retried git (and hg) commands in verbose mode,
+// to the TUI's log panel once the program is set, or to stdout otherwise (e.g. for the bundle and cache commands)
+type retryLog struct {
+	verbose bool
+	retries int
+	program *tea.Program
+}
+
+func (l *retryLog) log(cmd runner.Command, retry int, delay time.Duration, err error) {
+	if !l.verbose {
+		return
+	}
+	line := fmt.Sprintf("retrying %s in %s (retry %d/%d): %s", cmd, delay.Round(time.Millisecond), retry, l.retries, strings.ReplaceAll(err.Error(), "\n", " "))
+	if l.program != nil {
+		l.program.Send(tui.LogMsg(line))
+		return
+	}
+	utils.Log(line)
+}
+
+// offlineFlag is the -offline flag: boolean (-offline, -offline=false), or -offline=auto
+type offlineFlag struct {
+	mode string
+}
+
+func (f *offlineFlag) String() string {
+	return f.mode
+}
+
+func (f *offlineFlag) Set(value string) error {
+	switch value {
+	case "true", cache.OfflineOn:
+		f.mode = cache.OfflineOn
+	case "false", cache.OfflineOff:
+		f.mode = cache.OfflineOff
+	case cache.OfflineAuto:
+		f.mode = cache.OfflineAuto
+	default:
+		return fmt.Errorf("unknown offline mode %q, expected true, false, or auto", value)
+	}
+	return nil
+}
+
+// IsBoolFlag allows -offline without a value
+func (f *offlineFlag) IsBoolFlag() bool {
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		args     []string
+		maxAge   time.Duration
+		reqsPath string
+	}{
+		{"no command", []string{"-r", "other.yml"}, nil, time.Hour, "other.yml"},
+		{"flags before the command", []string{"-cache-max-age", "168h", "cache", "prune"}, []string{"cache", "prune"}, 168 * time.Hour, "requirements.yml"},
+		{"flags after the command", []string{"cache", "prune", "-cache-max-age", "168h"}, []string{"cache", "prune"}, 168 * time.Hour, "requirements.yml"},
+		{"flags between the arguments", []string{"verify", "-r", "other.yml"}, []string{"verify"}, time.Hour, "other.yml"},
+		{"flags everywhere", []string{"bundle", "-r", "other.yml", "create", "-cache-max-age", "2h", "out.tar"}, []string{"bundle", "create", "out.tar"}, 2 * time.Hour, "other.yml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("agru", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			maxAge := fs.Duration("cache-max-age", time.Hour, "")
+			reqsPath := fs.String("r", "requirements.yml", "")
+			if err := fs.Parse(tt.input); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := parseArgs(fs); !slices.Equal(got, tt.args) {
+				t.Errorf("parseArgs() = %q, want %q", got, tt.args)
+			}
+			if *maxAge != tt.maxAge || *reqsPath != tt.reqsPath {
+				t.Errorf("parseArgs() flags = %s, %q, want %s, %q", *maxAge, *reqsPath, tt.maxAge, tt.reqsPath)
+			}
+		})
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{nil, false},
+		{[]string{"verify"}, false},
+		{[]string{"bundle", "create", "out.tar"}, false},
+		{[]string{"cache", "prune"}, false},
+		{[]string{"cache"}, false}, // the missing cache command is reported by the cache command
+		{[]string{"verfy"}, true},
+		{[]string{"verify", "other.yml"}, true},
+		{[]string{"cache", "prune", "168h"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateArgs(tt.args); (err != nil) != tt.wantErr {
+			t.Errorf("validateArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+	}
+}
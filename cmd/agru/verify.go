@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/etkecc/agru/internal/installer"
+	"github.com/etkecc/agru/internal/parser"
+	"github.com/etkecc/agru/internal/utils"
+)
+
+// verify checks the installed roles for local modifications and prints the modified, added, and deleted files.
+// Returns the exit code: 0 if all roles are intact, 1 otherwise
+func verify(requirementsPath string, p *parser.Parser, inst *installer.Installer) int {
+	entries, installOnly, err := p.ParseFile(requirementsPath)
+	if err != nil {
+		utils.Log("ERROR:", err)
+		return 1
+	}
+
+	code := 0
+	for _, drift := range inst.Verify(p.MergeFiles(entries, installOnly)) {
+		switch {
+		case drift.Err != nil:
+			code = 1
+			utils.Log(fmt.Sprintf("%s: %v", drift.Name, drift.Err))
+		case drift.Unknown:
+			utils.Log(fmt.Sprintf("%s: no digest recorded, reinstall the role to record it", drift.Name))
+		case drift.Changed():
+			code = 1
+			utils.Log(fmt.Sprintf("%s: %d modified, %d added, %d deleted", drift.Name, len(drift.Modified), len(drift.Added), len(drift.Deleted)))
+			printPaths("M", drift.Modified)
+			printPaths("A", drift.Added)
+			printPaths("D", drift.Deleted)
+		default:
+			utils.Log(fmt.Sprintf("%s: ok", drift.Name))
+		}
+	}
+	return code
+}
+
+// printPaths prints the paths with the status prefix, e.g. "  M tasks/main.yml"
+func printPaths(status string, paths []string) {
+	for _, path := range paths {
+		fmt.Println(" ", status, path)
+	}
+}
@@ -0,0 +1,328 @@
+// Package archive implements roles published as tarballs on plain HTTP servers (e.g. release artifacts),
+// with optional checksum pinning and version placeholders in the archive URL.
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etkecc/agru/internal/versions"
+)
+
+const (
+	// VersionPlaceholder is replaced with the entry's version in the archive URL
+	VersionPlaceholder = "{version}"
+	// checksumSHA256 is the only supported checksum algorithm
+	checksumSHA256 = "sha256"
+	// requestTimeout is the timeout for a single HTTP request
+	requestTimeout = 5 * time.Minute
+	// maxProbes limits the number of version bumps probed in a templated URL
+	maxProbes = 100
+)
+
+var (
+	// Extensions are the supported archive file extensions
+	Extensions = []string{".tar.gz", ".tgz", ".tar"}
+
+	// ErrChecksumMismatch is returned when the archive doesn't match its pinned checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	probeVersionRegex = regexp.MustCompile(`^(v?)(\d+(?:\.\d+)*)$`)
+	errNotFound       = errors.New("not found")
+)
+
+// Client downloads archives and checks for their newer versions
+type Client struct {
+	http *http.Client
+}
+
+// New creates a new archive client
+func New() *Client {
+	return &Client{http: &http.Client{Timeout: requestTimeout}}
+}
+
+// IsArchive checks if the src is an HTTP(S) URL of an archive
+func IsArchive(src string) bool {
+	if !strings.HasPrefix(src, "https://") && !strings.HasPrefix(src, "http://") {
+		return false
+	}
+	src, _, _ = strings.Cut(src, "?")
+	for _, ext := range Extensions {
+		if strings.HasSuffix(src, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns the archive URL with the version placeholder replaced by the version
+func URL(src, version string) string {
+	return strings.ReplaceAll(src, VersionPlaceholder, version)
+}
+
+// Name returns the role name, generated from the archive URL:
+// the file name without extension and version suffix, e.g. https://example.com/role-{version}.tar.gz -> role
+func Name(src, version string) string {
+	src, _, _ = strings.Cut(src, "?")
+	name := path.Base(src)
+	for _, ext := range Extensions {
+		name = strings.TrimSuffix(name, ext)
+	}
+	for _, suffix := range []string{VersionPlaceholder, version} {
+		if suffix == "" {
+			continue
+		}
+		for _, sep := range []string{"-", "_"} {
+			name = strings.TrimSuffix(name, sep+suffix)
+		}
+	}
+	return name
+}
+
+// Download downloads the archive from fileURL into dst and verifies it against the checksum (if set),
+// the checksum is in "algorithm:hex" format, e.g. sha256:abcd...
+func (c *Client) Download(ctx context.Context, fileURL, dst, checksum string) error {
+	expected, err := parseChecksum(checksum)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.get(ctx, http.MethodGet, fileURL)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", fileURL, err)
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(dst) //nolint:gosec // that's intended
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", dst, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		return fmt.Errorf("writing %s: %w", dst, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); expected != "" && sum != expected {
+		return fmt.Errorf("downloading %s: %w, expected %s:%s, got %s:%s", fileURL, ErrChecksumMismatch, checksumSHA256, expected, checksumSHA256, sum)
+	}
+	return file.Close()
+}
+
+// Verify verifies the archive file against the checksum (if set) in "algorithm:hex" format,
+// e.g. the cached archive before it's used without downloading
+func Verify(archivePath, checksum string) error {
+	expected, err := parseChecksum(checksum)
+	if err != nil || expected == "" {
+		return err
+	}
+
+	file, err := os.Open(archivePath) //nolint:gosec // that's intended
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", archivePath, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("reading %s: %w", archivePath, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != expected {
+		return fmt.Errorf("verifying %s: %w, expected %s:%s, got %s:%s", archivePath, ErrChecksumMismatch, checksumSHA256, expected, checksumSHA256, sum)
+	}
+	return nil
+}
+
+// Checksum downloads the archive from fileURL and returns its checksum in "sha256:hex" format
+func (c *Client) Checksum(ctx context.Context, fileURL string) (string, error) {
+	resp, err := c.get(ctx, http.MethodGet, fileURL)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %w", fileURL, err)
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", fmt.Errorf("downloading %s: %w", fileURL, err)
+	}
+	return checksumSHA256 + ":" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// NewVersion returns a newer version of the templated archive URL (with the version placeholder), allowed by the policy,
+// and the newest version held back by the policy, if any.
+// When index is set, the versions are taken from the archive file names, found on the index page
+// (e.g. a directory listing), otherwise the next major, minor and patch versions are probed in the templated URL.
+// Returns empty strings if there is no newer version.
+func (c *Client) NewVersion(ctx context.Context, src, index, current string, policy versions.Policy) (newVersion, heldBack string, err error) {
+	if !strings.Contains(src, VersionPlaceholder) || policy.Pin {
+		return "", "", nil
+	}
+
+	if index != "" {
+		found, err := c.indexVersions(ctx, src, index)
+		if err != nil {
+			return "", "", err
+		}
+		newVersion, heldBack = policy.Select(current, found)
+		return newVersion, heldBack, nil
+	}
+
+	base, err := c.probeVersion(ctx, src, current, policy)
+	if err != nil {
+		return "", "", err
+	}
+	if versions.Compare(base, current) > 0 {
+		newVersion = base
+	} else {
+		base = current
+	}
+	if policy.Update == "" || policy.Update == versions.UpdateMajor {
+		return newVersion, "", nil
+	}
+
+	unrestricted := policy
+	unrestricted.Update = versions.UpdateMajor
+	held, err := c.probeVersion(ctx, src, base, unrestricted)
+	if err != nil {
+		return "", "", err
+	}
+	if versions.Compare(held, base) > 0 {
+		heldBack = held
+	}
+	return newVersion, heldBack, nil
+}
+
+// indexVersions returns the versions of the archive, found on the index page
+func (c *Client) indexVersions(ctx context.Context, src, index string) ([]string, error) {
+	resp, err := c.get(ctx, http.MethodGet, index)
+	if err != nil {
+		return nil, fmt.Errorf("getting index %s: %w", index, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading index %s: %w", index, err)
+	}
+
+	src, _, _ = strings.Cut(src, "?")
+	pattern := strings.Replace(regexp.QuoteMeta(path.Base(src)), regexp.QuoteMeta(VersionPlaceholder), `(v?[0-9][A-Za-z0-9.+_-]*?)`, 1)
+	fileRegex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("parsing archive file name: %w", err)
+	}
+
+	found := []string{}
+	for _, match := range fileRegex.FindAllStringSubmatch(string(body), -1) {
+		found = append(found, match[1])
+	}
+	return found, nil
+}
+
+// probeVersion bumps the current version (major first, then minor, then patch, as far as the policy allows)
+// and checks if the archive with the bumped version exists, until no bump is found.
+// Only numeric versions (e.g. v1.2.3) can be probed
+func (c *Client) probeVersion(ctx context.Context, src, current string, policy versions.Policy) (string, error) {
+	match := probeVersionRegex.FindStringSubmatch(current)
+	if match == nil {
+		return "", nil
+	}
+	prefix, last := match[1], match[2]
+	for probes := 0; probes < maxProbes; {
+		bumped := false
+		for _, candidate := range bumps(last) {
+			if !policy.Allows(current, prefix+candidate) {
+				continue
+			}
+			probes++
+			ok, err := c.exists(ctx, URL(src, prefix+candidate))
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				last = candidate
+				bumped = true
+				break
+			}
+		}
+		if !bumped {
+			break
+		}
+	}
+	return prefix + last, nil
+}
+
+// exists checks if the file exists on the server
+func (c *Client) exists(ctx context.Context, fileURL string) (bool, error) {
+	resp, err := c.get(ctx, http.MethodHead, fileURL)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("checking %s: %w", fileURL, err)
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// get sends the request and checks the response status
+func (c *Client) get(ctx context.Context, method, fileURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, fileURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// bumps returns the next major, minor and patch (and so on) versions of the dot-separated numeric version,
+// e.g. 1.2.3 -> 2.0.0, 1.3.0, 1.2.4
+func bumps(version string) []string {
+	parts := strings.Split(version, ".")
+	result := make([]string, 0, len(parts))
+	for idx := range parts {
+		bumped := make([]string, len(parts))
+		copy(bumped, parts[:idx])
+		n, _ := strconv.Atoi(parts[idx]) //nolint:errcheck // validated by probeVersionRegex
+		bumped[idx] = strconv.Itoa(n + 1)
+		for j := idx + 1; j < len(parts); j++ {
+			bumped[j] = "0"
+		}
+		result = append(result, strings.Join(bumped, "."))
+	}
+	return result
+}
+
+// parseChecksum parses the "algorithm:hex" checksum and returns the hex part, or an empty string if the checksum is not set
+func parseChecksum(checksum string) (string, error) {
+	if checksum == "" {
+		return "", nil
+	}
+	algorithm, sum, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid checksum %q, expected %s:<hex>", checksum, checksumSHA256)
+	}
+	if !strings.EqualFold(algorithm, checksumSHA256) {
+		return "", fmt.Errorf("unsupported checksum algorithm %q, only %s is supported", algorithm, checksumSHA256)
+	}
+	return strings.ToLower(sum), nil
+}
@@ -0,0 +1,200 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/etkecc/agru/internal/versions"
+)
+
+// newTestServer returns a fake artifact server with role archives in the given versions and an index page
+func newTestServer(t *testing.T, available ...string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	index := "<html><body>"
+	for _, version := range available {
+		name := "role-" + version + ".tar.gz"
+		index += `<a href="` + name + `">` + name + "</a>\n"
+		mux.HandleFunc("/releases/"+name, func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("archive " + version))
+		})
+	}
+	index += "</body></html>"
+	mux.HandleFunc("/releases/{$}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(index))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func sha256sum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestIsArchive(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/role-1.2.3.tar.gz":           true,
+		"https://example.com/role-{version}.tgz":          true,
+		"http://example.com/role.tar?token=abc":           true,
+		"https://github.com/org/role.git":                 false,
+		"git+https://example.com/role-1.2.3.tar.gz":       false,
+		"geerlingguy.docker":                              false,
+		"https://example.com/role-1.2.3.zip":              false,
+		"/home/user/roles/role-1.2.3.tar.gz":              false,
+		"https://example.com/archive/role-1.2.3.tar.gz/x": false,
+	}
+	for src, expected := range tests {
+		if got := IsArchive(src); got != expected {
+			t.Errorf("IsArchive(%q) = %v, want %v", src, got, expected)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		src, version string
+		expected     string
+	}{
+		{"https://example.com/role-{version}.tar.gz", "1.2.3", "role"},
+		{"https://example.com/role_{version}.tgz", "1.2.3", "role"},
+		{"https://example.com/role-1.2.3.tar.gz", "1.2.3", "role"},
+		{"https://example.com/role-1.2.3.tar.gz", "", "role-1.2.3"},
+		{"https://example.com/role.tar?token=abc", "", "role"},
+	}
+	for _, tt := range tests {
+		if got := Name(tt.src, tt.version); got != tt.expected {
+			t.Errorf("Name(%q, %q) = %q, want %q", tt.src, tt.version, got, tt.expected)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	srv := newTestServer(t, "1.0.0")
+	c := New()
+	dst := filepath.Join(t.TempDir(), "role.tar.gz")
+	fileURL := srv.URL + "/releases/role-1.0.0.tar.gz"
+
+	if err := c.Download(t.Context(), fileURL, dst, sha256sum("archive 1.0.0")); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	content, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "archive 1.0.0" {
+		t.Errorf("Download() content = %q, want %q", content, "archive 1.0.0")
+	}
+
+	if err := c.Download(t.Context(), fileURL, dst, ""); err != nil {
+		t.Errorf("Download() without checksum error = %v", err)
+	}
+	if err := c.Download(t.Context(), fileURL, dst, sha256sum("something else")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Download() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if err := c.Download(t.Context(), fileURL, dst, "md5:abcd"); err == nil {
+		t.Error("Download() expected error for unsupported checksum algorithm, got nil")
+	}
+	if err := c.Download(t.Context(), srv.URL+"/releases/role-9.9.9.tar.gz", dst, ""); err == nil {
+		t.Error("Download() expected error for 404, got nil")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "role.tar.gz")
+	if err := os.WriteFile(archivePath, []byte("archive 1.0.0"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Verify(archivePath, sha256sum("archive 1.0.0")); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := Verify(archivePath, ""); err != nil {
+		t.Errorf("Verify() without checksum error = %v", err)
+	}
+	if err := Verify(archivePath, sha256sum("something else")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Verify() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if err := Verify(archivePath, "md5:abcd"); err == nil {
+		t.Error("Verify() expected error for unsupported checksum algorithm, got nil")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	srv := newTestServer(t, "1.0.0")
+	got, err := New().Checksum(t.Context(), srv.URL+"/releases/role-1.0.0.tar.gz")
+	if err != nil {
+		t.Fatalf("Checksum() error = %v", err)
+	}
+	if expected := sha256sum("archive 1.0.0"); got != expected {
+		t.Errorf("Checksum() = %q, want %q", got, expected)
+	}
+}
+
+func TestNewVersionIndex(t *testing.T) {
+	srv := newTestServer(t, "1.0.0", "1.10.0", "1.9.0", "2.0.0-rc.1")
+	c := New()
+	src := srv.URL + "/releases/role-{version}.tar.gz"
+
+	got, _, err := c.NewVersion(t.Context(), src, srv.URL+"/releases/", "1.0.0", versions.Policy{})
+	if err != nil {
+		t.Fatalf("NewVersion() error = %v", err)
+	}
+	if got != "1.10.0" {
+		t.Errorf("NewVersion() = %q, want 1.10.0 (latest stable)", got)
+	}
+
+	got, _, err = c.NewVersion(t.Context(), src, srv.URL+"/releases/", "1.10.0", versions.Policy{})
+	if err != nil {
+		t.Fatalf("NewVersion() error = %v", err)
+	}
+	if got != "" {
+		t.Errorf("NewVersion() = %q, want empty for the latest version", got)
+	}
+}
+
+func TestNewVersionProbe(t *testing.T) {
+	srv := newTestServer(t, "1.0.0", "1.0.1", "1.1.0", "1.1.1", "3.0.0")
+	c := New()
+	src := srv.URL + "/releases/role-{version}.tar.gz"
+
+	got, _, err := c.NewVersion(t.Context(), src, "", "1.0.0", versions.Policy{})
+	if err != nil {
+		t.Fatalf("NewVersion() error = %v", err)
+	}
+	if got != "1.1.1" {
+		t.Errorf("NewVersion() = %q, want 1.1.1 (3.0.0 is not reachable by bumps)", got)
+	}
+
+	if got, _, _ := c.NewVersion(t.Context(), srv.URL+"/releases/role-1.0.0.tar.gz", "", "1.0.0", versions.Policy{}); got != "" {
+		t.Errorf("NewVersion() = %q, want empty for URL without placeholder", got)
+	}
+}
+
+func TestNewVersionPolicy(t *testing.T) {
+	srv := newTestServer(t, "1.0.0", "1.0.1", "1.1.0", "2.0.0", "2.1.0")
+	c := New()
+	src := srv.URL + "/releases/role-{version}.tar.gz"
+
+	for _, index := range []string{"", srv.URL + "/releases/"} {
+		got, held, err := c.NewVersion(t.Context(), src, index, "1.0.0", versions.Policy{Update: versions.UpdatePatch})
+		if err != nil {
+			t.Fatalf("NewVersion(index=%q) error = %v", index, err)
+		}
+		if got != "1.0.1" || held != "2.1.0" {
+			t.Errorf("NewVersion(index=%q) = %q, %q, want 1.0.1, 2.1.0", index, got, held)
+		}
+
+		got, held, err = c.NewVersion(t.Context(), src, index, "1.0.0", versions.Policy{Pin: true})
+		if err != nil || got != "" || held != "" {
+			t.Errorf("NewVersion(index=%q) = %q, %q, %v, want no update for pinned version", index, got, held, err)
+		}
+	}
+}
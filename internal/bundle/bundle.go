@@ -0,0 +1,55 @@
+// Package bundle packs the installed roles and collections with their lockfile into a single tar archive,
+// so they can be installed on hosts without network access.
+package bundle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/tarball"
+)
+
+const (
+	// LockName is the lockfile of the bundled roles
+	LockName = "requirements.lock"
+	// RolesDir is the dir of the bundled roles, as installed (with meta/.galaxy_install_info)
+	RolesDir = "roles"
+	// CollectionsDir is the dir of the bundled collections, as installed (ansible_collections/namespace/name)
+	CollectionsDir = "collections"
+)
+
+// Pack writes the bundle archive to the path from the dir with the lockfile (LockName), the installed roles (RolesDir)
+// and the installed collections (CollectionsDir, if any)
+func Pack(dir, path string) error {
+	if _, err := models.ReadLock(filepath.Join(dir, LockName)); err != nil {
+		return err
+	}
+	names := []string{LockName, RolesDir}
+	if _, err := os.Stat(filepath.Join(dir, CollectionsDir)); err == nil {
+		names = append(names, CollectionsDir)
+	}
+	if err := tarball.Create(path, dir, names...); err != nil {
+		return fmt.Errorf("packing bundle: %w", err)
+	}
+	return nil
+}
+
+// Unpack extracts the bundle archive into a new tmp dir and returns it.
+// The caller should remove the dir when it's no longer needed
+func Unpack(path string) (string, error) {
+	dir, err := os.MkdirTemp("", "agru-bundle-*")
+	if err != nil {
+		return "", fmt.Errorf("creating tmp dir: %w", err)
+	}
+	if err := tarball.Extract(path, dir, 0); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("extracting bundle %s: %w", path, err)
+	}
+	if _, err := models.ReadLock(filepath.Join(dir, LockName)); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("invalid bundle %s: %w", path, err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,69 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/tarball"
+)
+
+func TestPackUnpack(t *testing.T) {
+	dir := t.TempDir()
+	lock := &models.Lock{Roles: []*models.LockedRole{{Name: "role", Src: "git+https://github.com/org/role.git", Version: "v1.0.0", Commit: "aaa"}}}
+	if err := lock.Write(filepath.Join(dir, LockName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, RolesDir, "role", "meta"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, RolesDir, "role", "meta", ".galaxy_install_info"), []byte("version: v1.0.0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	collectionPath := filepath.Join(dir, CollectionsDir, "ansible_collections", "community", "general")
+	if err := os.MkdirAll(collectionPath, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(collectionPath, "MANIFEST.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "bundle.tar")
+	if err := Pack(dir, path); err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+	unpacked, err := Unpack(path)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	defer os.RemoveAll(unpacked)
+	data, err := os.ReadFile(filepath.Join(unpacked, RolesDir, "role", "meta", ".galaxy_install_info"))
+	if err != nil || string(data) != "version: v1.0.0\n" {
+		t.Errorf("Unpack() install info = %q, %v, want the bundled one", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(unpacked, CollectionsDir, "ansible_collections", "community", "general", "MANIFEST.json")); err != nil {
+		t.Errorf("Unpack() should unpack the bundled collections: %v", err)
+	}
+}
+
+func TestPackWithoutLock(t *testing.T) {
+	if err := Pack(t.TempDir(), filepath.Join(t.TempDir(), "bundle.tar")); err == nil {
+		t.Error("Pack() error = nil, want error for missing lockfile")
+	}
+}
+
+func TestUnpackInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a bundle\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "other.tar")
+	if err := tarball.Create(path, dir, "README.md"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Unpack(path); err == nil {
+		t.Error("Unpack() error = nil, want error for archive without lockfile")
+	}
+}
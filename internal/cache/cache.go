@@ -0,0 +1,387 @@
+// Package cache keeps bare mirrors of the git repos and downloaded archives on disk,
+// so the repos are fetched incrementally between runs, roles that share a repo are fetched once per run,
+// and roles can be installed offline.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etkecc/agru/internal/giturl"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+const (
+	// OfflineOff fetches the mirrors and downloads the archives, default
+	OfflineOff = "off"
+	// OfflineOn uses only the cached mirrors and archives, without network access
+	OfflineOn = "on"
+	// OfflineAuto fetches the mirrors and downloads the archives, and falls back to the cached ones on errors
+	// (only on network errors for the archives)
+	OfflineAuto = "auto"
+
+	// KindGit is a bare mirror of a git repo
+	KindGit = "git"
+	// KindArchive is a downloaded archive
+	KindArchive = "archive"
+
+	// metaSuffix is the suffix of the cached archive's metadata file, see MetaPath
+	metaSuffix = ".meta"
+)
+
+var (
+	// unsafeChars are replaced in the archive file names
+	unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	// ErrNotCached is returned in offline mode, when the repo, version or archive is not cached
+	ErrNotCached = errors.New("not cached, can't install offline")
+)
+
+// Item is a cached git mirror or archive
+type Item struct {
+	Kind     string    // KindGit or KindArchive
+	Source   string    // remote URL of the git repo, or the file name of the archive
+	Path     string    // path on disk
+	Size     int64     // size on disk, in bytes
+	LastUsed time.Time // last time the item was used
+}
+
+// Cache is a dir with bare mirrors of the git repos (in the git subdir) and downloaded archives (in the archive subdir)
+type Cache struct {
+	runner  runner.Runner
+	dir     string
+	offline string
+
+	mu      sync.Mutex
+	locks   map[string]*sync.Mutex // per-repo and per-archive locks
+	fetched map[string]bool        // repos fetched during this run
+}
+
+// DefaultDir returns the default cache dir: $XDG_CACHE_HOME/agru (or its OS-specific equivalent),
+// empty if the user's cache dir is unknown
+func DefaultDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "agru")
+}
+
+// New creates a new cache in the dir, offline is one of the Offline* modes (OfflineOff if empty)
+func New(r runner.Runner, dir, offline string) *Cache {
+	if offline == "" {
+		offline = OfflineOff
+	}
+	return &Cache{
+		runner:  r,
+		dir:     dir,
+		offline: offline,
+		locks:   make(map[string]*sync.Mutex),
+		fetched: make(map[string]bool),
+	}
+}
+
+// Dir returns the cache dir
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Offline returns the offline mode of the cache
+func (c *Cache) Offline() string {
+	return c.offline
+}
+
+// Mirror returns the dir of the repo's bare mirror, cloning it on the first use,
+// or fetching the new refs (once per run, even if many roles share the same repo).
+// In offline mode the cached mirror is used as-is
+func (c *Cache) Mirror(ctx context.Context, repo string) (string, error) {
+	unlock := c.lock(KindGit + ":" + repo)
+	defer unlock()
+
+	dir := c.mirrorPath(repo)
+	cached := exists(filepath.Join(dir, "HEAD"))
+	switch {
+	case c.offline == OfflineOn && !cached:
+		return "", fmt.Errorf("repo %s is %w", repo, ErrNotCached)
+	case c.offline == OfflineOn || c.isFetched(repo):
+		return dir, touch(dir)
+	}
+
+	if err := c.fetch(ctx, repo, dir, cached); err != nil {
+		if c.offline != OfflineAuto || !cached {
+			return "", err
+		}
+	}
+	c.setFetched(repo)
+	return dir, touch(dir)
+}
+
+// FetchCommit fetches the commit that is not reachable from the mirrored refs (e.g. from a deleted branch) into the repo's mirror
+func (c *Cache) FetchCommit(ctx context.Context, repo, commit string) error {
+	if c.offline == OfflineOn {
+		return fmt.Errorf("commit %s of %s is %w", commit, repo, ErrNotCached)
+	}
+	unlock := c.lock(KindGit + ":" + repo)
+	defer unlock()
+
+	if _, err := c.runner.Run(ctx, runner.Cmd(c.mirrorPath(repo), "git", "fetch", "-q", "origin", commit)); err != nil {
+		return fmt.Errorf("fetching commit %s: %w", commit, err)
+	}
+	return nil
+}
+
+// Archive returns the path to the cached archive, downloaded by the download func.
+// key identifies the archive (e.g. its URL), and its last element is used as the file name.
+// In offline mode the cached archive is used without downloading, and in auto mode it's used when the download fails
+// with a network error. The cached archive is checked with the verify func (optional, e.g. against the pinned checksum)
+// before it's used without downloading
+func (c *Cache) Archive(key string, download func(dst string) error, verify func(archivePath string) error) (string, error) {
+	unlock := c.lock(KindArchive + ":" + key)
+	defer unlock()
+
+	archivePath := c.archivePath(key)
+	cached := exists(archivePath)
+	if c.offline == OfflineOn {
+		if !cached {
+			return "", fmt.Errorf("archive %s is %w", key, ErrNotCached)
+		}
+		return c.cachedArchive(archivePath, verify)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0o700); err != nil {
+		return "", fmt.Errorf("creating cache dir: %w", err)
+	}
+	tmpPath := archivePath + ".tmp"
+	if err := download(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		if c.offline == OfflineAuto && cached && isNetworkError(err) {
+			return c.cachedArchive(archivePath, verify)
+		}
+		return "", err
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		return "", fmt.Errorf("caching archive: %w", err)
+	}
+	return archivePath, nil
+}
+
+// MetaPath returns the path of the cached archive's metadata file (e.g. its resolved version and checksum),
+// written by the caller next to the archive, and pruned together with it
+func MetaPath(archivePath string) string {
+	return archivePath + metaSuffix
+}
+
+// List returns all cached mirrors and archives, sorted by kind and source
+func (c *Cache) List(ctx context.Context) ([]Item, error) {
+	mirrors, err := c.list(KindGit, func(dir string) string {
+		repo, err := c.runner.Run(ctx, runner.Cmd(dir, "git", "config", "--get", "remote.origin.url"))
+		if err != nil {
+			return "(unknown)"
+		}
+		return strings.TrimSpace(repo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	archives, err := c.list(KindArchive, func(archivePath string) string {
+		_, name, _ := strings.Cut(filepath.Base(archivePath), "-") // drop the hash prefix
+		return name
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(mirrors, archives...), nil
+}
+
+// Prune removes the mirrors and archives that were not used for longer than maxAge, and returns them
+func (c *Cache) Prune(ctx context.Context, maxAge time.Duration) ([]Item, error) {
+	items, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pruned := make([]Item, 0, len(items))
+	for _, item := range items {
+		if time.Since(item.LastUsed) <= maxAge {
+			continue
+		}
+		if err := os.RemoveAll(item.Path); err != nil {
+			return pruned, fmt.Errorf("removing %s: %w", item.Path, err)
+		}
+		if item.Kind == KindArchive {
+			if err := os.RemoveAll(MetaPath(item.Path)); err != nil {
+				return pruned, fmt.Errorf("removing %s: %w", MetaPath(item.Path), err)
+			}
+		}
+		pruned = append(pruned, item)
+	}
+	return pruned, nil
+}
+
+// Clean removes the whole cache dir
+func (c *Cache) Clean() error {
+	if err := os.RemoveAll(c.dir); err != nil {
+		return fmt.Errorf("removing cache dir: %w", err)
+	}
+	return nil
+}
+
+// cachedArchive verifies the cached archive (if the verify func is set) and marks it as used
+func (c *Cache) cachedArchive(archivePath string, verify func(archivePath string) error) (string, error) {
+	if verify != nil {
+		if err := verify(archivePath); err != nil {
+			return "", fmt.Errorf("cached archive: %w", err)
+		}
+	}
+	return archivePath, touch(archivePath)
+}
+
+// fetch clones the repo's mirror, or fetches the new refs into the existing one.
+// The partially cloned mirror is removed on errors (e.g. on cancellation) and before the retries of the clone
+func (c *Cache) fetch(ctx context.Context, repo, dir string, cached bool) error {
+	if cached {
+		if _, err := c.runner.Run(ctx, runner.Cmd(dir, "git", "fetch", "-q", "--prune", "origin")); err != nil {
+			return fmt.Errorf("fetching mirror: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0o700); err != nil {
+		return fmt.Errorf("creating cache dir: %w", err)
+	}
+	if err := os.RemoveAll(dir); err != nil { // leftovers of an interrupted clone
+		return fmt.Errorf("removing broken mirror: %w", err)
+	}
+	cmd := runner.Cmd("", "git", "clone", "-q", "--mirror", repo, dir)
+	cmd.BeforeRetry = func() error { return os.RemoveAll(dir) }
+	if _, err := c.runner.Run(ctx, cmd); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("cloning mirror: %w", err)
+	}
+	return nil
+}
+
+// list returns the items of the kind's subdir, with the sources returned by the source func
+func (c *Cache) list(kind string, source func(itemPath string) string) ([]Item, error) {
+	dir := filepath.Join(c.dir, kind)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading cache dir: %w", err)
+	}
+
+	items := make([]Item, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") || strings.HasSuffix(entry.Name(), metaSuffix) {
+			continue
+		}
+		itemPath := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(itemPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", itemPath, err)
+		}
+		size, err := diskSize(itemPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", itemPath, err)
+		}
+		items = append(items, Item{Kind: kind, Source: source(itemPath), Path: itemPath, Size: size, LastUsed: info.ModTime()})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Source < items[j].Source
+	})
+	return items, nil
+}
+
+// mirrorPath returns the dir of the repo's mirror: the repo name and the URL's hash, e.g. git/traefik-0123456789ab.git
+func (c *Cache) mirrorPath(repo string) string {
+	return filepath.Join(c.dir, KindGit, giturl.Name(repo)+"-"+hash(repo)+".git")
+}
+
+// archivePath returns the path of the cached archive: the key's hash and its last element, e.g. archive/0123456789ab-role-1.0.0.tar.gz
+func (c *Cache) archivePath(key string) string {
+	return filepath.Join(c.dir, KindArchive, hash(key)+"-"+unsafeChars.ReplaceAllString(path.Base(key), "_"))
+}
+
+// lock locks the key (e.g. a repo), so the same mirror or archive is not written concurrently, and returns the unlock func
+func (c *Cache) lock(key string) func() {
+	c.mu.Lock()
+	lock, ok := c.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[key] = lock
+	}
+	c.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// isFetched checks if the repo was already fetched during this run
+func (c *Cache) isFetched(repo string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetched[repo]
+}
+
+// setFetched marks the repo as fetched during this run
+func (c *Cache) setFetched(repo string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetched[repo] = true
+}
+
+// hash returns the short hash of the string, used in the cached items' names
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:6])
+}
+
+// isNetworkError checks if the download failed because of the network (e.g. DNS, refused connection or timeout),
+// and not because of the archive itself (e.g. checksum mismatch or missing version)
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// exists checks if the path exists
+func exists(itemPath string) bool {
+	_, err := os.Stat(itemPath)
+	return err == nil
+}
+
+// touch updates the item's last used time
+func touch(itemPath string) error {
+	now := time.Now()
+	if err := os.Chtimes(itemPath, now, now); err != nil {
+		return fmt.Errorf("updating cache: %w", err)
+	}
+	return nil
+}
+
+// diskSize returns the total size of the regular files in the path (a file or a dir)
+func diskSize(itemPath string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(itemPath, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etkecc/agru/internal/runner"
+)
+
+// countingRunner runs the commands with the shell runner and records them
+type countingRunner struct {
+	runner runner.Runner
+	calls  []string
+}
+
+func (r *countingRunner) Run(ctx context.Context, cmd runner.Command) (string, error) {
+	r.calls = append(r.calls, cmd.String())
+	return r.runner.Run(ctx, cmd)
+}
+
+func (r *countingRunner) count(prefix string) int {
+	var count int
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// runnerFunc is a Runner func
+type runnerFunc func(ctx context.Context, cmd runner.Command) (string, error)
+
+func (f runnerFunc) Run(ctx context.Context, cmd runner.Command) (string, error) {
+	return f(ctx, cmd)
+}
+
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func makeRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("v1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "init", "-q")
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "init")
+	git(t, dir, "tag", "v1.0.0")
+	return dir
+}
+
+func TestMirror(t *testing.T) {
+	repo := makeRepo(t)
+	r := &countingRunner{runner: runner.New(0)}
+	c := New(r, filepath.Join(t.TempDir(), "cache"), OfflineOff)
+
+	dir, err := c.Mirror(t.Context(), repo)
+	if err != nil {
+		t.Fatalf("Mirror() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		t.Fatalf("Mirror() should clone a bare mirror: %v", err)
+	}
+	if _, err := c.Mirror(t.Context(), repo); err != nil { // the same run, another role from the same repo
+		t.Fatalf("Mirror() second call error = %v", err)
+	}
+	if r.count("git clone") != 1 || r.count("git fetch") != 0 {
+		t.Errorf("Mirror() should clone the repo once per run, calls: %v", r.calls)
+	}
+
+	// next run fetches only the new refs
+	if err := os.WriteFile(filepath.Join(repo, "README.md"), []byte("v2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git(t, repo, "commit", "-q", "-am", "v2")
+	git(t, repo, "tag", "v2.0.0")
+	c = New(r, c.Dir(), OfflineOff)
+	if _, err := c.Mirror(t.Context(), repo); err != nil {
+		t.Fatalf("Mirror() next run error = %v", err)
+	}
+	if r.count("git clone") != 1 || r.count("git fetch") != 1 {
+		t.Errorf("Mirror() should fetch the existing mirror, calls: %v", r.calls)
+	}
+	if _, err := r.Run(t.Context(), runner.Cmd(dir, "git", "rev-parse", "-q", "--verify", "v2.0.0")); err != nil {
+		t.Errorf("Mirror() should fetch the new tags: %v", err)
+	}
+}
+
+func TestMirrorOffline(t *testing.T) {
+	repo := makeRepo(t)
+	r := &countingRunner{runner: runner.New(0)}
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	if _, err := New(r, dir, OfflineOn).Mirror(t.Context(), repo); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("Mirror() offline error = %v, want %v", err, ErrNotCached)
+	}
+	if _, err := New(r, dir, OfflineOff).Mirror(t.Context(), repo); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := len(r.calls)
+	if _, err := New(r, dir, OfflineOn).Mirror(t.Context(), repo); err != nil {
+		t.Fatalf("Mirror() offline error = %v", err)
+	}
+	if len(r.calls) != calls {
+		t.Errorf("Mirror() offline should not fetch, calls: %v", r.calls[calls:])
+	}
+	if err := New(r, dir, OfflineOn).FetchCommit(t.Context(), repo, "0123456789012345678901234567890123456789"); !errors.Is(err, ErrNotCached) {
+		t.Errorf("FetchCommit() offline error = %v, want %v", err, ErrNotCached)
+	}
+
+	// the remote is gone
+	if err := os.RemoveAll(repo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(r, dir, OfflineOff).Mirror(t.Context(), repo); err == nil {
+		t.Error("Mirror() error = nil, want fetch error")
+	}
+	if _, err := New(r, dir, OfflineAuto).Mirror(t.Context(), repo); err != nil {
+		t.Errorf("Mirror() auto should fall back to the cached mirror, error = %v", err)
+	}
+}
+
+func TestMirrorRetriesPartialClone(t *testing.T) {
+	repo := makeRepo(t)
+	shell := runner.New(0)
+	var clones int
+	// the first clone times out and leaves the partially cloned mirror behind
+	flaky := runner.NewRetryRunner(runnerFunc(func(ctx context.Context, cmd runner.Command) (string, error) {
+		if cmd.Args[1] == "clone" {
+			if clones++; clones == 1 {
+				if err := os.MkdirAll(filepath.Join(cmd.Args[len(cmd.Args)-1], "objects"), 0o700); err != nil {
+					return "", err
+				}
+				return "", &runner.Error{Command: cmd.String(), Err: fmt.Errorf("timed out after 1s: %w", context.DeadlineExceeded)}
+			}
+		}
+		return shell.Run(ctx, cmd)
+	}), runner.RetryPolicy{Retries: 2}, nil)
+
+	dir, err := New(flaky, filepath.Join(t.TempDir(), "cache"), OfflineOff).Mirror(t.Context(), repo)
+	if err != nil {
+		t.Fatalf("Mirror() error = %v", err)
+	}
+	if clones != 2 {
+		t.Errorf("Mirror() cloned %d times, want 2", clones)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		t.Errorf("Mirror() should clone the mirror on retry: %v", err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	key := "https://example.com/roles/role-1.0.0.tar.gz"
+	var downloads int
+	download := func(dst string) error {
+		downloads++
+		return os.WriteFile(dst, []byte("archive"), 0o600)
+	}
+	fail := func(string) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("network is unreachable")}
+	}
+	mismatch := func(string) error {
+		return errors.New("checksum mismatch")
+	}
+
+	if _, err := New(nil, dir, OfflineOn).Archive(key, download, nil); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("Archive() offline error = %v, want %v", err, ErrNotCached)
+	}
+	archivePath, err := New(nil, dir, OfflineOff).Archive(key, download, nil)
+	if err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+	if !strings.HasSuffix(archivePath, "-role-1.0.0.tar.gz") {
+		t.Errorf("Archive() = %q, want the archive's file name", archivePath)
+	}
+
+	if got, err := New(nil, dir, OfflineOn).Archive(key, download, nil); err != nil || got != archivePath {
+		t.Errorf("Archive() offline = %q, %v, want %q", got, err, archivePath)
+	}
+	if downloads != 1 {
+		t.Errorf("Archive() offline should not download, downloads = %d", downloads)
+	}
+	if _, err := New(nil, dir, OfflineOff).Archive(key, fail, nil); err == nil {
+		t.Error("Archive() error = nil, want download error")
+	}
+	if got, err := New(nil, dir, OfflineAuto).Archive(key, fail, nil); err != nil || got != archivePath {
+		t.Errorf("Archive() auto = %q, %v, want the cached %q", got, err, archivePath)
+	}
+	if _, err := New(nil, dir, OfflineAuto).Archive(key, mismatch, nil); err == nil {
+		t.Error("Archive() auto error = nil, want to fall back to the cached archive only on network errors")
+	}
+	if _, err := New(nil, dir, OfflineOn).Archive(key, download, mismatch); err == nil {
+		t.Error("Archive() offline error = nil, want the verification error of the cached archive")
+	}
+	if _, err := New(nil, dir, OfflineAuto).Archive(key, fail, mismatch); err == nil {
+		t.Error("Archive() auto error = nil, want the verification error of the cached archive")
+	}
+
+	if err := os.WriteFile(MetaPath(archivePath), []byte("version: 1.0.0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	items, err := New(nil, dir, OfflineOff).List(t.Context())
+	if err != nil || len(items) != 1 || items[0].Kind != KindArchive || items[0].Source != "role-1.0.0.tar.gz" {
+		t.Errorf("List() = %+v, %v, want the cached archive without its metadata", items, err)
+	}
+	if _, err := New(nil, dir, OfflineOff).Prune(t.Context(), 0); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if _, err := os.Stat(MetaPath(archivePath)); !os.IsNotExist(err) {
+		t.Errorf("Prune() should remove the archive's metadata, got: %v", err)
+	}
+}
+
+func TestListPruneClean(t *testing.T) {
+	repoA, repoB := makeRepo(t), makeRepo(t)
+	c := New(runner.New(0), filepath.Join(t.TempDir(), "cache"), OfflineOff)
+	dirA, err := c.Mirror(t.Context(), repoA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Mirror(t.Context(), repoB); err != nil {
+		t.Fatal(err)
+	}
+
+	mirrors, err := c.List(t.Context())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(mirrors) != 2 || mirrors[0].Size == 0 {
+		t.Fatalf("List() = %+v, want 2 non-empty mirrors", mirrors)
+	}
+	for _, mirror := range mirrors {
+		if mirror.Source != repoA && mirror.Source != repoB {
+			t.Errorf("List() repo = %q, want one of the mirrored repos", mirror.Source)
+		}
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(dirA, old, old); err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := c.Prune(t.Context(), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if len(pruned) != 1 || pruned[0].Source != repoA {
+		t.Errorf("Prune() = %+v, want only %s", pruned, repoA)
+	}
+	if _, err := os.Stat(dirA); !os.IsNotExist(err) {
+		t.Errorf("Prune() should remove the mirror dir, got: %v", err)
+	}
+
+	if err := c.Clean(); err != nil {
+		t.Fatalf("Clean() error = %v", err)
+	}
+	if mirrors, err := c.List(t.Context()); err != nil || len(mirrors) != 0 {
+		t.Errorf("List() after Clean() = %+v, %v, want empty", mirrors, err)
+	}
+}
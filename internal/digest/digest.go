@@ -0,0 +1,126 @@
+// Package digest computes content digests of the installed role trees (file paths, modes and hashes),
+// so local modifications of the installed roles can be detected.
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// File is a single file of the tree
+type File struct {
+	Path string `yaml:"path"`   // slash-separated path, relative to the tree's root
+	Mode string `yaml:"mode"`   // permission bits in octal, or "symlink"
+	Hash string `yaml:"sha256"` // sha256 of the file's content, or of the symlink's target
+}
+
+// Tree returns the files of the dir tree (sorted by path, dirs are not included),
+// skipping the excluded slash-separated relative paths
+func Tree(dir string, exclude ...string) ([]File, error) {
+	excluded := make(map[string]bool, len(exclude))
+	for _, path := range exclude {
+		excluded[path] = true
+	}
+
+	var files []File
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() || excluded[rel] {
+			return nil
+		}
+
+		file, err := hashFile(path, d)
+		if err != nil {
+			return fmt.Errorf("hashing %s: %w", rel, err)
+		}
+		if file != nil {
+			file.Path = rel
+			files = append(files, *file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// Sum returns the digest of the whole tree, e.g. sha256:abcd...
+func Sum(files []File) string {
+	hash := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(hash, "%s %s %s\n", file.Mode, file.Hash, file.Path)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// Diff compares the recorded files with the current ones,
+// and returns the paths of the modified (content or mode), added, and deleted files
+func Diff(recorded, current []File) (modified, added, deleted []string) {
+	recordedMap := make(map[string]File, len(recorded))
+	for _, file := range recorded {
+		recordedMap[file.Path] = file
+	}
+	for _, file := range current {
+		old, ok := recordedMap[file.Path]
+		switch {
+		case !ok:
+			added = append(added, file.Path)
+		case old != file:
+			modified = append(modified, file.Path)
+		}
+		delete(recordedMap, file.Path)
+	}
+	for _, file := range recorded {
+		if _, ok := recordedMap[file.Path]; ok {
+			deleted = append(deleted, file.Path)
+		}
+	}
+	return modified, added, deleted
+}
+
+// hashFile returns the mode and hash of the regular file or symlink, nil for other file types (sockets, devices, etc.)
+func hashFile(path string, d fs.DirEntry) (*File, error) {
+	info, err := d.Info()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		io.WriteString(hash, target) //nolint:errcheck // hash writes never fail
+		return &File{Mode: "symlink", Hash: hex.EncodeToString(hash.Sum(nil))}, nil
+	case info.Mode().IsRegular():
+		f, err := os.Open(path) //nolint:gosec // that's intended
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := io.Copy(hash, f); err != nil {
+			return nil, err
+		}
+		return &File{Mode: fmt.Sprintf("%04o", info.Mode().Perm()), Hash: hex.EncodeToString(hash.Sum(nil))}, nil
+	default:
+		return nil, nil
+	}
+}
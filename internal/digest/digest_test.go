@@ -0,0 +1,92 @@
+package digest
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTree(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"tasks/main.yml":            "- debug: msg=hi\n",
+		"meta/main.yml":             "galaxy_info: {}\n",
+		"meta/.galaxy_install_info": "version: v1.0.0\n",
+	})
+	if err := os.Symlink("main.yml", filepath.Join(dir, "tasks", "link.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Tree(dir, "meta/.galaxy_install_info")
+	if err != nil {
+		t.Fatalf("Tree() error = %v", err)
+	}
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	expected := []string{"meta/main.yml", "tasks/link.yml", "tasks/main.yml"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("Tree() paths = %v, want %v", paths, expected)
+	}
+	if files[0].Mode != "0600" {
+		t.Errorf("Tree() mode = %q, want %q", files[0].Mode, "0600")
+	}
+	if files[1].Mode != "symlink" {
+		t.Errorf("Tree() symlink mode = %q, want %q", files[1].Mode, "symlink")
+	}
+}
+
+func TestSum(t *testing.T) {
+	files := []File{{Path: "a", Mode: "0644", Hash: "abc"}}
+	if Sum(files) != Sum([]File{{Path: "a", Mode: "0644", Hash: "abc"}}) {
+		t.Error("Sum() should be stable")
+	}
+	if Sum(files) == Sum([]File{{Path: "a", Mode: "0755", Hash: "abc"}}) {
+		t.Error("Sum() should change with the mode")
+	}
+	if Sum(files) == Sum(nil) {
+		t.Error("Sum() should change with the files list")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	recorded := []File{
+		{Path: "same", Mode: "0644", Hash: "1"},
+		{Path: "content", Mode: "0644", Hash: "2"},
+		{Path: "mode", Mode: "0644", Hash: "3"},
+		{Path: "deleted", Mode: "0644", Hash: "4"},
+	}
+	current := []File{
+		{Path: "added", Mode: "0644", Hash: "5"},
+		{Path: "content", Mode: "0644", Hash: "changed"},
+		{Path: "mode", Mode: "0755", Hash: "3"},
+		{Path: "same", Mode: "0644", Hash: "1"},
+	}
+
+	modified, added, deleted := Diff(recorded, current)
+	if !slices.Equal(modified, []string{"content", "mode"}) {
+		t.Errorf("Diff() modified = %v, want [content mode]", modified)
+	}
+	if !slices.Equal(added, []string{"added"}) {
+		t.Errorf("Diff() added = %v, want [added]", added)
+	}
+	if !slices.Equal(deleted, []string{"deleted"}) {
+		t.Errorf("Diff() deleted = %v, want [deleted]", deleted)
+	}
+}
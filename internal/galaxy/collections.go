@@ -0,0 +1,160 @@
+package galaxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/etkecc/agru/internal/versions"
+)
+
+// CollectionVersion is a single version of a collection, as returned by the Galaxy v3 API
+type CollectionVersion struct {
+	Namespace   string
+	Name        string
+	Version     string
+	DownloadURL string
+	SHA256      string
+	VersionURL  string
+	Server      string // URL of the server the version was resolved on
+}
+
+// collectionVersionsPage is a single page of the v3 collection versions list
+type collectionVersionsPage struct {
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+	Data []struct {
+		Version string `json:"version"`
+	} `json:"data"`
+}
+
+// collectionVersionDetail is the v3 collection version response
+type collectionVersionDetail struct {
+	Version     string `json:"version"`
+	DownloadURL string `json:"download_url"`
+	Href        string `json:"href"`
+	Artifact    struct {
+		SHA256 string `json:"sha256"`
+	} `json:"artifact"`
+	Namespace struct {
+		Name string `json:"name"`
+	} `json:"namespace"`
+	Collection struct {
+		Name string `json:"name"`
+	} `json:"collection"`
+}
+
+// CollectionVersions returns all available versions of the namespace.name collection,
+// from the first server (in priority order) that has the collection.
+// source is either a server id from ANSIBLE_GALAXY_SERVER_LIST, a server URL, or empty to use all configured servers
+func (c *Client) CollectionVersions(ctx context.Context, source, namespace, name string) ([]string, error) {
+	for _, srv := range c.serversFor(source) {
+		list, err := c.collectionVersions(ctx, srv, namespace, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return list, err
+	}
+	return nil, fmt.Errorf("collection %s.%s not found on any galaxy server", namespace, name)
+}
+
+// CollectionVersion returns the highest version of the namespace.name collection that satisfies the constraint.
+// Servers are checked in priority order, the first one that has a matching version wins.
+// Pre-releases are considered only when the constraint pins the exact version, same as ansible-galaxy does.
+// source is either a server id from ANSIBLE_GALAXY_SERVER_LIST, a server URL, or empty to use all configured servers
+func (c *Client) CollectionVersion(ctx context.Context, source, namespace, name, constraint string) (CollectionVersion, error) {
+	for _, srv := range c.serversFor(source) {
+		list, err := c.collectionVersions(ctx, srv, namespace, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return CollectionVersion{}, err
+		}
+		version := latestSatisfying(list, constraint)
+		if version == "" {
+			continue
+		}
+		return c.collectionVersion(ctx, srv, namespace, name, version)
+	}
+	return CollectionVersion{}, fmt.Errorf("collection %s.%s:%s not found on any galaxy server", namespace, name, constraint)
+}
+
+// collectionVersions returns all available versions of the namespace.name collection on the server
+func (c *Client) collectionVersions(ctx context.Context, srv *server, namespace, name string) ([]string, error) {
+	v3, err := c.apiURL(ctx, srv, "v3")
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	next := v3 + "collections/" + url.PathEscape(namespace) + "/" + url.PathEscape(name) + "/versions/?limit=100"
+	for next != "" {
+		var resp collectionVersionsPage
+		if err := c.getJSON(ctx, srv, next, &resp); err != nil {
+			return nil, fmt.Errorf("getting %s.%s versions: %w", namespace, name, err)
+		}
+		for _, item := range resp.Data {
+			list = append(list, item.Version)
+		}
+		next = resp.Links.Next
+		if next != "" {
+			next = srv.resolve(next)
+		}
+	}
+	return list, nil
+}
+
+// collectionVersion returns details of the specific namespace.name collection version on the server
+func (c *Client) collectionVersion(ctx context.Context, srv *server, namespace, name, version string) (CollectionVersion, error) {
+	v3, err := c.apiURL(ctx, srv, "v3")
+	if err != nil {
+		return CollectionVersion{}, err
+	}
+	versionURL := v3 + "collections/" + url.PathEscape(namespace) + "/" + url.PathEscape(name) + "/versions/" + url.PathEscape(version) + "/"
+	var resp collectionVersionDetail
+	if err := c.getJSON(ctx, srv, versionURL, &resp); err != nil {
+		return CollectionVersion{}, fmt.Errorf("getting %s.%s:%s: %w", namespace, name, version, err)
+	}
+	if resp.DownloadURL == "" || resp.Artifact.SHA256 == "" {
+		return CollectionVersion{}, fmt.Errorf("getting %s.%s:%s: download_url or artifact sha256 is missing", namespace, name, version)
+	}
+	return CollectionVersion{
+		Namespace:   namespace,
+		Name:        name,
+		Version:     resp.Version,
+		DownloadURL: srv.resolve(resp.DownloadURL),
+		SHA256:      resp.Artifact.SHA256,
+		VersionURL:  versionURL,
+		Server:      srv.URL,
+	}, nil
+}
+
+// serversFor returns servers to resolve collections on, in priority order
+func (c *Client) serversFor(source string) []*server {
+	if source == "" {
+		return c.servers
+	}
+	for _, srv := range c.servers {
+		if srv.ID == source || srv.URL == newServer(Server{URL: source}).URL {
+			return []*server{srv}
+		}
+	}
+	return []*server{newServer(Server{URL: source})}
+}
+
+// latestSatisfying returns the highest version from the list that satisfies the constraint
+func latestSatisfying(list []string, constraint string) string {
+	exact := versions.IsExact(constraint)
+	candidates := make([]string, 0, len(list))
+	for _, version := range list {
+		if versions.IsPrerelease(version) && !exact {
+			continue
+		}
+		if versions.Satisfies(version, constraint) {
+			candidates = append(candidates, version)
+		}
+	}
+	return versions.Latest(candidates)
+}
@@ -0,0 +1,151 @@
+package galaxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newCollectionServer returns a fake Galaxy v3 server with a single community.general collection,
+// versions split across 2 pages
+func newCollectionServer(t *testing.T, list string) *httptest.Server {
+	t.Helper()
+	sum := sha256.Sum256([]byte("collection"))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"available_versions": {"v3": "v3/"}}`))
+	})
+	mux.HandleFunc("/api/v3/collections/community/general/versions/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") == "100" {
+			w.Write([]byte(`{"links": {"next": null}, "data": [{"version": "8.0.0"}]}`))
+			return
+		}
+		w.Write([]byte(`{"links": {"next": "/api/v3/collections/community/general/versions/?limit=100&offset=100"}, "data": ` + list + `}`))
+	})
+	mux.HandleFunc("/api/v3/collections/community/general/versions/{version}/", func(w http.ResponseWriter, r *http.Request) {
+		version := r.PathValue("version")
+		w.Write([]byte(`{"version": "` + version + `", "download_url": "/download/community-general-` + version + `.tar.gz", "artifact": {"sha256": "` + hex.EncodeToString(sum[:]) + `"}}`))
+	})
+	mux.HandleFunc("/download/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("collection"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCollectionVersions(t *testing.T) {
+	srv := newCollectionServer(t, `[{"version": "7.0.0"}, {"version": "9.0.0-rc.1"}]`)
+	c := New(Server{URL: srv.URL})
+
+	list, err := c.CollectionVersions(t.Context(), "", "community", "general")
+	if err != nil {
+		t.Fatalf("CollectionVersions() error = %v", err)
+	}
+	if len(list) != 3 {
+		t.Errorf("CollectionVersions() = %v, want 3 versions (both pages)", list)
+	}
+
+	if _, err := c.CollectionVersions(t.Context(), "", "nobody", "nothing"); err == nil {
+		t.Error("CollectionVersions() expected error for missing collection, got nil")
+	}
+}
+
+func TestCollectionVersion(t *testing.T) {
+	srv := newCollectionServer(t, `[{"version": "7.0.0"}, {"version": "7.5.0"}, {"version": "9.0.0-rc.1"}]`)
+	c := New(Server{URL: srv.URL})
+
+	tests := []struct {
+		constraint string
+		expected   string
+	}{
+		{"", "8.0.0"},
+		{"*", "8.0.0"},
+		{">=7.0.0,<8.0.0", "7.5.0"},
+		{"7.0.0", "7.0.0"},
+		{"==9.0.0-rc.1", "9.0.0-rc.1"}, // pre-releases only when pinned exactly
+	}
+	for _, tt := range tests {
+		v, err := c.CollectionVersion(t.Context(), "", "community", "general", tt.constraint)
+		if err != nil {
+			t.Fatalf("CollectionVersion(%q) error = %v", tt.constraint, err)
+		}
+		if v.Version != tt.expected {
+			t.Errorf("CollectionVersion(%q) = %q, want %q", tt.constraint, v.Version, tt.expected)
+		}
+		if v.DownloadURL != srv.URL+"/download/community-general-"+tt.expected+".tar.gz" {
+			t.Errorf("CollectionVersion(%q).DownloadURL = %q, want resolved against the server", tt.constraint, v.DownloadURL)
+		}
+	}
+
+	if _, err := c.CollectionVersion(t.Context(), "", "community", "general", ">=10.0.0"); err == nil {
+		t.Error("CollectionVersion() expected error for unsatisfiable constraint, got nil")
+	}
+}
+
+func TestCollectionVersionServerPriority(t *testing.T) {
+	empty := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(empty.Close)
+	srv := newCollectionServer(t, `[{"version": "7.0.0"}]`)
+	c := New(Server{ID: "private", URL: empty.URL}, Server{ID: "public", URL: srv.URL})
+
+	v, err := c.CollectionVersion(t.Context(), "", "community", "general", "7.0.0")
+	if err != nil {
+		t.Fatalf("CollectionVersion() error = %v", err)
+	}
+	if v.Server != srv.URL+"/" {
+		t.Errorf("CollectionVersion().Server = %q, want the second server %q", v.Server, srv.URL+"/")
+	}
+
+	// explicit source limits lookup to the single server
+	if _, err := c.CollectionVersion(t.Context(), "private", "community", "general", "7.0.0"); err == nil {
+		t.Error("CollectionVersion() expected error when source server has no collection, got nil")
+	}
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	srv := newCollectionServer(t, `[{"version": "7.0.0"}]`)
+	c := New(Server{URL: srv.URL})
+	v, err := c.CollectionVersion(t.Context(), "", "community", "general", "7.0.0")
+	if err != nil {
+		t.Fatalf("CollectionVersion() error = %v", err)
+	}
+	dst := filepath.Join(t.TempDir(), "collection.tar.gz")
+
+	if err := c.Download(t.Context(), v.DownloadURL, dst, v.SHA256); err != nil {
+		t.Errorf("Download() error = %v", err)
+	}
+	if err := c.Download(t.Context(), v.DownloadURL, dst, "0000"); err == nil {
+		t.Error("Download() expected error for checksum mismatch, got nil")
+	}
+}
+
+func TestServersFromEnv(t *testing.T) {
+	t.Setenv("ANSIBLE_GALAXY_SERVER_LIST", "private, public")
+	t.Setenv("ANSIBLE_GALAXY_SERVER_PRIVATE_URL", "https://hub.example.com/api/galaxy")
+	t.Setenv("ANSIBLE_GALAXY_SERVER_PRIVATE_TOKEN", "secret")
+	t.Setenv("ANSIBLE_GALAXY_SERVER_PUBLIC_URL", "https://galaxy.ansible.com")
+
+	servers := ServersFromEnv(DefaultServer)
+	if len(servers) != 2 {
+		t.Fatalf("ServersFromEnv() = %v, want 2 servers", servers)
+	}
+	if servers[0].ID != "private" || servers[0].URL != "https://hub.example.com/api/galaxy" || servers[0].Token != "secret" {
+		t.Errorf("ServersFromEnv()[0] = %+v, want private server with token", servers[0])
+	}
+	if servers[1].ID != "public" || servers[1].Token != "" {
+		t.Errorf("ServersFromEnv()[1] = %+v, want public server without token", servers[1])
+	}
+
+	t.Setenv("ANSIBLE_GALAXY_SERVER_LIST", "")
+	if servers := ServersFromEnv(DefaultServer); len(servers) != 1 || servers[0].URL != DefaultServer {
+		t.Errorf("ServersFromEnv() = %v, want fallback server only", servers)
+	}
+}
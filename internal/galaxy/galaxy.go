@@ -0,0 +1,344 @@
+// Package galaxy implements a minimal Ansible Galaxy API client, used to resolve and download roles
+// that are referenced by their plain namespace.name in requirements files, and collections from Galaxy v3 API.
+package galaxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etkecc/agru/internal/versions"
+)
+
+const (
+	// DefaultServer is the public Ansible Galaxy server
+	DefaultServer = "https://galaxy.ansible.com"
+	// githubArchiveURL is used when the API doesn't provide download_url for a role version, same as ansible-galaxy does
+	githubArchiveURL = "https://github.com/%s/%s/archive/%s.tar.gz"
+	// requestTimeout is the timeout for a single HTTP request
+	requestTimeout = 5 * time.Minute
+)
+
+// RoleVersion is a single version of a role, as returned by the Galaxy API
+type RoleVersion struct {
+	Name        string `json:"name"`
+	DownloadURL string `json:"download_url"`
+}
+
+// role is a role, as returned by the Galaxy API
+type role struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	GithubUser string `json:"github_user"`
+	GithubRepo string `json:"github_repo"`
+}
+
+// page is a single page of a paginated Galaxy API response
+type page[T any] struct {
+	Next     string `json:"next"`
+	NextLink string `json:"next_link"`
+	Results  []T    `json:"results"`
+}
+
+// apiRoot is the Galaxy API root response, used to discover available API versions
+type apiRoot struct {
+	AvailableVersions map[string]string `json:"available_versions"`
+}
+
+// ErrNotFound is returned when the requested object doesn't exist on the Galaxy server
+var ErrNotFound = errors.New("not found")
+
+// Server is a Galaxy server configuration
+type Server struct {
+	ID    string // server id, as in ANSIBLE_GALAXY_SERVER_LIST
+	URL   string
+	Token string // API token, sent as "Authorization: Token <token>" header
+}
+
+// server is a single Galaxy server with its lazily discovered API versions
+type server struct {
+	Server
+	mu   sync.Mutex
+	apis map[string]string
+}
+
+// Client is an Ansible Galaxy API client.
+// API versions are discovered lazily on the first request, so a server may be either
+// the legacy galaxy (v1) or a galaxy_ng-based one (v1 legacy roles alongside v3).
+// Roles are resolved on the first server only (same as ansible-galaxy does),
+// while collections are resolved on all servers in priority order.
+type Client struct {
+	servers []*server
+	http    *http.Client
+}
+
+// New creates a new Galaxy API client for the given servers, listed in priority order.
+// If no servers are given, the public Galaxy server is used
+func New(servers ...Server) *Client {
+	if len(servers) == 0 {
+		servers = []Server{{URL: DefaultServer}}
+	}
+	c := &Client{http: &http.Client{Timeout: requestTimeout}}
+	for _, srv := range servers {
+		c.servers = append(c.servers, newServer(srv))
+	}
+	return c
+}
+
+// ServersFromEnv returns Galaxy servers in priority order, configured the same way as for ansible-galaxy:
+// ANSIBLE_GALAXY_SERVER_LIST contains comma-separated server ids, and each server is configured with
+// ANSIBLE_GALAXY_SERVER_<ID>_URL and (optional) ANSIBLE_GALAXY_SERVER_<ID>_TOKEN env vars.
+// If the list is not set (or none of the listed servers has URL), the fallback server is returned
+func ServersFromEnv(fallback string) []Server {
+	var servers []Server
+	for _, id := range strings.Split(os.Getenv("ANSIBLE_GALAXY_SERVER_LIST"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		prefix := "ANSIBLE_GALAXY_SERVER_" + strings.ToUpper(id) + "_"
+		serverURL := os.Getenv(prefix + "URL")
+		if serverURL == "" {
+			continue
+		}
+		servers = append(servers, Server{ID: id, URL: serverURL, Token: os.Getenv(prefix + "TOKEN")})
+	}
+	if len(servers) == 0 {
+		return []Server{{URL: fallback}}
+	}
+	return servers
+}
+
+// Servers returns the configured Galaxy servers in priority order
+func (c *Client) Servers() []Server {
+	servers := make([]Server, 0, len(c.servers))
+	for _, srv := range c.servers {
+		servers = append(servers, srv.Server)
+	}
+	return servers
+}
+
+// newServer creates a new server with normalized URL
+func newServer(srv Server) *server {
+	if srv.URL == "" {
+		srv.URL = DefaultServer
+	}
+	srv.URL = strings.TrimSuffix(srv.URL, "/") + "/"
+	return &server{Server: srv}
+}
+
+// RoleVersions returns all available versions of the namespace.name role.
+// DownloadURL is always set, either from the API response or as a GitHub archive URL.
+func (c *Client) RoleVersions(ctx context.Context, namespace, name string) ([]RoleVersion, error) {
+	srv := c.servers[0]
+	r, err := c.findRole(ctx, srv, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	v1, err := c.apiURL(ctx, srv, "v1")
+	if err != nil {
+		return nil, err
+	}
+	list, err := getPaginated[RoleVersion](ctx, c, srv, v1+"roles/"+strconv.Itoa(r.ID)+"/versions/?page_size=50")
+	if err != nil {
+		return nil, fmt.Errorf("getting %s.%s versions: %w", namespace, name, err)
+	}
+	for idx := range list {
+		if list[idx].DownloadURL == "" {
+			list[idx].DownloadURL = fmt.Sprintf(githubArchiveURL, r.GithubUser, r.GithubRepo, list[idx].Name)
+			continue
+		}
+		list[idx].DownloadURL = srv.resolve(list[idx].DownloadURL)
+	}
+	return list, nil
+}
+
+// RoleVersion returns the specific version of the namespace.name role, or the latest one if version is empty.
+// Versions are matched with and without the "v" prefix, same as ansible-galaxy does.
+func (c *Client) RoleVersion(ctx context.Context, namespace, name, version string) (RoleVersion, error) {
+	available, err := c.RoleVersions(ctx, namespace, name)
+	if err != nil {
+		return RoleVersion{}, err
+	}
+	if version == "" {
+		version = versions.Latest(RoleVersionNames(available))
+	}
+	for _, v := range available {
+		if v.Name == version || strings.TrimPrefix(v.Name, "v") == strings.TrimPrefix(version, "v") {
+			return v, nil
+		}
+	}
+	return RoleVersion{}, fmt.Errorf("version %s of %s.%s not found on %s", version, namespace, name, c.servers[0].URL)
+}
+
+// RoleVersionNames returns names of the role versions
+func RoleVersionNames(list []RoleVersion) []string {
+	names := make([]string, 0, len(list))
+	for _, v := range list {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// Download downloads the file from the url into the dst path.
+// If sha256sum is not empty, the downloaded file's checksum is verified against it
+func (c *Client) Download(ctx context.Context, fileURL, dst, sha256sum string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", fileURL, err)
+	}
+	if srv := c.serverFor(fileURL); srv != nil {
+		srv.authorize(req)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", fileURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: unexpected status %s", fileURL, resp.Status)
+	}
+
+	file, err := os.Create(dst) //nolint:gosec // that's intended
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", dst, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		return fmt.Errorf("writing %s: %w", dst, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sha256sum != "" && !strings.EqualFold(sum, sha256sum) {
+		return fmt.Errorf("downloading %s: sha256 mismatch, expected %s, got %s", fileURL, sha256sum, sum)
+	}
+	return file.Close()
+}
+
+// findRole looks up the role by its namespace (owner) and name
+func (c *Client) findRole(ctx context.Context, srv *server, namespace, name string) (role, error) {
+	v1, err := c.apiURL(ctx, srv, "v1")
+	if err != nil {
+		return role{}, err
+	}
+	query := url.Values{}
+	query.Set("owner__username", namespace)
+	query.Set("name", name)
+	var resp page[role]
+	if err := c.getJSON(ctx, srv, v1+"roles/?"+query.Encode(), &resp); err != nil {
+		return role{}, fmt.Errorf("looking up %s.%s: %w", namespace, name, err)
+	}
+	if len(resp.Results) == 0 {
+		return role{}, fmt.Errorf("role %s.%s not found on %s", namespace, name, srv.URL)
+	}
+	return resp.Results[0], nil
+}
+
+// serverFor returns the configured server the url belongs to, if any
+func (c *Client) serverFor(fileURL string) *server {
+	for _, srv := range c.servers {
+		if strings.HasPrefix(fileURL, srv.URL) {
+			return srv
+		}
+	}
+	return nil
+}
+
+// apiURL returns the absolute URL of the given API version, discovering available versions on the first call
+func (c *Client) apiURL(ctx context.Context, srv *server, version string) (string, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.apis == nil {
+		var root apiRoot
+		if err := c.getJSON(ctx, srv, srv.URL+"api/", &root); err != nil {
+			return "", fmt.Errorf("discovering galaxy api on %s: %w", srv.URL, err)
+		}
+		srv.apis = root.AvailableVersions
+		if srv.apis == nil {
+			srv.apis = map[string]string{}
+		}
+	}
+	// legacy roles API is not always advertised by galaxy_ng, but it is there
+	rel, ok := srv.apis[version]
+	if !ok {
+		rel = version + "/"
+	}
+	if strings.HasPrefix(rel, "/") {
+		return srv.resolve(rel), nil
+	}
+	return srv.URL + "api/" + strings.TrimSuffix(rel, "/") + "/", nil
+}
+
+// resolve resolves a (possibly relative) link returned by the API against the server URL
+func (srv *server) resolve(link string) string {
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		return link
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// authorize adds the server's API token to the request, if set
+func (srv *server) authorize(req *http.Request) {
+	if srv.Token != "" {
+		req.Header.Set("Authorization", "Token "+srv.Token)
+	}
+}
+
+// getJSON performs a GET request to the server's API and decodes the JSON response into v
+func (c *Client) getJSON(ctx context.Context, srv *server, apiURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	srv.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("GET %s: %w", apiURL, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", apiURL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getPaginated fetches all pages of a paginated API response
+func getPaginated[T any](ctx context.Context, c *Client, srv *server, apiURL string) ([]T, error) {
+	var results []T
+	next := apiURL
+	for next != "" {
+		var resp page[T]
+		if err := c.getJSON(ctx, srv, next, &resp); err != nil {
+			return nil, err
+		}
+		results = append(results, resp.Results...)
+		next = resp.Next
+		if next == "" {
+			next = resp.NextLink
+		}
+		if next != "" {
+			next = srv.resolve(next)
+		}
+	}
+	return results, nil
+}
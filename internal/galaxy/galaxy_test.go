@@ -0,0 +1,119 @@
+package galaxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer returns a fake Galaxy server with a single geerlingguy.docker role, versions split across 2 pages
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"available_versions": {"v1": "v1/", "v3": "v3/"}}`))
+	})
+	mux.HandleFunc("/api/v1/roles/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("owner__username") != "geerlingguy" || r.URL.Query().Get("name") != "docker" {
+			w.Write([]byte(`{"results": []}`))
+			return
+		}
+		w.Write([]byte(`{"results": [{"id": 42, "name": "docker", "github_user": "geerlingguy", "github_repo": "ansible-role-docker"}]}`))
+	})
+	mux.HandleFunc("/api/v1/roles/42/versions/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`{"next": null, "results": [{"name": "6.1.0", "download_url": "/download/6.1.0.tar.gz"}]}`))
+			return
+		}
+		w.Write([]byte(`{"next": "/api/v1/roles/42/versions/?page=2", "results": [{"name": "6.0.0"}, {"name": "v5.0.0"}]}`))
+	})
+	mux.HandleFunc("/download/6.1.0.tar.gz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("archive"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRoleVersions(t *testing.T) {
+	srv := newTestServer(t)
+	c := New(Server{URL: srv.URL})
+
+	list, err := c.RoleVersions(t.Context(), "geerlingguy", "docker")
+	if err != nil {
+		t.Fatalf("RoleVersions() error = %v", err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("RoleVersions() len = %d, want 3 (both pages)", len(list))
+	}
+	expectedURL := "https://github.com/geerlingguy/ansible-role-docker/archive/6.0.0.tar.gz"
+	if list[0].DownloadURL != expectedURL {
+		t.Errorf("RoleVersions()[0].DownloadURL = %q, want %q", list[0].DownloadURL, expectedURL)
+	}
+	if list[2].DownloadURL != srv.URL+"/download/6.1.0.tar.gz" {
+		t.Errorf("RoleVersions()[2].DownloadURL = %q, want the API-provided one", list[2].DownloadURL)
+	}
+}
+
+func TestRoleVersionsNotFound(t *testing.T) {
+	srv := newTestServer(t)
+	c := New(Server{URL: srv.URL})
+
+	if _, err := c.RoleVersions(t.Context(), "nobody", "nothing"); err == nil {
+		t.Error("RoleVersions() expected error for missing role, got nil")
+	}
+}
+
+func TestRoleVersion(t *testing.T) {
+	srv := newTestServer(t)
+	c := New(Server{URL: srv.URL})
+
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{"6.0.0", "6.0.0"},
+		{"5.0.0", "v5.0.0"}, // "v" prefix is ignored
+		{"", "6.1.0"},       // latest
+	}
+	for _, tt := range tests {
+		v, err := c.RoleVersion(t.Context(), "geerlingguy", "docker", tt.version)
+		if err != nil {
+			t.Fatalf("RoleVersion(%q) error = %v", tt.version, err)
+		}
+		if v.Name != tt.expected {
+			t.Errorf("RoleVersion(%q) = %q, want %q", tt.version, v.Name, tt.expected)
+		}
+	}
+
+	if _, err := c.RoleVersion(t.Context(), "geerlingguy", "docker", "9.9.9"); err == nil {
+		t.Error("RoleVersion() expected error for missing version, got nil")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	srv := newTestServer(t)
+	c := New(Server{URL: srv.URL})
+	dst := filepath.Join(t.TempDir(), "role.tar.gz")
+
+	if err := c.Download(t.Context(), srv.URL+"/download/6.1.0.tar.gz", dst, ""); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	content, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "archive" {
+		t.Errorf("Download() content = %q, want %q", content, "archive")
+	}
+
+	if err := c.Download(t.Context(), srv.URL+"/download/missing.tar.gz", dst, ""); err == nil {
+		t.Error("Download() expected error for 404, got nil")
+	}
+}
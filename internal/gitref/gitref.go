@@ -0,0 +1,118 @@
+// Package gitref resolves refs of git repositories, so roles installed from branches
+// are reinstalled only when the branch's head has moved.
+package gitref
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/etkecc/agru/internal/runner"
+)
+
+const (
+	// headRef is the remote's default branch
+	headRef = "HEAD"
+	// branchPrefix is the prefix of the branch refs
+	branchPrefix = "refs/heads/"
+	// tagPrefix is the prefix of the tag refs
+	tagPrefix = "refs/tags/"
+)
+
+// Ref returns the full ref of the branch, or HEAD (the default branch) if the branch is empty
+func Ref(branch string) string {
+	if branch == "" {
+		return headRef
+	}
+	return branchPrefix + branch
+}
+
+// Head returns the commit hash of the branch's head in the remote repo, the default branch is used if the branch is empty
+func Head(ctx context.Context, r runner.Runner, repo, branch string) (string, error) {
+	ref := Ref(branch)
+	out, err := r.Run(ctx, runner.Cmd("", "git", "ls-remote", "-q", repo, ref))
+	if err != nil {
+		return "", fmt.Errorf("listing remote refs: %w", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		sha, name, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if ok && name == ref && sha != "" {
+			return sha, nil
+		}
+	}
+	return "", fmt.Errorf("ref %s not found in %s", ref, repo)
+}
+
+// Resolve returns the commit hash of the version (tag or branch, the default branch if empty) in the remote repo,
+// and whether the version is a branch. The branch takes precedence over the tag with the same name, same as in git clone -b
+func Resolve(ctx context.Context, r runner.Runner, repo, version string) (sha string, isBranch bool, err error) {
+	ref := Ref(version)
+	args := []string{"git", "ls-remote", "-q", repo, ref}
+	if version != "" { // the peeled ref of the annotated tag is listed only when asked for
+		args = append(args, tagPrefix+version, tagPrefix+version+"^{}")
+	}
+	out, err := r.Run(ctx, runner.Cmd("", args...))
+	if err != nil {
+		return "", false, fmt.Errorf("listing remote refs: %w", err)
+	}
+	var tag, peeled string
+	for _, line := range strings.Split(out, "\n") {
+		sha, name, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if !ok || sha == "" {
+			continue
+		}
+		switch name {
+		case ref:
+			return sha, true, nil
+		case tagPrefix + version:
+			tag = sha
+		case tagPrefix + version + "^{}":
+			peeled = sha
+		}
+	}
+	switch {
+	case peeled != "": // the commit of the annotated tag
+		return peeled, false, nil
+	case tag != "":
+		return tag, false, nil
+	}
+	return "", false, fmt.Errorf("version %s not found in %s", version, repo)
+}
+
+// IsRemoteBranch checks if the version is a branch (and not a tag) in the remote repo, the default branch if the version is empty.
+// It's used for the roles installed before the ref type was recorded
+func IsRemoteBranch(ctx context.Context, r runner.Runner, repo, version string) (bool, error) {
+	ref := Ref(version)
+	args := []string{"git", "ls-remote", "-q", repo, ref}
+	if version != "" {
+		args = append(args, tagPrefix+version)
+	}
+	out, err := r.Run(ctx, runner.Cmd("", args...))
+	if err != nil {
+		return false, fmt.Errorf("listing remote refs: %w", err)
+	}
+	var tagged bool
+	for _, line := range strings.Split(out, "\n") {
+		_, name, _ := strings.Cut(strings.TrimSpace(line), "\t")
+		switch name {
+		case ref:
+			return true, nil
+		case tagPrefix + version, tagPrefix + version + "^{}":
+			tagged = true
+		}
+	}
+	if !tagged {
+		return false, fmt.Errorf("version %s not found in %s", version, repo)
+	}
+	return false, nil
+}
+
+// IsBranch checks if the symbolic full name (as returned by git rev-parse --symbolic-full-name) is a branch
+func IsBranch(fullName string) bool {
+	for _, line := range strings.Split(fullName, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), branchPrefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package gitref
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/etkecc/agru/internal/runner"
+)
+
+// fakeRunner returns the preset output and error for any command
+type fakeRunner struct {
+	out     string
+	err     error
+	command string
+}
+
+func (r *fakeRunner) Run(_ context.Context, cmd runner.Command) (string, error) {
+	r.command = cmd.String()
+	return r.out, r.err
+}
+
+func TestRef(t *testing.T) {
+	if got := Ref(""); got != "HEAD" {
+		t.Errorf("Ref(\"\") = %q, want %q", got, "HEAD")
+	}
+	if got := Ref("develop"); got != "refs/heads/develop" {
+		t.Errorf("Ref(develop) = %q, want %q", got, "refs/heads/develop")
+	}
+}
+
+func TestHead(t *testing.T) {
+	tests := []struct {
+		name     string
+		branch   string
+		out      string
+		err      error
+		command  string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "branch",
+			branch:   "develop",
+			out:      "abc123\trefs/heads/develop",
+			command:  "git ls-remote -q https://example.com/repo.git refs/heads/develop",
+			expected: "abc123",
+		},
+		{
+			name:     "default branch",
+			out:      "def456\tHEAD",
+			command:  "git ls-remote -q https://example.com/repo.git HEAD",
+			expected: "def456",
+		},
+		{
+			name:     "ignores other refs",
+			branch:   "main",
+			out:      "111\trefs/heads/main-old\n222\trefs/heads/main",
+			command:  "git ls-remote -q https://example.com/repo.git refs/heads/main",
+			expected: "222",
+		},
+		{
+			name:    "missing branch",
+			branch:  "gone",
+			command: "git ls-remote -q https://example.com/repo.git refs/heads/gone",
+			wantErr: true,
+		},
+		{
+			name:    "runner error",
+			branch:  "main",
+			err:     errors.New("network down"),
+			command: "git ls-remote -q https://example.com/repo.git refs/heads/main",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeRunner{out: tt.out, err: tt.err}
+			got, err := Head(t.Context(), r, "https://example.com/repo.git", tt.branch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Head() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("Head() = %q, want %q", got, tt.expected)
+			}
+			if r.command != tt.command {
+				t.Errorf("Head() command = %q, want %q", r.command, tt.command)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		out      string
+		err      error
+		command  string
+		expected string
+		branch   bool
+		wantErr  bool
+	}{
+		{
+			name:     "annotated tag",
+			version:  "v1.0.0",
+			out:      "abc123\trefs/tags/v1.0.0\ndef456\trefs/tags/v1.0.0^{}",
+			command:  "git ls-remote -q https://example.com/repo.git refs/heads/v1.0.0 refs/tags/v1.0.0 refs/tags/v1.0.0^{}",
+			expected: "def456",
+		},
+		{
+			name:     "lightweight tag",
+			version:  "v1.0.0",
+			out:      "abc123\trefs/tags/v1.0.0",
+			command:  "git ls-remote -q https://example.com/repo.git refs/heads/v1.0.0 refs/tags/v1.0.0 refs/tags/v1.0.0^{}",
+			expected: "abc123",
+		},
+		{
+			name:     "branch",
+			version:  "develop",
+			out:      "abc123\trefs/heads/develop\ndef456\trefs/tags/develop",
+			command:  "git ls-remote -q https://example.com/repo.git refs/heads/develop refs/tags/develop refs/tags/develop^{}",
+			expected: "abc123",
+			branch:   true,
+		},
+		{
+			name:     "default branch",
+			out:      "def456\tHEAD",
+			command:  "git ls-remote -q https://example.com/repo.git HEAD",
+			expected: "def456",
+			branch:   true,
+		},
+		{
+			name:    "missing version",
+			version: "gone",
+			command: "git ls-remote -q https://example.com/repo.git refs/heads/gone refs/tags/gone refs/tags/gone^{}",
+			wantErr: true,
+		},
+		{
+			name:    "runner error",
+			version: "v1.0.0",
+			err:     errors.New("network down"),
+			command: "git ls-remote -q https://example.com/repo.git refs/heads/v1.0.0 refs/tags/v1.0.0 refs/tags/v1.0.0^{}",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeRunner{out: tt.out, err: tt.err}
+			got, branch, err := Resolve(t.Context(), r, "https://example.com/repo.git", tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected || branch != tt.branch {
+				t.Errorf("Resolve() = %q, %v, want %q, %v", got, branch, tt.expected, tt.branch)
+			}
+			if r.command != tt.command {
+				t.Errorf("Resolve() command = %q, want %q", r.command, tt.command)
+			}
+		})
+	}
+}
+
+func TestIsRemoteBranch(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		out      string
+		err      error
+		command  string
+		expected bool
+		wantErr  bool
+	}{
+		{
+			name:     "branch",
+			version:  "develop",
+			out:      "abc123\trefs/heads/develop",
+			command:  "git ls-remote -q https://example.com/repo.git refs/heads/develop refs/tags/develop",
+			expected: true,
+		},
+		{
+			name:    "tag",
+			version: "v1.0.0",
+			out:     "abc123\trefs/tags/v1.0.0\ndef456\trefs/tags/v1.0.0^{}",
+			command: "git ls-remote -q https://example.com/repo.git refs/heads/v1.0.0 refs/tags/v1.0.0",
+		},
+		{
+			name:     "default branch",
+			out:      "def456\tHEAD",
+			command:  "git ls-remote -q https://example.com/repo.git HEAD",
+			expected: true,
+		},
+		{
+			name:    "missing version",
+			version: "gone",
+			out:     "abc123\trefs/heads/feature/gone",
+			command: "git ls-remote -q https://example.com/repo.git refs/heads/gone refs/tags/gone",
+			wantErr: true,
+		},
+		{
+			name:    "runner error",
+			version: "develop",
+			err:     errors.New("network down"),
+			command: "git ls-remote -q https://example.com/repo.git refs/heads/develop refs/tags/develop",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeRunner{out: tt.out, err: tt.err}
+			got, err := IsRemoteBranch(t.Context(), r, "https://example.com/repo.git", tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IsRemoteBranch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("IsRemoteBranch() = %v, want %v", got, tt.expected)
+			}
+			if r.command != tt.command {
+				t.Errorf("IsRemoteBranch() command = %q, want %q", r.command, tt.command)
+			}
+		})
+	}
+}
+
+func TestIsBranch(t *testing.T) {
+	tests := []struct {
+		fullName string
+		expected bool
+	}{
+		{"refs/heads/develop", true},
+		{"refs/tags/v1.0.0", false},
+		{"", false},
+		{"warning: refname 'main' is ambiguous.\nrefs/heads/main", true},
+	}
+
+	for _, tt := range tests {
+		if got := IsBranch(tt.fullName); got != tt.expected {
+			t.Errorf("IsBranch(%q) = %v, want %v", tt.fullName, got, tt.expected)
+		}
+	}
+}
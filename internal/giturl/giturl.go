@@ -0,0 +1,156 @@
+// Package giturl parses git repository URLs in all forms used in requirements files
+// (https, ssh, scp-style, git, file, with optional git+ prefix), so roles and collections
+// are checked and installed from the same normalized URL.
+package giturl
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// TransportHTTPS is the smart HTTPS transport
+	TransportHTTPS = "https"
+	// TransportHTTP is the smart HTTP transport
+	TransportHTTP = "http"
+	// TransportSSH is the SSH transport, used by ssh:// and scp-style URLs
+	TransportSSH = "ssh"
+	// TransportGit is the git daemon transport
+	TransportGit = "git"
+	// TransportFile is the local transport
+	TransportFile = "file"
+)
+
+var (
+	// scpRegex matches scp-style URLs, e.g. git@github.com:org/repo.git
+	scpRegex = regexp.MustCompile(`^(?:([^@/]+)@)?([^:/]+):(.+)$`)
+
+	transports = map[string]bool{
+		TransportHTTPS: true,
+		TransportHTTP:  true,
+		TransportSSH:   true,
+		TransportGit:   true,
+		TransportFile:  true,
+	}
+)
+
+// URL is a parsed git repository URL
+type URL struct {
+	Transport string
+	User      *url.Userinfo // username and optional password (e.g. a token), nil if not set
+	Host      string
+	Port      string
+	Path      string
+	RawQuery  string // encoded query, without '?'
+	Fragment  string // fragment, without '#'
+	scp       bool
+}
+
+// Parse parses the git repository URL, in any of the following forms:
+// [git+]https://host/org/repo.git, [git+]ssh://user@host[:port]/org/repo.git, user@host:org/repo.git,
+// git://host/org/repo.git, [git+]file:///path/to/repo.git, /path/to/repo.git
+func Parse(raw string) (*URL, error) {
+	raw = strings.TrimPrefix(strings.TrimSpace(raw), "git+")
+	if raw == "" {
+		return nil, errors.New("empty git url")
+	}
+
+	if strings.Contains(raw, "://") {
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parsing git url %q: %w", raw, err)
+		}
+		transport := strings.ToLower(parsed.Scheme)
+		if !transports[transport] {
+			return nil, fmt.Errorf("unsupported git url transport %q", parsed.Scheme)
+		}
+		if transport != TransportFile && parsed.Host == "" {
+			return nil, fmt.Errorf("git url %q has no host", raw)
+		}
+		return &URL{
+			Transport: transport,
+			User:      parsed.User,
+			Host:      parsed.Hostname(),
+			Port:      parsed.Port(),
+			Path:      parsed.Path,
+			RawQuery:  parsed.RawQuery,
+			Fragment:  parsed.Fragment,
+		}, nil
+	}
+
+	if strings.HasPrefix(raw, "/") {
+		return &URL{Transport: TransportFile, Path: raw}, nil
+	}
+
+	if match := scpRegex.FindStringSubmatch(raw); match != nil {
+		u := &URL{Transport: TransportSSH, Host: match[2], Path: match[3], scp: true}
+		if match[1] != "" {
+			u.User = url.User(match[1])
+		}
+		return u, nil
+	}
+
+	return nil, fmt.Errorf("unsupported git url %q", raw)
+}
+
+// IsGit checks if the src looks like a git repository URL: it has explicit git+ prefix, uses ssh or git transport,
+// or its host or path mentions git (e.g. github.com or repo.git)
+func IsGit(src string) bool {
+	u, err := Parse(src)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(src, "git+") || u.Transport == TransportSSH || u.Transport == TransportGit {
+		return true
+	}
+	return strings.Contains(u.Host, "git") || strings.HasSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+}
+
+// Normalize returns the URL that git can use, or the src as-is if it can't be parsed
+func Normalize(src string) string {
+	u, err := Parse(src)
+	if err != nil {
+		return src
+	}
+	return u.String()
+}
+
+// Name returns the repository name derived from the src, or the src's base name if it can't be parsed
+func Name(src string) string {
+	u, err := Parse(src)
+	if err != nil {
+		return strings.TrimSuffix(path.Base(src), ".git")
+	}
+	return u.Name()
+}
+
+// String returns the URL in the form git understands, without git+ prefix
+func (u *URL) String() string {
+	if u.scp {
+		var userinfo string
+		if u.User != nil {
+			userinfo = u.User.Username() + "@"
+		}
+		return userinfo + u.Host + ":" + u.Path
+	}
+	if u.Transport == TransportFile {
+		return "file://" + u.Path
+	}
+	host := u.Host
+	if strings.Contains(host, ":") { // IPv6
+		host = "[" + host + "]"
+	}
+	if u.Port != "" {
+		host += ":" + u.Port
+	}
+	return (&url.URL{Scheme: u.Transport, User: u.User, Host: host, Path: u.Path, RawQuery: u.RawQuery, Fragment: u.Fragment}).String()
+}
+
+// Name returns the repository name, i.e. the last path element without .git suffix
+func (u *URL) Name() string {
+	return strings.TrimSuffix(path.Base(strings.TrimSuffix(u.Path, "/")), ".git")
+}
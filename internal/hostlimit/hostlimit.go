@@ -0,0 +1,115 @@
+// Package hostlimit caps the number of concurrent version checks and installs per remote host,
+// so hundreds of roles from the same host don't hit its rate limits or exhaust the file descriptors.
+package hostlimit
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Any is the host pattern that sets the limit of all hosts without their own limit
+const Any = "*"
+
+// Limits maps the hosts (or Any) to the max number of concurrent operations, 0 means no limit
+type Limits map[string]int
+
+// DefaultLimits returns the default per-host limits
+func DefaultLimits() Limits {
+	return Limits{Any: 8}
+}
+
+// Parse parses the comma-separated host=limit pairs, e.g. github.com=8,gitlab.example.com=2,*=4
+func Parse(s string) (Limits, error) {
+	limits := Limits{}
+	for pair := range strings.SplitSeq(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		host, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(host) == "" {
+			return nil, fmt.Errorf("invalid host limit %q, expected host=limit", pair)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid host limit %q, expected a non-negative number", pair)
+		}
+		limits[strings.ToLower(strings.TrimSpace(host))] = limit
+	}
+	return limits, nil
+}
+
+// String returns the limits in the Parse format, sorted by host
+func (l Limits) String() string {
+	pairs := make([]string, 0, len(l))
+	for _, host := range slices.Sorted(maps.Keys(l)) {
+		pairs = append(pairs, host+"="+strconv.Itoa(l[host]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set merges the comma-separated host=limit pairs into the limits, so the limits can be set with a repeatable flag
+func (l Limits) Set(s string) error {
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	maps.Copy(l, parsed)
+	return nil
+}
+
+// Limiter caps the number of concurrent operations per host,
+// the same Limiter is shared by the version checks and installs
+type Limiter struct {
+	limits Limits
+
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+// New creates a new Limiter with the per-host limits
+func New(limits Limits) *Limiter {
+	return &Limiter{limits: limits, sems: map[string]chan struct{}{}}
+}
+
+// Acquire waits until the host has a free slot (or the ctx is done), and returns the func to release it.
+// The empty host (e.g. of a local role) and the hosts without a limit are not limited, same as any host of the nil Limiter
+func (l *Limiter) Acquire(ctx context.Context, host string) (release func(), err error) {
+	sem := l.semaphore(strings.ToLower(host))
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// semaphore returns the host's semaphore, nil if the host is not limited
+func (l *Limiter) semaphore(host string) chan struct{} {
+	if l == nil || host == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sem, ok := l.sems[host]; ok {
+		return sem
+	}
+	limit, ok := l.limits[host]
+	if !ok {
+		limit = l.limits[Any]
+	}
+	var sem chan struct{}
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+	l.sems[host] = sem
+	return sem
+}
@@ -0,0 +1,105 @@
+package hostlimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"github.com=8", "github.com=8", false},
+		{"GitHub.com=8, gitlab.example.com=2,*=4", "*=4,github.com=8,gitlab.example.com=2", false},
+		{"", "", false},
+		{"github.com=0", "github.com=0", false},
+		{"github.com", "", true},
+		{"=8", "", true},
+		{"github.com=many", "", true},
+		{"github.com=-1", "", true},
+	}
+	for _, tt := range tests {
+		limits, err := Parse(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got := limits.String(); !tt.wantErr && got != tt.expected {
+			t.Errorf("Parse(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestLimitsSet(t *testing.T) {
+	limits := DefaultLimits()
+	if err := limits.Set("github.com=8"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := limits.Set("gitlab.example.com=2,*=4"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got, want := limits.String(), "*=4,github.com=8,gitlab.example.com=2"; got != want {
+		t.Errorf("Set() = %q, want %q", got, want)
+	}
+	if err := limits.Set("github.com"); err == nil {
+		t.Error("Set() expected error for the invalid pair, got nil")
+	}
+}
+
+func TestLimiterAcquire(t *testing.T) {
+	l := New(Limits{"github.com": 2, "gitlab.example.com": 0, Any: 1})
+
+	// github.com has 2 slots, the host name is case-insensitive
+	release1, err := l.Acquire(t.Context(), "github.com")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	release2, err := l.Acquire(t.Context(), "GitHub.com")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "github.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() error = %v, want to wait for a free slot until the ctx is done", err)
+	}
+	release1()
+	release3, err := l.Acquire(t.Context(), "github.com")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v, want the released slot", err)
+	}
+	release2()
+	release3()
+
+	// other hosts have their own slots, limited by Any
+	releaseOther, err := l.Acquire(t.Context(), "example.org")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if _, err := l.Acquire(ctx, "example.org"); err == nil {
+		t.Error("Acquire() should limit the other hosts with the * limit")
+	}
+	releaseOther()
+
+	// 0 and the empty host are not limited
+	for _, host := range []string{"gitlab.example.com", ""} {
+		for range 10 {
+			if _, err := l.Acquire(t.Context(), host); err != nil {
+				t.Errorf("Acquire(%q) error = %v, want no limit", host, err)
+			}
+		}
+	}
+}
+
+func TestLimiterNil(t *testing.T) {
+	var l *Limiter
+	release, err := l.Acquire(t.Context(), "github.com")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	release()
+}
@@ -0,0 +1,160 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/etkecc/agru/internal/archive"
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+// newArchiveServer returns a fake artifact server with a single role-1.2.3.tar.gz archive and its sha256 checksum
+func newArchiveServer(t *testing.T) (srv *httptest.Server, checksum string) {
+	t.Helper()
+	content := makeTarGz(t, map[string]string{
+		"role-1.2.3/tasks/main.yml": "---\n",
+	})
+	sum := sha256.Sum256(content)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/releases/role-1.2.3.tar.gz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(content)
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestInstallArchiveRole(t *testing.T) {
+	srv, checksum := newArchiveServer(t)
+	rolesPath := t.TempDir()
+	inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, nil, "")
+	entry := &models.Entry{Src: srv.URL + "/releases/role-{version}.tar.gz", Version: "1.2.3", Checksum: checksum}
+
+	ok, _, err := inst.installRole(t.Context(), entry)
+	if err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+	if !ok {
+		t.Error("installRole() = false, want true for new installation")
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "role", "tasks", "main.yml")); err != nil {
+		t.Errorf("installRole() should extract the archive without top-level dir: %v", err)
+	}
+	if !entry.IsInstalled(os.DirFS(rolesPath)) {
+		t.Error("IsInstalled() = false after archive installation, install info is not written")
+	}
+}
+
+func TestInstallArchiveRoleOffline(t *testing.T) {
+	srv, checksum := newArchiveServer(t)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	entry := &models.Entry{Src: srv.URL + "/releases/role-{version}.tar.gz", Version: "1.2.3", Checksum: checksum}
+	inst := New(runner.New(0), nil, t.TempDir(), "", 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOff), "")
+	if _, _, err := inst.installRole(t.Context(), entry); err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+	srv.Close()
+
+	rolesPath := t.TempDir()
+	inst = New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOn), "")
+	if _, _, err := inst.installRole(t.Context(), entry); err != nil {
+		t.Fatalf("installRole() offline error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "role", "tasks", "main.yml")); err != nil {
+		t.Errorf("installRole() offline should extract the cached archive: %v", err)
+	}
+
+	missing := &models.Entry{Src: entry.Src, Version: "2.0.0"}
+	if _, _, err := inst.installRole(t.Context(), missing); !errors.Is(err, cache.ErrNotCached) {
+		t.Errorf("installRole() offline error = %v, want %v", err, cache.ErrNotCached)
+	}
+}
+
+func TestInstallArchiveRoleOfflineTampered(t *testing.T) {
+	srv, checksum := newArchiveServer(t)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	entry := &models.Entry{Src: srv.URL + "/releases/role-{version}.tar.gz", Version: "1.2.3", Checksum: checksum}
+	inst := New(runner.New(0), nil, t.TempDir(), "", 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOff), "")
+	if _, _, err := inst.installRole(t.Context(), entry); err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+	cached, err := filepath.Glob(filepath.Join(cacheDir, cache.KindArchive, "*"))
+	if err != nil || len(cached) != 1 {
+		t.Fatalf("cached archives = %v, %v, want 1", cached, err)
+	}
+	if err := os.WriteFile(cached[0], makeTarGz(t, map[string]string{"role-1.2.3/tasks/main.yml": "- tampered\n"}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rolesPath := t.TempDir()
+	inst = New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOn), "")
+	if _, _, err := inst.installRole(t.Context(), entry); !errors.Is(err, archive.ErrChecksumMismatch) {
+		t.Errorf("installRole() offline error = %v, want %v", err, archive.ErrChecksumMismatch)
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "role")); !os.IsNotExist(err) {
+		t.Errorf("installRole() offline should not extract the tampered archive, got: %v", err)
+	}
+}
+
+func TestInstallArchiveRoleAutoChecksumMismatch(t *testing.T) {
+	content := makeTarGz(t, map[string]string{"role-1.2.3/tasks/main.yml": "---\n"})
+	sum := sha256.Sum256(content)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(content)
+	}))
+	t.Cleanup(srv.Close)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	entry := &models.Entry{Src: srv.URL + "/releases/role-1.2.3.tar.gz", Version: "1.2.3", Checksum: "sha256:" + hex.EncodeToString(sum[:])}
+	inst := New(runner.New(0), nil, t.TempDir(), "", 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOff), "")
+	if _, _, err := inst.installRole(t.Context(), entry); err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+
+	// the upstream archive is replaced, the cached one still matches the checksum, but must not be used
+	content = makeTarGz(t, map[string]string{"role-1.2.3/tasks/main.yml": "- tampered\n"})
+	rolesPath := t.TempDir()
+	inst = New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineAuto), "")
+	if _, _, err := inst.installRole(t.Context(), entry); !errors.Is(err, archive.ErrChecksumMismatch) {
+		t.Errorf("installRole() auto error = %v, want %v", err, archive.ErrChecksumMismatch)
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "role")); !os.IsNotExist(err) {
+		t.Errorf("installRole() auto should not fall back to the cached archive on checksum mismatch, got: %v", err)
+	}
+
+	srv.Close()
+	if _, _, err := inst.installRole(t.Context(), entry); err != nil {
+		t.Errorf("installRole() auto should fall back to the cached archive on network errors, error = %v", err)
+	}
+}
+
+func TestInstallArchiveRoleChecksumMismatch(t *testing.T) {
+	srv, _ := newArchiveServer(t)
+	rolesPath := t.TempDir()
+	inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, nil, "")
+	sum := sha256.Sum256([]byte("tampered"))
+	entry := &models.Entry{Src: srv.URL + "/releases/role-1.2.3.tar.gz", Version: "1.2.3", Checksum: "sha256:" + hex.EncodeToString(sum[:])}
+
+	if _, _, err := inst.installRole(t.Context(), entry); err == nil {
+		t.Error("installRole() expected error for checksum mismatch, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "role")); !os.IsNotExist(err) {
+		t.Errorf("installRole() should not extract the archive on checksum mismatch, got: %v", err)
+	}
+}
+
+func TestInstallArchiveRoleMissingVersion(t *testing.T) {
+	inst := New(runner.New(0), nil, t.TempDir(), "", 0, nil, true, false, false, nil, "")
+	entry := &models.Entry{Src: "https://artifacts.example.com/role-{version}.tar.gz"}
+
+	if _, _, err := inst.installRole(t.Context(), entry); err == nil {
+		t.Error("installRole() expected error for templated src without version, got nil")
+	}
+}
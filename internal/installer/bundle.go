@@ -0,0 +1,120 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/etkecc/agru/internal/bundle"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/versions"
+)
+
+// installBundleRole writes the role from the unpacked bundle to the target roles dir as-is,
+// so its install info is the same as of the role installed when the bundle was created.
+// Returns whether the role was installed, a verbose log line, and any error.
+func (i *Installer) installBundleRole(ctx context.Context, entry *models.Entry) (installed bool, log string, err error) {
+	name := entry.GetName()
+	info, err := i.bundledInstallInfo(entry)
+	if err != nil {
+		return false, "", err
+	}
+
+	logLine := fmt.Sprintf("[%s] copying %s from the bundle (sha: %s)", name, info.Version, info.InstallCommit)
+	err = i.replaceRole(ctx, name, func(rolesPath string) error {
+		if err := copyDir(filepath.Join(i.bundleDir, bundle.RolesDir, name), path.Join(rolesPath, name)); err != nil {
+			return fmt.Errorf("copying role dir: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, logLine, err
+	}
+	return true, logLine, nil
+}
+
+// bundledInstallInfo returns the install info of the bundled role,
+// or an error if the role is not bundled, or its version (or locked commit) differs from the entry's
+func (i *Installer) bundledInstallInfo(entry *models.Entry) (models.GalaxyInstallInfo, error) {
+	info, err := entry.GetInstallInfo(os.DirFS(filepath.Join(i.bundleDir, bundle.RolesDir)))
+	if err != nil {
+		return info, fmt.Errorf("reading bundled install info: %w", err)
+	}
+	switch {
+	case info.Version == "" && info.InstallDate == "":
+		return info, fmt.Errorf("%s is not in the bundle", entry.GetName())
+	case info.Version != entry.Version:
+		return info, fmt.Errorf("%s@%s is not in the bundle, it has version %s", entry.GetName(), entry.Version, info.Version)
+	}
+	if commit, _ := entry.Locked(); commit != "" && commit != info.InstallCommit {
+		return info, fmt.Errorf("bundled commit %s doesn't match the locked commit %s", info.InstallCommit, commit)
+	}
+	return info, nil
+}
+
+// installBundleCollection writes the collection from the unpacked bundle to the target collections dir as-is,
+// the collection is found by its src in the bundle's lockfile, so git collections don't have to be cloned to get their names.
+// Returns the previously installed version, whether the collection was installed, a verbose log line, and any error.
+func (i *Installer) installBundleCollection(ctx context.Context, collection *models.Collection) (oldVersion string, installed bool, log string, err error) {
+	lock, err := models.ReadLock(filepath.Join(i.bundleDir, bundle.LockName))
+	if err != nil {
+		return "", false, "", err
+	}
+	locked := lock.Collection(collection)
+	if locked == nil {
+		return "", false, "", fmt.Errorf("%s is not in the bundle", collection.GetName())
+	}
+	namespace, name, _ := strings.Cut(locked.Name, ".")
+	collection.SetFQCN(namespace, name)
+	bundled := locked.Version == collection.Version
+	if !collection.IsGit() { // galaxy collections have version constraints
+		bundled = versions.Satisfies(locked.Version, collection.Version)
+	}
+	if !bundled {
+		return "", false, "", fmt.Errorf("%s@%s is not in the bundle, it has version %s", collection.GetName(), collection.Version, locked.Version)
+	}
+
+	collectionsFS := os.DirFS(i.collectionsPath)
+	oldVersion = collection.GetInstalledVersion(collectionsFS)
+	if collection.IsGit() {
+		info, _ := collection.GetInstallInfo(collectionsFS) //nolint:errcheck // parse failure → empty version → will reinstall
+		oldVersion = info.Version
+	}
+	if collection.IsInstalled(collectionsFS) {
+		return "", false, "", nil
+	}
+
+	bundledPath := filepath.Join(i.bundleDir, bundle.CollectionsDir)
+	logLine := fmt.Sprintf("[%s] copying %s from the bundle", collection.GetName(), locked.Version)
+	err = i.replace(ctx, collection.GetName(), path.Join(i.collectionsPath, collection.GetPath()), func(staged string) error {
+		if err := copyDir(filepath.Join(bundledPath, collection.GetPath()), staged); err != nil {
+			return fmt.Errorf("copying collection dir: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, logLine, err
+	}
+
+	if collection.IsGit() {
+		return oldVersion, true, logLine, nil
+	}
+	// galaxy collections have the GALAXY.yml info dir, see writeGalaxyCollectionInfo
+	if err := i.removeGalaxyCollectionInfo(collection.GetName(), namespace, name); err != nil {
+		return "", false, logLine, err
+	}
+	infoName := locked.Name + "-" + locked.Version + ".info"
+	err = i.replace(ctx, collection.GetName(), path.Join(i.collectionsPath, "ansible_collections", infoName), func(staged string) error {
+		if err := copyDir(filepath.Join(bundledPath, "ansible_collections", infoName), staged); err != nil {
+			return fmt.Errorf("copying collection info: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, logLine, err
+	}
+	return oldVersion, true, logLine, nil
+}
@@ -0,0 +1,112 @@
+package installer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/etkecc/agru/internal/bundle"
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+func TestInstallBundleRole(t *testing.T) {
+	repo := makeGitRepo(t, map[string]string{"tasks/main.yml": "---\n"}, "v1.0.0")
+	entry := &models.Entry{Src: "git+file://" + repo, Name: "role", Version: "v1.0.0"}
+	bundleDir := t.TempDir()
+	if _, _, err := New(runner.New(0), nil, filepath.Join(bundleDir, bundle.RolesDir), "", 0, nil, true, false, false, nil, "").installRole(t.Context(), entry); err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+	if err := os.RemoveAll(repo); err != nil { // no network, no repo
+		t.Fatal(err)
+	}
+
+	rolesPath := t.TempDir()
+	inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, nil, bundleDir)
+	ok, _, err := inst.installRole(t.Context(), entry)
+	if err != nil {
+		t.Fatalf("installRole() from bundle error = %v", err)
+	}
+	if !ok {
+		t.Error("installRole() = false, want true for new installation")
+	}
+	expected, err := os.ReadFile(entry.GetInstallInfoPath(filepath.Join(bundleDir, bundle.RolesDir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(entry.GetInstallInfoPath(rolesPath)); err != nil || !bytes.Equal(got, expected) {
+		t.Errorf("installRole() install info = %q, %v, want the bundled %q", got, err, expected)
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "role", "tasks", "main.yml")); err != nil {
+		t.Errorf("installRole() should copy the bundled role: %v", err)
+	}
+
+	for _, missing := range []*models.Entry{
+		{Src: entry.Src, Name: "role", Version: "v2.0.0"},
+		{Src: entry.Src, Name: "other", Version: "v1.0.0"},
+	} {
+		if _, _, err := inst.installRole(t.Context(), missing); err == nil {
+			t.Errorf("installRole(%s@%s) error = nil, want error for role not in the bundle", missing.Name, missing.Version)
+		}
+	}
+}
+
+func TestInstallBundleCollections(t *testing.T) {
+	srv := newCollectionServer(t)
+	repo := makeGitRepo(t, map[string]string{
+		"galaxy.yml":             "namespace: org\nname: foo\nversion: 1.0.0\n",
+		"plugins/modules/bar.py": "# module\n",
+	}, "v1.0.0")
+	bundleDir := t.TempDir()
+	bundledPath := filepath.Join(bundleDir, bundle.CollectionsDir)
+	collections := models.Collections{
+		{Name: "file://" + repo, Type: "git", Version: "v1.0.0"},
+		{Name: "community.general", Version: ">=7.0.0"},
+	}
+	inst := New(runner.New(0), galaxy.New(galaxy.Server{URL: srv.URL}), filepath.Join(bundleDir, bundle.RolesDir), bundledPath, 0, nil, true, false, false, nil, "")
+	if err := inst.InstallMissing(t.Context(), models.File{}, collections, nil); err != nil {
+		t.Fatalf("InstallMissing() error = %v", err)
+	}
+	lock := &models.Lock{}
+	if err := lock.AddCollections(collections, os.DirFS(bundledPath)); err != nil {
+		t.Fatalf("AddCollections() error = %v", err)
+	}
+	if err := lock.Write(filepath.Join(bundleDir, bundle.LockName)); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close() // no network, no repo
+	if err := os.RemoveAll(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	collectionsPath := t.TempDir()
+	inst = New(runner.New(0), nil, t.TempDir(), collectionsPath, 0, nil, true, false, false, nil, bundleDir)
+	fromBundle := models.Collections{
+		{Name: "file://" + repo, Type: "git", Version: "v1.0.0"},
+		{Name: "community.general", Version: ">=7.0.0"},
+	}
+	if err := inst.InstallMissing(t.Context(), models.File{}, fromBundle, nil); err != nil {
+		t.Fatalf("InstallMissing() from bundle error = %v", err)
+	}
+	for _, file := range []string{
+		filepath.Join("ansible_collections", "org", "foo", "plugins", "modules", "bar.py"),
+		filepath.Join("ansible_collections", "community", "general", "plugins", "modules", "foo.py"),
+		filepath.Join("ansible_collections", "community.general-8.0.0.info", "GALAXY.yml"),
+	} {
+		if _, err := os.Stat(filepath.Join(collectionsPath, file)); err != nil {
+			t.Errorf("InstallMissing() should copy the bundled %s: %v", file, err)
+		}
+	}
+
+	for _, missing := range []*models.Collection{
+		{Name: "file://" + repo, Type: "git", Version: "v2.0.0"},
+		{Name: "community.general", Version: ">=9.0.0"},
+		{Name: "community.docker", Version: ">=1.0.0"},
+	} {
+		if _, _, _, err := inst.processCollection(t.Context(), missing); err == nil {
+			t.Errorf("processCollection(%s@%s) error = nil, want error for collection not in the bundle", missing.Name, missing.Version)
+		}
+	}
+}
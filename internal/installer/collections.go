@@ -0,0 +1,297 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/etkecc/agru/internal/archive"
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/tarball"
+	"github.com/etkecc/agru/internal/versions"
+)
+
+// galaxyCollectionInfo is the ansible_collections/namespace.name-version.info/GALAXY.yml structure
+type galaxyCollectionInfo struct {
+	DownloadURL   string   `yaml:"download_url"`
+	FormatVersion string   `yaml:"format_version"`
+	Name          string   `yaml:"name"`
+	Namespace     string   `yaml:"namespace"`
+	Server        string   `yaml:"server"`
+	Signatures    []string `yaml:"signatures"`
+	Version       string   `yaml:"version"`
+	VersionURL    string   `yaml:"version_url"`
+}
+
+// cachedCollection is the resolved version of the cached collection artifact, written next to it (see cache.MetaPath),
+// as the artifact is cached by the version constraint
+type cachedCollection struct {
+	Version     string `yaml:"version"`
+	SHA256      string `yaml:"sha256"`
+	DownloadURL string `yaml:"download_url"`
+	VersionURL  string `yaml:"version_url"`
+	Server      string `yaml:"server"`
+}
+
+// processCollection checks and installs a single collection.
+// Returns the previously installed version, whether the collection was installed/updated, a verbose log line, and any error.
+func (i *Installer) processCollection(ctx context.Context, collection *models.Collection) (oldVersion string, installed bool, logLine string, err error) {
+	if i.collectionsPath == "" {
+		return "", false, "", fmt.Errorf("installing %s@%s: collections path is not set", collection.GetName(), collection.Version)
+	}
+	// fast path, works only when collection's namespace.name is known before cloning
+	if collection.IsInstalled(os.DirFS(i.collectionsPath)) {
+		return "", false, "", nil
+	}
+	if i.bundleDir != "" {
+		oldVersion, ok, logLine, err := i.installBundleCollection(ctx, collection)
+		if err != nil {
+			return "", false, logLine, fmt.Errorf("installing %s@%s: %w", collection.GetName(), collection.Version, err)
+		}
+		return oldVersion, ok, logLine, nil
+	}
+	install := i.installGitCollection
+	if !collection.IsGit() {
+		install = i.installGalaxyCollection
+	}
+	release, err := i.hosts.Acquire(ctx, collection.Host())
+	if err != nil {
+		return "", false, "", err
+	}
+	defer release()
+	oldVersion, ok, logLine, err := install(ctx, collection)
+	if err != nil {
+		return "", false, logLine, fmt.Errorf("installing %s@%s: %w", collection.GetName(), collection.Version, err)
+	}
+	return oldVersion, ok, logLine, nil
+}
+
+// installGitCollection writes specific collection version from a git repository (or its cached mirror) to the target collections dir,
+// as ansible_collections/namespace/name, where namespace and name are taken from the collection's galaxy.yml or MANIFEST.json.
+// Returns the previously installed version, whether the collection was installed, a verbose log line, and any error.
+func (i *Installer) installGitCollection(ctx context.Context, collection *models.Collection) (oldVersion string, installed bool, log string, err error) {
+	name := collection.GetName()
+	repo := collection.Repo()
+	tmpdir, err := os.MkdirTemp("", "agru-"+name+"-*")
+	if err != nil {
+		return "", false, "", fmt.Errorf("creating tmp dir: %w", err)
+	}
+	tmpfile := tmpdir + ".tar"
+	srcdir := tmpdir + ".src"
+	if i.cleanup {
+		defer i.cleanupRole(tmpdir, tmpfile)
+		defer os.RemoveAll(srcdir)
+	}
+
+	backend := i.gitVCS()
+	logLine := fmt.Sprintf("[%s] cloning %s @ %s", name, repo, collection.Version)
+	dir, sha, err := backend.clone(ctx, repo, collection.Version, tmpdir)
+	if err != nil {
+		return "", false, logLine, err
+	}
+	// export the source at the cloned commit, as the cached mirror has no work tree to read the metadata from
+	if err := backend.archive(ctx, dir, sha, "", tmpfile); err != nil {
+		return "", false, logLine, err
+	}
+	if err := tarball.Extract(tmpfile, srcdir, 0); err != nil {
+		return "", false, logLine, fmt.Errorf("extracting archive: %w", err)
+	}
+	meta, err := models.ParseCollectionMeta(os.DirFS(srcdir))
+	if err != nil {
+		return "", false, logLine, fmt.Errorf("reading collection metadata: %w", err)
+	}
+	collection.SetFQCN(meta.Namespace, meta.Name)
+	logLine = fmt.Sprintf("[%s] cloned %s @ %s (sha: %s) as %s.%s", name, repo, collection.Version, sha, meta.Namespace, meta.Name)
+
+	// check if the collection is already installed
+	cachedInfo, _ := collection.GetInstallInfo(os.DirFS(i.collectionsPath)) //nolint:errcheck // parse failure → empty commit → will reinstall
+	if sha != "" && cachedInfo.InstallCommit == sha && cachedInfo.Version == collection.Version {
+		return "", false, logLine, nil
+	}
+
+	// copy the source into the staged collection dir
+	err = i.replace(ctx, collection.GetName(), path.Join(i.collectionsPath, collection.GetPath()), func(staged string) error {
+		if err := copyDir(srcdir, staged); err != nil {
+			return fmt.Errorf("copying collection dir: %w", err)
+		}
+		outb, err := collection.GenerateInstallInfo(sha)
+		if err != nil {
+			return fmt.Errorf("generating install info: %w", err)
+		}
+		if err := os.WriteFile(path.Join(staged, ".galaxy_install_info"), outb, 0o600); err != nil {
+			return fmt.Errorf("writing install info: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, logLine, err
+	}
+	return cachedInfo.Version, true, logLine, nil
+}
+
+// installGalaxyCollection downloads the highest collection version that satisfies the collection's version constraint
+// from the Galaxy servers (in priority order), verifies its sha256 checksum and writes it to the target collections dir.
+// With the cache, the artifact is cached by the version constraint together with its resolved version and checksum,
+// so it can be installed offline, see verifyCachedCollection.
+// Returns the previously installed version, whether the collection was installed, a verbose log line, and any error.
+func (i *Installer) installGalaxyCollection(ctx context.Context, collection *models.Collection) (oldVersion string, installed bool, log string, err error) {
+	if i.galaxy == nil {
+		return "", false, "", errors.New("galaxy client is not configured")
+	}
+	namespace, name, ok := collection.GetFQCN()
+	if !ok {
+		return "", false, "", fmt.Errorf("collection name %q is not in namespace.name format", collection.Name)
+	}
+
+	tmpfile, err := os.CreateTemp("", "agru-"+collection.GetName()+"-*.tar.gz")
+	if err != nil {
+		return "", false, "", fmt.Errorf("creating tmp file: %w", err)
+	}
+	tmpfile.Close()
+	if i.cleanup {
+		defer os.Remove(tmpfile.Name())
+	}
+
+	// the version is resolved when the artifact is downloaded, or read from the cached artifact's metadata
+	version := galaxy.CollectionVersion{Namespace: namespace, Name: name}
+	var downloaded bool
+	logLine := fmt.Sprintf("[%s] downloading %s.%s:%s", collection.GetName(), namespace, name, collection.Version)
+	key := "galaxy-collection:" + namespace + "." + name + "-" + collection.Version + ".tar.gz"
+	archivePath, err := i.download(key, tmpfile.Name(), func(dst string) error {
+		resolved, err := i.galaxy.CollectionVersion(ctx, collection.Source, namespace, name, collection.Version)
+		if err != nil {
+			return err
+		}
+		version = resolved
+		logLine = fmt.Sprintf("[%s] downloading %s.%s:%s from %s", collection.GetName(), namespace, name, version.Version, version.DownloadURL)
+		if err := i.galaxy.Download(ctx, version.DownloadURL, dst, version.SHA256); err != nil {
+			return err
+		}
+		downloaded = true
+		return nil
+	}, func(archivePath string) error {
+		cached, err := verifyCachedCollection(archivePath, collection.Version)
+		if err != nil {
+			return err
+		}
+		version.Version, version.SHA256 = cached.Version, cached.SHA256
+		version.DownloadURL, version.VersionURL, version.Server = cached.DownloadURL, cached.VersionURL, cached.Server
+		logLine = fmt.Sprintf("[%s] using cached %s.%s:%s", collection.GetName(), namespace, name, version.Version)
+		return nil
+	})
+	if err != nil {
+		return "", false, logLine, err
+	}
+	if downloaded && i.cache != nil {
+		if err := writeCachedCollection(archivePath, version); err != nil {
+			return "", false, logLine, err
+		}
+	}
+	oldVersion = collection.GetInstalledVersion(os.DirFS(i.collectionsPath))
+
+	// extract the archive into the staged collection dir, collection artifacts have no top-level dir
+	err = i.replace(ctx, collection.GetName(), path.Join(i.collectionsPath, collection.GetPath()), func(staged string) error {
+		if err := tarball.Extract(archivePath, staged, 0); err != nil {
+			return fmt.Errorf("extracting archive: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, logLine, err
+	}
+
+	if err := i.writeGalaxyCollectionInfo(ctx, collection.GetName(), version); err != nil {
+		return "", false, logLine, err
+	}
+	return oldVersion, true, logLine, nil
+}
+
+// writeCachedCollection writes the resolved version and checksum of the downloaded artifact next to the cached one
+func writeCachedCollection(archivePath string, version galaxy.CollectionVersion) error {
+	outb, err := yaml.Marshal(cachedCollection{
+		Version:     version.Version,
+		SHA256:      version.SHA256,
+		DownloadURL: version.DownloadURL,
+		VersionURL:  version.VersionURL,
+		Server:      version.Server,
+	})
+	if err != nil {
+		return fmt.Errorf("generating cached collection info: %w", err)
+	}
+	if err := os.WriteFile(cache.MetaPath(archivePath), outb, 0o600); err != nil {
+		return fmt.Errorf("writing cached collection info: %w", err)
+	}
+	return nil
+}
+
+// verifyCachedCollection reads the resolved version of the cached artifact, written by writeCachedCollection,
+// and checks that it satisfies the constraint and that the artifact matches its sha256 checksum
+func verifyCachedCollection(archivePath, constraint string) (cachedCollection, error) {
+	var cached cachedCollection
+	datab, err := os.ReadFile(cache.MetaPath(archivePath))
+	if err != nil {
+		return cached, fmt.Errorf("reading cached collection info: %w", err)
+	}
+	if err := yaml.Unmarshal(datab, &cached); err != nil {
+		return cached, fmt.Errorf("parsing cached collection info: %w", err)
+	}
+	if cached.Version == "" || cached.SHA256 == "" {
+		return cached, errors.New("cached collection info has no version or sha256 checksum")
+	}
+	if !versions.Satisfies(cached.Version, constraint) {
+		return cached, fmt.Errorf("cached version %s doesn't satisfy %s", cached.Version, constraint)
+	}
+	return cached, archive.Verify(archivePath, "sha256:"+cached.SHA256)
+}
+
+// writeGalaxyCollectionInfo writes ansible_collections/namespace.name-version.info/GALAXY.yml, same as ansible-galaxy does,
+// removing info dirs of the previously installed versions. name is the collection's name, see replace
+func (i *Installer) writeGalaxyCollectionInfo(ctx context.Context, name string, version galaxy.CollectionVersion) error {
+	if err := i.removeGalaxyCollectionInfo(name, version.Namespace, version.Name); err != nil {
+		return err
+	}
+
+	outb, err := yaml.Marshal(galaxyCollectionInfo{
+		DownloadURL:   version.DownloadURL,
+		FormatVersion: "1.0.0",
+		Name:          version.Name,
+		Namespace:     version.Namespace,
+		Server:        version.Server,
+		Signatures:    []string{},
+		Version:       version.Version,
+		VersionURL:    version.VersionURL,
+	})
+	if err != nil {
+		return fmt.Errorf("generating collection info: %w", err)
+	}
+	infoPath := path.Join(i.collectionsPath, "ansible_collections", version.Namespace+"."+version.Name+"-"+version.Version+".info")
+	return i.replace(ctx, name, infoPath, func(staged string) error {
+		if err := os.MkdirAll(staged, 0o700); err != nil {
+			return fmt.Errorf("creating collection info dir: %w", err)
+		}
+		if err := os.WriteFile(path.Join(staged, "GALAXY.yml"), outb, 0o600); err != nil {
+			return fmt.Errorf("writing collection info: %w", err)
+		}
+		return nil
+	})
+}
+
+// removeGalaxyCollectionInfo removes ansible_collections/namespace.name-*.info dirs of the previously installed versions
+// of the collection by the name, see removeDir
+func (i *Installer) removeGalaxyCollectionInfo(collectionName, namespace, name string) error {
+	infoGlob := path.Join(i.collectionsPath, "ansible_collections", namespace+"."+name+"-*.info")
+	oldInfos, _ := filepath.Glob(infoGlob) //nolint:errcheck // the only possible error is ErrBadPattern
+	for _, oldInfo := range oldInfos {
+		if err := i.removeDir(collectionName, oldInfo); err != nil {
+			return fmt.Errorf("removing old collection info: %w", err)
+		}
+	}
+	return nil
+}
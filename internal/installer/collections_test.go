@@ -0,0 +1,107 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+// makeGitRepo creates a local git repo with the given files committed and tagged
+func makeGitRepo(t *testing.T, files map[string]string, tag string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+		{"tag", tag},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	return dir
+}
+
+func TestInstallGitCollection(t *testing.T) {
+	repo := makeGitRepo(t, map[string]string{
+		"galaxy.yml":             "namespace: org\nname: foo\nversion: 1.0.0\n",
+		"plugins/modules/bar.py": "# module\n",
+	}, "v1.0.0")
+	collectionsPath := t.TempDir()
+
+	inst := New(runner.New(0), nil, t.TempDir(), collectionsPath, 0, nil, true, false, false, nil, "")
+	collection := &models.Collection{Name: "file://" + repo, Type: "git", Version: "v1.0.0"}
+
+	if err := inst.InstallMissing(t.Context(), models.File{}, models.Collections{collection}, nil); err != nil {
+		t.Fatalf("InstallMissing() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(collectionsPath, "ansible_collections", "org", "foo", "plugins", "modules", "bar.py")); err != nil {
+		t.Errorf("InstallMissing() should install collection as ansible_collections/org/foo: %v", err)
+	}
+	if !collection.IsInstalled(os.DirFS(collectionsPath)) {
+		t.Error("IsInstalled() = false after installation, install info is not written")
+	}
+
+	// second run is a no-op
+	oldVersion, installed, _, err := inst.processCollection(t.Context(), &models.Collection{Name: "file://" + repo, Type: "git", Version: "v1.0.0"})
+	if err != nil {
+		t.Fatalf("processCollection() error = %v", err)
+	}
+	if installed || oldVersion != "" {
+		t.Errorf("processCollection() = %q, %v, want no reinstall of the same commit", oldVersion, installed)
+	}
+}
+
+func TestInstallGitCollectionOffline(t *testing.T) {
+	repo := makeGitRepo(t, map[string]string{
+		"galaxy.yml":             "namespace: org\nname: foo\nversion: 1.0.0\n",
+		"plugins/modules/bar.py": "# module\n",
+	}, "v1.0.0")
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	inst := New(runner.New(0), nil, t.TempDir(), t.TempDir(), 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOff), "")
+	if _, _, _, err := inst.processCollection(t.Context(), &models.Collection{Name: "file://" + repo, Type: "git", Version: "v1.0.0"}); err != nil {
+		t.Fatalf("processCollection() error = %v", err)
+	}
+	if err := os.RemoveAll(repo); err != nil { // no network, no repo
+		t.Fatal(err)
+	}
+
+	collectionsPath := t.TempDir()
+	inst = New(runner.New(0), nil, t.TempDir(), collectionsPath, 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOn), "")
+	if _, _, _, err := inst.processCollection(t.Context(), &models.Collection{Name: "file://" + repo, Type: "git", Version: "v1.0.0"}); err != nil {
+		t.Fatalf("processCollection() offline error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(collectionsPath, "ansible_collections", "org", "foo", "plugins", "modules", "bar.py")); err != nil {
+		t.Errorf("processCollection() offline should install the collection from the cached mirror: %v", err)
+	}
+	if _, _, _, err := inst.processCollection(t.Context(), &models.Collection{Name: "file://" + repo, Type: "git", Version: "v2.0.0"}); !errors.Is(err, cache.ErrNotCached) {
+		t.Errorf("processCollection() offline error = %v, want %v", err, cache.ErrNotCached)
+	}
+}
+
+func TestInstallGitCollectionWithoutMetadata(t *testing.T) {
+	repo := makeGitRepo(t, map[string]string{"README.md": "# not a collection\n"}, "v1.0.0")
+	inst := New(runner.New(0), nil, t.TempDir(), t.TempDir(), 0, nil, true, false, false, nil, "")
+	collection := &models.Collection{Name: "file://" + repo, Type: "git", Version: "v1.0.0"}
+
+	if _, _, _, err := inst.processCollection(t.Context(), collection); err == nil {
+		t.Error("processCollection() expected error for repo without galaxy.yml, got nil")
+	}
+}
@@ -0,0 +1,98 @@
+package installer
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/etkecc/go-kit/workpool"
+
+	"github.com/etkecc/agru/internal/models"
+)
+
+// installRun is the shared state of a single InstallMissing call
+type installRun struct {
+	wp       *workpool.WorkPool
+	deps     *resolver // nil if dependencies are not installed
+	fsys     fs.FS
+	progress chan<- Progress
+
+	mu      sync.Mutex
+	changes models.UpdatedItems
+	errs    []error
+}
+
+// fail records the error and reports it to the progress channel (if non-nil)
+func (run *installRun) fail(p Progress) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.errs = append(run.errs, p.Err)
+	if run.progress != nil {
+		run.progress <- p
+	}
+}
+
+// requirement is a role required either by the requirements file or by another role
+type requirement struct {
+	version    string
+	requiredBy string        // empty for roles from the requirements file
+	dep        *models.Entry // nil for roles from the requirements file
+}
+
+// resolver tracks required roles to install each dependency once and detect version conflicts
+type resolver struct {
+	mu       sync.Mutex
+	required map[string]requirement
+}
+
+// newResolver creates a new resolver with roles from the requirements file
+func newResolver(entries models.File) *resolver {
+	r := &resolver{required: make(map[string]requirement, len(entries))}
+	for _, entry := range entries {
+		if entry.Include != "" {
+			continue
+		}
+		r.required[entry.GetName()] = requirement{version: entry.Version}
+	}
+	return r
+}
+
+// add registers the dependency of the parent role.
+// Returns true if the dependency is seen for the first time and should be installed,
+// or an error if the dependency is already required by another role in a different version.
+// Roles from the requirements file take precedence over dependencies, so they never conflict.
+func (r *resolver) add(dep *models.Entry, parent string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := dep.GetName()
+	existing, ok := r.required[name]
+	if !ok {
+		r.required[name] = requirement{version: dep.Version, requiredBy: parent, dep: dep}
+		return true, nil
+	}
+	if existing.requiredBy == "" || existing.requiredBy == parent {
+		return false, nil
+	}
+	if dep.Version != "" && existing.version != "" && dep.Version != existing.version {
+		return false, fmt.Errorf("version conflict: %s@%s is required by %s, but %s@%s is required by %s",
+			name, dep.Version, parent, name, existing.version, existing.requiredBy)
+	}
+	return false, nil
+}
+
+// dependencies returns the dependencies registered during the run, sorted by name
+func (r *resolver) dependencies() models.File {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deps := models.File{}
+	for _, name := range slices.Sorted(maps.Keys(r.required)) {
+		if dep := r.required[name].dep; dep != nil {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
@@ -0,0 +1,136 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+// makeLocalRole creates a local role dir with the given meta/main.yml content
+func makeLocalRole(t *testing.T, base, name, meta string) string {
+	t.Helper()
+	dir := filepath.Join(base, name)
+	if err := os.MkdirAll(filepath.Join(dir, "meta"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta", "main.yml"), []byte(meta), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestInstallMissingDependencies(t *testing.T) {
+	base := t.TempDir()
+	makeLocalRole(t, base, "leaf", "---\n")
+	makeLocalRole(t, base, "middle", "dependencies:\n  - src: "+filepath.Join(base, "leaf")+"\n")
+	parent := makeLocalRole(t, base, "parent", "dependencies:\n  - src: "+filepath.Join(base, "middle")+"\n  - common\n")
+
+	rolesPath := t.TempDir()
+	inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, nil, "")
+	progress := make(chan Progress, 64)
+	if err := inst.InstallMissing(t.Context(), models.File{{Src: parent}}, nil, progress); err != nil {
+		t.Fatalf("InstallMissing() error = %v", err)
+	}
+
+	requiredBy := map[string]string{}
+	for p := range progress {
+		if p.Status == "done" {
+			requiredBy[p.Name] = p.RequiredBy
+		}
+	}
+	expected := map[string]string{"parent": "", "middle": "parent", "leaf": "middle"}
+	for name, parentName := range expected {
+		if _, err := os.Stat(filepath.Join(rolesPath, name, "meta", "main.yml")); err != nil {
+			t.Errorf("InstallMissing() should install %s: %v", name, err)
+		}
+		if got, ok := requiredBy[name]; !ok || got != parentName {
+			t.Errorf("Progress for %s: RequiredBy = %q, want %q", name, got, parentName)
+		}
+	}
+}
+
+func TestInstallMissingFrozenDependencies(t *testing.T) {
+	base := t.TempDir()
+	leaf := makeLocalRole(t, base, "leaf", "---\n")
+	parent := makeLocalRole(t, base, "parent", "dependencies:\n  - src: "+leaf+"\n")
+
+	t.Run("locked", func(t *testing.T) {
+		inst := New(runner.New(0), nil, t.TempDir(), "", 0, nil, true, false, false, nil, "")
+		inst.SetLock(&models.Lock{Roles: []*models.LockedRole{
+			{Name: "parent", Src: parent},
+			{Name: "leaf", Src: leaf, Dependency: true},
+		}})
+		if err := inst.InstallMissing(t.Context(), models.File{{Src: parent}}, nil, nil); err != nil {
+			t.Fatalf("InstallMissing() error = %v", err)
+		}
+		deps := inst.Dependencies()
+		if len(deps) != 1 || deps[0].GetName() != "leaf" {
+			t.Errorf("Dependencies() = %v, want leaf", deps)
+		}
+	})
+
+	t.Run("not locked", func(t *testing.T) {
+		rolesPath := t.TempDir()
+		inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, nil, "")
+		inst.SetLock(&models.Lock{Roles: []*models.LockedRole{{Name: "parent", Src: parent}}})
+		err := inst.InstallMissing(t.Context(), models.File{{Src: parent}}, nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "dependency leaf is not in the lockfile") {
+			t.Errorf("InstallMissing() error = %v, want the dependency missing in the lockfile", err)
+		}
+		if _, err := os.Stat(filepath.Join(rolesPath, "leaf")); !os.IsNotExist(err) {
+			t.Errorf("InstallMissing() should not install the dependency missing in the lockfile, got: %v", err)
+		}
+	})
+}
+
+func TestInstallMissingNoDeps(t *testing.T) {
+	base := t.TempDir()
+	makeLocalRole(t, base, "leaf", "---\n")
+	parent := makeLocalRole(t, base, "parent", "dependencies:\n  - src: "+filepath.Join(base, "leaf")+"\n")
+
+	rolesPath := t.TempDir()
+	inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, true, false, nil, "")
+	if err := inst.InstallMissing(t.Context(), models.File{{Src: parent}}, nil, nil); err != nil {
+		t.Fatalf("InstallMissing() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "leaf")); !os.IsNotExist(err) {
+		t.Errorf("InstallMissing() should not install dependencies with noDeps, got: %v", err)
+	}
+	if deps := inst.Dependencies(); deps != nil {
+		t.Errorf("Dependencies() = %v, want nil with noDeps", deps)
+	}
+}
+
+func TestInstallMissingDependencyConflict(t *testing.T) {
+	base := t.TempDir()
+	leaf := makeLocalRole(t, base, "leaf", "---\n")
+	a := makeLocalRole(t, base, "a", "dependencies:\n  - src: "+leaf+"\n    version: v1.0.0\n")
+	b := makeLocalRole(t, base, "b", "dependencies:\n  - src: "+leaf+"\n    version: v2.0.0\n")
+
+	inst := New(runner.New(0), nil, t.TempDir(), "", 1, nil, true, false, false, nil, "")
+	err := inst.InstallMissing(t.Context(), models.File{{Src: a}, {Src: b}}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "version conflict") {
+		t.Errorf("InstallMissing() error = %v, want version conflict", err)
+	}
+}
+
+func TestResolverRequirementsFilePrecedence(t *testing.T) {
+	r := newResolver(models.File{{Src: "geerlingguy.docker", Version: "7.0.0"}})
+
+	schedule, err := r.add(&models.Entry{Src: "geerlingguy.docker", Version: "6.0.0"}, "parent")
+	if err != nil || schedule {
+		t.Errorf("add() = %v, %v, want the requirements file version to take precedence", schedule, err)
+	}
+	schedule, err = r.add(&models.Entry{Src: "geerlingguy.pip"}, "parent")
+	if err != nil || !schedule {
+		t.Errorf("add() = %v, %v, want new dependency to be scheduled", schedule, err)
+	}
+	schedule, err = r.add(&models.Entry{Src: "geerlingguy.pip", Version: "2.0.0"}, "other")
+	if err != nil || schedule {
+		t.Errorf("add() = %v, %v, want dependency without version to be compatible", schedule, err)
+	}
+}
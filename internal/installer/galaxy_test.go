@@ -0,0 +1,246 @@
+package installer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/agru/internal/archive"
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+// makeTarGz creates a gzipped tarball with the given files
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newGalaxyServer returns a fake Galaxy server with a single geerlingguy.docker role in version 6.1.0
+func newGalaxyServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	archive := makeTarGz(t, map[string]string{
+		"ansible-role-docker-6.1.0/tasks/main.yml": "---\n",
+		"ansible-role-docker-6.1.0/meta/main.yml":  "---\n",
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"available_versions": {"v1": "v1/"}}`))
+	})
+	mux.HandleFunc("/api/v1/roles/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"results": [{"id": 1, "github_user": "geerlingguy", "github_repo": "ansible-role-docker"}]}`))
+	})
+	mux.HandleFunc("/api/v1/roles/1/versions/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"results": [{"name": "6.1.0", "download_url": "/download/6.1.0.tar.gz"}]}`))
+	})
+	mux.HandleFunc("/download/6.1.0.tar.gz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(archive)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestInstallGalaxyRole(t *testing.T) {
+	srv := newGalaxyServer(t)
+	rolesPath := t.TempDir()
+	// stale file from the previous version should be removed
+	if err := os.MkdirAll(filepath.Join(rolesPath, "geerlingguy.docker"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rolesPath, "geerlingguy.docker", "stale.yml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	inst := New(runner.New(0), galaxy.New(galaxy.Server{URL: srv.URL}), rolesPath, "", 0, nil, true, false, false, nil, "")
+	entry := &models.Entry{Src: "geerlingguy.docker", Version: "6.1.0"}
+
+	ok, _, err := inst.installRole(t.Context(), entry)
+	if err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+	if !ok {
+		t.Error("installRole() = false, want true for new installation")
+	}
+
+	if _, err := os.Stat(filepath.Join(rolesPath, "geerlingguy.docker", "tasks", "main.yml")); err != nil {
+		t.Errorf("installRole() should extract the archive without top-level dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "geerlingguy.docker", "stale.yml")); !os.IsNotExist(err) {
+		t.Errorf("installRole() should remove stale files, got: %v", err)
+	}
+	if !entry.IsInstalled(os.DirFS(rolesPath)) {
+		t.Error("IsInstalled() = false after galaxy installation, install info is not written")
+	}
+}
+
+func TestInstallGalaxyRoleMissingVersion(t *testing.T) {
+	srv := newGalaxyServer(t)
+	inst := New(runner.New(0), galaxy.New(galaxy.Server{URL: srv.URL}), t.TempDir(), "", 0, nil, true, false, false, nil, "")
+	entry := &models.Entry{Src: "geerlingguy.docker", Version: "9.9.9"}
+
+	if _, _, err := inst.installRole(t.Context(), entry); err == nil {
+		t.Error("installRole() expected error for missing galaxy version, got nil")
+	}
+}
+
+// newCollectionServer returns a fake Galaxy v3 server with a single community.general collection in version 8.0.0
+func newCollectionServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	archive := makeTarGz(t, map[string]string{
+		"MANIFEST.json":               `{"collection_info": {"namespace": "community", "name": "general", "version": "8.0.0"}}`,
+		"plugins/modules/foo.py":      "# module\n",
+		"plugins/modules/__init__.py": "",
+	})
+	sum := sha256.Sum256(archive)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"available_versions": {"v3": "v3/"}}`))
+	})
+	mux.HandleFunc("/api/v3/collections/community/general/versions/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"links": {"next": null}, "data": [{"version": "7.0.0"}, {"version": "8.0.0"}]}`))
+	})
+	mux.HandleFunc("/api/v3/collections/community/general/versions/8.0.0/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"version": "8.0.0", "download_url": "/download/community-general-8.0.0.tar.gz", "artifact": {"sha256": "` + hex.EncodeToString(sum[:]) + `"}}`))
+	})
+	mux.HandleFunc("/download/community-general-8.0.0.tar.gz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(archive)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestInstallGalaxyCollection(t *testing.T) {
+	srv := newCollectionServer(t)
+	collectionsPath := t.TempDir()
+	// info dir of the previous version should be replaced
+	oldInfo := filepath.Join(collectionsPath, "ansible_collections", "community.general-7.0.0.info")
+	if err := os.MkdirAll(oldInfo, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	inst := New(runner.New(0), galaxy.New(galaxy.Server{URL: srv.URL}), t.TempDir(), collectionsPath, 0, nil, true, false, false, nil, "")
+	collection := &models.Collection{Name: "community.general", Version: ">=7.0.0"}
+
+	if err := inst.InstallMissing(t.Context(), models.File{}, models.Collections{collection}, nil); err != nil {
+		t.Fatalf("InstallMissing() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(collectionsPath, "ansible_collections", "community", "general", "plugins", "modules", "foo.py")); err != nil {
+		t.Errorf("InstallMissing() should install collection as ansible_collections/community/general: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(collectionsPath, "ansible_collections", "community.general-8.0.0.info", "GALAXY.yml")); err != nil {
+		t.Errorf("InstallMissing() should write GALAXY.yml: %v", err)
+	}
+	if _, err := os.Stat(oldInfo); !os.IsNotExist(err) {
+		t.Errorf("InstallMissing() should remove info dir of the previous version, got: %v", err)
+	}
+	if got := collection.GetInstalledVersion(os.DirFS(collectionsPath)); got != "8.0.0" {
+		t.Errorf("GetInstalledVersion() = %q, want 8.0.0", got)
+	}
+	if !collection.IsInstalled(os.DirFS(collectionsPath)) {
+		t.Error("IsInstalled() = false after installation")
+	}
+}
+
+func TestInstallGalaxyCollectionOffline(t *testing.T) {
+	srv := newCollectionServer(t)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	g := galaxy.New(galaxy.Server{URL: srv.URL})
+	inst := New(runner.New(0), g, t.TempDir(), t.TempDir(), 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOff), "")
+	if _, _, _, err := inst.processCollection(t.Context(), &models.Collection{Name: "community.general", Version: ">=7.0.0"}); err != nil {
+		t.Fatalf("processCollection() error = %v", err)
+	}
+	srv.Close()
+
+	collectionsPath := t.TempDir()
+	inst = New(runner.New(0), g, t.TempDir(), collectionsPath, 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOn), "")
+	collection := &models.Collection{Name: "community.general", Version: ">=7.0.0"}
+	if _, _, _, err := inst.processCollection(t.Context(), collection); err != nil {
+		t.Fatalf("processCollection() offline error = %v", err)
+	}
+	if got := collection.GetInstalledVersion(os.DirFS(collectionsPath)); got != "8.0.0" {
+		t.Errorf("GetInstalledVersion() = %q, want the cached 8.0.0", got)
+	}
+	if _, err := os.Stat(filepath.Join(collectionsPath, "ansible_collections", "community.general-8.0.0.info", "GALAXY.yml")); err != nil {
+		t.Errorf("processCollection() offline should write GALAXY.yml: %v", err)
+	}
+	if _, _, _, err := inst.processCollection(t.Context(), &models.Collection{Name: "community.general", Version: ">=9.0.0"}); !errors.Is(err, cache.ErrNotCached) {
+		t.Errorf("processCollection() offline error = %v, want %v", err, cache.ErrNotCached)
+	}
+
+	metas, err := filepath.Glob(filepath.Join(cacheDir, cache.KindArchive, "*.tar.gz.meta"))
+	if err != nil || len(metas) != 1 {
+		t.Fatalf("cached collection info = %v, %v, want one file next to the cached artifact", metas, err)
+	}
+	archivePath := strings.TrimSuffix(metas[0], ".meta")
+	if err := os.WriteFile(archivePath, []byte("tampered"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	inst = New(runner.New(0), g, t.TempDir(), t.TempDir(), 0, nil, true, false, false, cache.New(runner.New(0), cacheDir, cache.OfflineOn), "")
+	if _, _, _, err := inst.processCollection(t.Context(), &models.Collection{Name: "community.general", Version: ">=7.0.0"}); !errors.Is(err, archive.ErrChecksumMismatch) {
+		t.Errorf("processCollection() offline error = %v, want %v of the tampered artifact", err, archive.ErrChecksumMismatch)
+	}
+}
+
+func TestVerifyCachedCollection(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "community.general-8.0.0.tar.gz")
+	if err := os.WriteFile(archivePath, []byte("artifact"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("artifact"))
+	if _, err := verifyCachedCollection(archivePath, ">=7.0.0"); err == nil {
+		t.Error("verifyCachedCollection() error = nil, want error without the cached collection info")
+	}
+	if err := writeCachedCollection(archivePath, galaxy.CollectionVersion{Version: "8.0.0", SHA256: hex.EncodeToString(sum[:])}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		constraint string
+		wantErr    bool
+	}{
+		{">=7.0.0", false},
+		{"", false},
+		{"8.0.0", false},
+		{">=9.0.0", true},
+		{"<8.0.0", true},
+	}
+	for _, tt := range tests {
+		cached, err := verifyCachedCollection(archivePath, tt.constraint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("verifyCachedCollection(%q) error = %v, wantErr %v", tt.constraint, err, tt.wantErr)
+		}
+		if err == nil && cached.Version != "8.0.0" {
+			t.Errorf("verifyCachedCollection(%q) = %q, want 8.0.0", tt.constraint, cached.Version)
+		}
+	}
+}
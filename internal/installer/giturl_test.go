@@ -0,0 +1,78 @@
+package installer
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+// makeBareRepo creates a local bare git repo with the given files committed and tagged
+func makeBareRepo(t *testing.T, files map[string]string, tag string) string {
+	t.Helper()
+	bare := filepath.Join(t.TempDir(), "ansible-role-foo.git")
+	cmd := exec.Command("git", "clone", "-q", "--bare", makeGitRepo(t, files, tag), bare)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git clone --bare: %v\n%s", err, out)
+	}
+	return bare
+}
+
+// stubSSH points git to an ssh stub that runs the remote command locally and logs the ssh args
+func stubSSH(t *testing.T) (logPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	logPath = filepath.Join(dir, "ssh.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + logPath + "\nfor last; do :; done\nexec sh -c \"$last\"\n"
+	stub := filepath.Join(dir, "ssh")
+	if err := os.WriteFile(stub, []byte(script), 0o700); err != nil { //nolint:gosec // the stub must be executable
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_SSH_COMMAND", stub)
+	t.Setenv("GIT_SSH_VARIANT", "ssh")
+	return logPath
+}
+
+func TestInstallRoleGitURLForms(t *testing.T) {
+	bare := makeBareRepo(t, map[string]string{"tasks/main.yml": "---\n"}, "v1.0.0")
+	logPath := stubSSH(t)
+
+	tests := []struct {
+		name string
+		src  string
+		ssh  bool
+	}{
+		{"file", "file://" + bare, false},
+		{"git+file", "git+file://" + bare, false},
+		{"ssh", "ssh://git@git.example.com" + bare, true},
+		{"git+ssh", "git+ssh://git@git.example.com" + bare, true},
+		{"scp-style", "git@git.example.com:" + bare, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(logPath)
+			rolesPath := t.TempDir()
+			inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, nil, "")
+			entry := &models.Entry{Src: tt.src, Version: "v1.0.0"}
+
+			if name := entry.GetName(); name != "ansible-role-foo" {
+				t.Errorf("GetName() = %q, want ansible-role-foo", name)
+			}
+			if _, _, err := inst.installRole(t.Context(), entry); err != nil {
+				t.Fatalf("installRole() error = %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(rolesPath, "ansible-role-foo", "tasks", "main.yml")); err != nil {
+				t.Errorf("installRole() should install the role: %v", err)
+			}
+
+			logb, _ := os.ReadFile(logPath) //nolint:errcheck // missing log means ssh was not used
+			if used := strings.Contains(string(logb), "git@git.example.com"); used != tt.ssh {
+				t.Errorf("ssh used = %v, want %v, ssh log: %q", used, tt.ssh, logb)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+// cloneHgRepo clones the hg repo at the specific version (tag, branch or changeset) into the dir.
+// Returns the changeset hash of the cloned working copy.
+func (i *Installer) cloneHgRepo(ctx context.Context, repo, version, dir string) (string, error) {
+	args := []string{"hg", "clone", "-q"}
+	if version != "" {
+		args = append(args, "-u", version)
+	}
+	cmd := runner.Cmd("", append(args, repo, dir)...)
+	cmd.BeforeRetry = func() error { return os.RemoveAll(dir) } // hg doesn't clone into the non-empty dir
+	if _, err := i.runner.Run(ctx, cmd); err != nil {
+		return "", fmt.Errorf("cloning repo: %w", err)
+	}
+
+	sha, err := i.runner.Run(ctx, runner.Cmd(dir, "hg", "log", "-r", ".", "-T", "{node}"))
+	if err != nil {
+		return "", fmt.Errorf("getting changeset hash: %w", err)
+	}
+	return sha, nil
+}
+
+// archiveHgRepo creates a tar archive of the cloned hg repo at the specific version, with all paths prefixed
+func (i *Installer) archiveHgRepo(ctx context.Context, dir, version, prefix, tmpfile string) error {
+	if version == "" {
+		version = "."
+	}
+	if _, err := i.runner.Run(ctx, runner.Cmd(dir, "hg", "archive", "-t", "tar", "--prefix="+prefix, "-r", version, tmpfile)); err != nil {
+		return fmt.Errorf("archiving repo: %w", err)
+	}
+	return nil
+}
+
+// hgRefType returns the type of the version in the cloned hg repo dir (see models.Ref* constants), empty if unknown.
+// The version is looked up same as hg does: bookmarks, tags, then branches. Bookmarks, branches and tip are recorded
+// as branches, as their head moves, and anything else (e.g. a changeset hash) is a commit
+func (i *Installer) hgRefType(ctx context.Context, dir, version string) string {
+	switch {
+	case version == "" || version == "tip":
+		return models.RefBranch
+	case len(version) >= 40:
+		return models.RefCommit
+	}
+	for _, namespace := range []struct {
+		args    []string
+		refType string
+	}{
+		{[]string{"hg", "bookmarks", "-q"}, models.RefBranch},
+		{[]string{"hg", "tags", "-q"}, models.RefTag},
+		{[]string{"hg", "branches", "-c", "-q"}, models.RefBranch},
+	} {
+		out, err := i.runner.Run(ctx, runner.Cmd(dir, namespace.args...))
+		if err != nil {
+			return ""
+		}
+		for _, name := range strings.Split(out, "\n") {
+			if strings.TrimSpace(name) == version {
+				return namespace.refType
+			}
+		}
+	}
+	return models.RefCommit
+}
+
+// remoteHgChangeset returns the changeset hash of the hg role's version (the default branch if empty) in its remote repo
+func (i *Installer) remoteHgChangeset(ctx context.Context, entry *models.Entry) (sha, refType string, err error) {
+	release, err := i.hosts.Acquire(ctx, i.entryHost(entry))
+	if err != nil {
+		return "", "", err
+	}
+	defer release()
+
+	rev := entry.Version
+	if rev == "" {
+		rev = "default"
+	}
+	sha, err = i.runner.Run(ctx, runner.Cmd("", "hg", "identify", "--debug", "-i", "-r", rev, entry.Repo()))
+	if err != nil {
+		return "", "", fmt.Errorf("identifying changeset: %w", err)
+	}
+	return strings.TrimSpace(sha), "", nil
+}
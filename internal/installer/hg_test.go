@@ -0,0 +1,162 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+func TestInstallHgRole(t *testing.T) {
+	rolesPath := t.TempDir()
+	changeset := "0123456789abcdef0123456789abcdef01234567"
+	calledCmds := []string{}
+
+	inst := &Installer{
+		runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+			command := cmd.String()
+			calledCmds = append(calledCmds, command)
+			if strings.HasPrefix(command, "hg log") {
+				return changeset, nil
+			}
+			if strings.HasPrefix(command, "hg archive") {
+				return "", fakeArchive(command)
+			}
+			if command == "hg tags -q" {
+				return "tip\nv1.0.0", nil
+			}
+			return "", nil
+		}},
+		fsys:      os.DirFS(rolesPath),
+		rolesPath: rolesPath,
+	}
+	entry := &models.Entry{Src: "https://hg.example.com/my-role", Scm: "hg", Version: "v1.0.0"}
+
+	ok, _, err := inst.installRole(t.Context(), entry)
+	if err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+	if !ok {
+		t.Error("installRole() = false, want true for new installation")
+	}
+
+	expected := []string{
+		"hg clone -q -u v1.0.0 https://hg.example.com/my-role ",
+		"hg log -r . -T {node}",
+		"hg archive -t tar --prefix=my-role/ -r v1.0.0 ",
+		"hg bookmarks -q",
+		"hg tags -q",
+	}
+	if len(calledCmds) != len(expected) {
+		t.Fatalf("installRole() called %v, want %d commands", calledCmds, len(expected))
+	}
+	for idx, prefix := range expected {
+		if !strings.HasPrefix(calledCmds[idx], prefix) {
+			t.Errorf("installRole() command #%d = %q, want prefix %q", idx, calledCmds[idx], prefix)
+		}
+	}
+
+	info, err := entry.GetInstallInfo(os.DirFS(rolesPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.InstallCommit != changeset || info.InstallRefType != models.RefTag {
+		t.Errorf("install info = %+v, want the hg changeset %q and the tag ref type", info, changeset)
+	}
+}
+
+func TestHgRefType(t *testing.T) {
+	inst := &Installer{runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+		switch cmd.String() {
+		case "hg bookmarks -q":
+			return "feature-x", nil
+		case "hg tags -q":
+			return "tip\nv1.0.0\nv0.9.0", nil
+		case "hg branches -c -q":
+			return "default\nstable", nil
+		}
+		return "", errors.New("unexpected command")
+	}}}
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{"", models.RefBranch},
+		{"tip", models.RefBranch},
+		{"default", models.RefBranch},
+		{"stable", models.RefBranch},
+		{"feature-x", models.RefBranch},
+		{"v1.0.0", models.RefTag},
+		{"0123456789abcdef0123456789abcdef01234567", models.RefCommit},
+		{"01234567", models.RefCommit},
+	}
+	for _, tt := range tests {
+		if got := inst.hgRefType(t.Context(), "", tt.version); got != tt.expected {
+			t.Errorf("hgRefType(%q) = %q, want %q", tt.version, got, tt.expected)
+		}
+	}
+}
+
+func TestProcessEntryHgBranch(t *testing.T) {
+	installed := "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name     string
+		version  string
+		refType  string
+		head     string
+		expected bool // reinstalled
+	}{
+		{"default branch not moved", "default", models.RefBranch, installed, false},
+		{"default branch moved", "default", models.RefBranch, "fedcba9876543210fedcba9876543210fedcba98", true},
+		{"named branch moved", "stable", models.RefBranch, "fedcba9876543210fedcba9876543210fedcba98", true},
+		{"tip moved before the ref type was recorded", "tip", "", "fedcba9876543210fedcba9876543210fedcba98", true},
+		{"tag before the ref type was recorded", "v1.0.0", "", installed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rolesPath := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(rolesPath, "my-role", "meta"), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			info := "install_commit: " + installed + "\nversion: " + tt.version + "\n"
+			if tt.refType != "" {
+				info += "install_ref_type: " + tt.refType + "\n"
+			}
+			if err := os.WriteFile(filepath.Join(rolesPath, "my-role", "meta", ".galaxy_install_info"), []byte(info), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cloned := false
+			inst := &Installer{
+				runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+					command := cmd.String()
+					switch {
+					case command == "hg identify --debug -i -r "+tt.version+" https://hg.example.com/my-role":
+						return tt.head + "\n", nil
+					case strings.HasPrefix(command, "hg clone"):
+						cloned = true
+					case strings.HasPrefix(command, "hg log"):
+						return tt.head, nil
+					case strings.HasPrefix(command, "hg archive"):
+						return "", fakeArchive(command)
+					}
+					return "", nil
+				}},
+				fsys:      os.DirFS(rolesPath),
+				rolesPath: rolesPath,
+				cleanup:   true,
+			}
+			entry := &models.Entry{Name: "my-role", Src: "hg+https://hg.example.com/my-role", Version: tt.version}
+
+			if _, _, _, err := inst.processEntry(t.Context(), entry, os.DirFS(rolesPath)); err != nil {
+				t.Fatalf("processEntry() error = %v", err)
+			}
+			if cloned != tt.expected {
+				t.Errorf("processEntry() cloned = %v, want %v", cloned, tt.expected)
+			}
+		})
+	}
+}
@@ -1,26 +1,27 @@
 package installer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/etkecc/go-kit/workpool"
 
+	"github.com/etkecc/agru/internal/archive"
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/gitref"
+	"github.com/etkecc/agru/internal/hostlimit"
 	"github.com/etkecc/agru/internal/models"
 	"github.com/etkecc/agru/internal/runner"
-)
-
-const (
-	// RetriesMax is the maximum number of retries for git clone operations
-	RetriesMax = 5
-	// RetryStepDelay is the delay between retry attempts (exponential backoff)
-	RetryStepDelay = 1 * time.Second
+	"github.com/etkecc/agru/internal/tarball"
 )
 
 var ignoredVersions = map[string]bool{
@@ -28,45 +29,106 @@ var ignoredVersions = map[string]bool{
 	"master": true,
 }
 
+// vcs is a version control system backend, used to clone repos and export them as tar archives
+type vcs struct {
+	// clone clones the repo at the version into the tmp dir (or uses the cached mirror),
+	// and returns the dir to archive the repo from, and the commit hash of the version
+	clone   func(ctx context.Context, repo, version, tmpdir string) (dir, sha string, err error)
+	archive func(ctx context.Context, dir, version, prefix, tmpfile string) error
+	refType func(ctx context.Context, dir, version string) string // optional, returns one of the models.Ref* constants, empty if unknown
+}
+
+// processFunc installs a single role or collection.
+// Returns the previously installed version, whether it was installed/updated, a verbose log line, and any error.
+type processFunc func() (oldVersion string, installed bool, logLine string, err error)
+
 // Progress represents the installation status of a single role.
 type Progress struct {
 	Name       string
 	Version    string
 	OldVersion string
-	Status     string // "active" | "done" | "skipped" | "error"
+	RequiredBy string // name of the role that depends on that role, empty for roles from the requirements file
+	Status     string // "active" | "done" | "skipped" | "error" (also sent for a done role that couldn't be restored in atomic mode)
 	Log        string // verbose log line (non-empty only when verbose mode is on)
 	Err        error
 }
 
 // Installer handles installing and managing Ansible roles from a requirements.yml file.
-// It uses a Runner to execute git commands and an fs.FS for reading role metadata.
+// It uses a Runner to execute git commands, a Galaxy API client to download Galaxy roles,
+// an archive client to download tarball roles, an optional cache of git mirrors, and an fs.FS for reading role metadata.
+// The installs run in a worker pool, limited per host with the Limiter shared with the parser.
 type Installer struct {
-	runner    runner.Runner
-	fsys      fs.FS
-	rolesPath string
-	limit     int
-	cleanup   bool
+	runner          runner.Runner
+	galaxy          *galaxy.Client
+	archive         *archive.Client
+	cache           *cache.Cache
+	bundleDir       string // dir of the unpacked bundle, all roles and collections are installed from it if set
+	fsys            fs.FS
+	rolesPath       string
+	collectionsPath string
+	limit           int
+	hosts           *hostlimit.Limiter // per-host limits, may be nil
+	cleanup         bool
+	noDeps          bool
+	atomic          bool
+
+	lock         *models.Lock // lockfile of the frozen run, the discovered dependencies are checked against it, may be nil
+	dependencies models.File  // dependencies installed during the last run, nil if they were not resolved
+
+	mu       sync.Mutex
+	replaced []replacement // roles and collections replaced during the run in atomic mode
 }
 
-// New creates a new Installer
-func New(r runner.Runner, rolesPath string, limit int, cleanup bool) *Installer {
+// New creates a new Installer, limit is the max number of parallel installs (0 - no limit),
+// hosts is the per-host limits (may be nil), noDeps disables installation of role dependencies,
+// atomic restores all roles and collections replaced during the run if any of them fails,
+// roles from git repos are installed from the cache's mirrors (if the cache is not nil),
+// all roles and collections are installed from the bundleDir with the unpacked bundle (if set)
+func New(r runner.Runner, g *galaxy.Client, rolesPath, collectionsPath string, limit int, hosts *hostlimit.Limiter, cleanup, noDeps, atomic bool, c *cache.Cache, bundleDir string) *Installer {
 	return &Installer{
-		runner:    r,
-		fsys:      os.DirFS(rolesPath),
-		rolesPath: rolesPath,
-		limit:     limit,
-		cleanup:   cleanup,
+		runner:          r,
+		galaxy:          g,
+		archive:         archive.New(),
+		cache:           c,
+		bundleDir:       bundleDir,
+		fsys:            os.DirFS(rolesPath),
+		rolesPath:       rolesPath,
+		collectionsPath: collectionsPath,
+		limit:           limit,
+		hosts:           hosts,
+		cleanup:         cleanup,
+		noDeps:          noDeps,
+		atomic:          atomic,
 	}
 }
 
+// SetLock sets the lockfile of the frozen run (see models.Lock.Apply),
+// so the dependencies discovered during the install are checked against it and installed at their locked commits
+func (i *Installer) SetLock(lock *models.Lock) {
+	i.lock = lock
+}
+
+// Dependencies returns the role dependencies installed (or found installed) during the last InstallMissing run, sorted by name.
+// Returns nil if the dependencies were not resolved, e.g. they are disabled or InstallMissing wasn't called
+func (i *Installer) Dependencies() models.File {
+	return i.dependencies
+}
+
 // FS returns the filesystem used for reading role metadata
 func (i *Installer) FS() fs.FS {
 	return i.fsys
 }
 
-// InstallMissing writes all roles to the target roles dir if role doesn't exist or has different version.
+// InstallMissing writes all roles to the target roles dir (and all collections to the target collections dir)
+// if role (collection) doesn't exist or has different version.
+// Unless disabled, dependencies of the installed roles are installed too, as soon as they are discovered.
 // Progress events are sent to the progress channel (if non-nil); the channel is closed when all installs complete.
-func (i *Installer) InstallMissing(entries models.File, progress chan<- Progress) error {
+// When the ctx is done, the running git commands and downloads are stopped, and the roles that are not started yet fail.
+// A role is never left half-written: it's written into a staging dir next to the target one, and swapped in with renames
+// only when its install info is written and the ctx is not done yet.
+// In atomic mode, if any role or collection fails, all roles and collections replaced during the run
+// are restored to their previous versions (or removed if new).
+func (i *Installer) InstallMissing(ctx context.Context, entries models.File, collections models.Collections, progress chan<- Progress) error {
 	if err := i.bootstrapRoles(); err != nil {
 		return err
 	}
@@ -74,88 +136,204 @@ func (i *Installer) InstallMissing(entries models.File, progress chan<- Progress
 	// Take a local snapshot before the concurrent loop — goroutines read from
 	// this snapshot; i.fsys is refreshed once after all installations complete.
 	i.fsys = os.DirFS(i.rolesPath)
-	fsys := i.fsys
 
-	rolesLen := entries.RolesLen()
+	rolesLen := entries.RolesLen() + len(collections)
 	limit := i.limit
 	if limit == 0 {
 		limit = rolesLen
 	}
-	wp := workpool.New(limit)
-	var (
-		mu      sync.Mutex
-		changes models.UpdatedItems
-		errs    []error
-	)
+	run := &installRun{
+		wp:       workpool.New(limit),
+		fsys:     i.fsys,
+		progress: progress,
+	}
+	if !i.noDeps {
+		run.deps = newResolver(entries)
+	}
 
 	for _, entry := range entries {
 		if entry.Include != "" { // skip entries with include directive
 			continue
 		}
-		wp.Do(func() {
-			i.installEntry(entry, fsys, &mu, &changes, &errs, progress)
+		run.wp.Do(func() {
+			i.installEntry(ctx, run, entry, "")
 		})
 	}
-	wp.Run()
+	for _, collection := range collections {
+		run.wp.Do(func() {
+			i.installItem(ctx, run, collection.GetName(), collection.Version, "", func() (string, bool, string, error) {
+				return i.processCollection(ctx, collection)
+			})
+		})
+	}
+	run.wp.Run()
+	i.finishReplacements(run)
 	i.fsys = os.DirFS(i.rolesPath)
+	i.dependencies = nil
+	if run.deps != nil {
+		i.dependencies = run.deps.dependencies()
+	}
 
 	if progress != nil {
 		close(progress)
 	}
 
-	if len(errs) == 0 {
+	if len(run.errs) == 0 {
 		return nil
 	}
-	errStrs := make([]string, 0, len(errs))
-	for _, err := range errs {
+	errStrs := make([]string, 0, len(run.errs))
+	for _, err := range run.errs {
 		errStrs = append(errStrs, err.Error())
 	}
 	return errors.New(strings.Join(errStrs, "\n"))
 }
 
-// installEntry executes a single role install inside the workpool goroutine.
-func (i *Installer) installEntry(entry *models.Entry, fsys fs.FS, mu *sync.Mutex, changes *models.UpdatedItems, errs *[]error, progress chan<- Progress) {
-	if progress != nil {
-		progress <- Progress{Name: entry.GetName(), Version: entry.Version, Status: "active"}
+// installEntry installs a single role inside the workpool goroutine,
+// and schedules installation of its dependencies (if enabled).
+// requiredBy is the name of the role that depends on that role, empty for roles from the requirements file
+func (i *Installer) installEntry(ctx context.Context, run *installRun, entry *models.Entry, requiredBy string) {
+	ok := i.installItem(ctx, run, entry.GetName(), entry.Version, requiredBy, func() (string, bool, string, error) {
+		return i.processEntry(ctx, entry, run.fsys)
+	})
+	if !ok || run.deps == nil {
+		return
 	}
-	oldVersion, installed, logLine, err := i.processEntry(entry, fsys)
-	mu.Lock()
-	defer mu.Unlock()
+
+	deps, err := models.ParseRoleDependencies(run.fsys, entry.GetName())
 	if err != nil {
-		*errs = append(*errs, err)
-		if progress != nil {
-			progress <- Progress{Name: entry.GetName(), Version: entry.Version, Status: "error", Log: logLine, Err: err}
-		}
+		run.fail(Progress{Name: entry.GetName(), Version: entry.Version, RequiredBy: requiredBy, Status: "error", Err: err})
 		return
 	}
-	if installed && !ignoredVersions[entry.Version] {
-		*changes = changes.Add(entry.GetName(), oldVersion, entry.Version)
+	for _, dep := range deps {
+		schedule, err := run.deps.add(dep, entry.GetName())
+		if err != nil {
+			run.fail(Progress{Name: dep.GetName(), Version: dep.Version, RequiredBy: entry.GetName(), Status: "error", Err: err})
+			continue
+		}
+		if !schedule {
+			continue
+		}
+		if i.lock != nil {
+			if err := i.lock.ApplyDependency(dep); err != nil {
+				run.fail(Progress{Name: dep.GetName(), Version: dep.Version, RequiredBy: entry.GetName(), Status: "error", Err: err})
+				continue
+			}
+		}
+		run.wp.Do(func() {
+			i.installEntry(ctx, run, dep, entry.GetName())
+		})
+	}
+}
+
+// installItem executes a single role or collection install inside the workpool goroutine.
+// name and version are used for progress reporting, process does the actual installation.
+// Returns false if the installation failed
+func (i *Installer) installItem(ctx context.Context, run *installRun, name, version, requiredBy string, process processFunc) bool {
+	if err := ctx.Err(); err != nil { // cancelled while waiting in the workpool
+		run.fail(Progress{Name: name, Version: version, RequiredBy: requiredBy, Status: "error", Err: err})
+		return false
+	}
+	if run.progress != nil {
+		run.progress <- Progress{Name: name, Version: version, RequiredBy: requiredBy, Status: "active"}
 	}
-	if progress == nil {
-		return
+	oldVersion, installed, logLine, err := process()
+	if err != nil {
+		run.fail(Progress{Name: name, Version: version, RequiredBy: requiredBy, Status: "error", Log: logLine, Err: err})
+		return false
+	}
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if installed && !ignoredVersions[version] && (oldVersion == "" || oldVersion != version) { // same version means the branch's head has moved
+		run.changes = run.changes.Add(name, oldVersion, version)
+	}
+	if run.progress == nil {
+		return true
 	}
 	if installed {
-		progress <- Progress{Name: entry.GetName(), Version: entry.Version, OldVersion: oldVersion, Status: "done", Log: logLine}
+		run.progress <- Progress{Name: name, Version: version, OldVersion: oldVersion, RequiredBy: requiredBy, Status: "done", Log: logLine}
 	} else {
-		progress <- Progress{Name: entry.GetName(), Version: entry.Version, Status: "skipped"}
+		run.progress <- Progress{Name: name, Version: version, RequiredBy: requiredBy, Status: "skipped"}
 	}
+	return true
 }
 
 // processEntry checks and installs a single role.
 // Returns the previously installed version, whether the role was installed/updated, a verbose log line, and any error.
-func (i *Installer) processEntry(entry *models.Entry, fsys fs.FS) (oldVersion string, installed bool, logLine string, err error) {
+func (i *Installer) processEntry(ctx context.Context, entry *models.Entry, fsys fs.FS) (oldVersion string, installed bool, logLine string, err error) {
 	if entry.IsInstalled(fsys) {
 		return "", false, "", nil
 	}
+	release, err := i.hosts.Acquire(ctx, i.entryHost(entry))
+	if err != nil {
+		return "", false, "", err
+	}
+	defer release()
+
 	existingInfo, _ := entry.GetInstallInfo(fsys) //nolint:errcheck // parse failure → empty version → unknown old version, will reinstall
+	if i.isBranchHeadInstalled(ctx, entry, existingInfo) {
+		return "", false, "", nil
+	}
 	oldVersion = existingInfo.Version
-	ok, logLine, err := i.installRole(entry)
+	ok, logLine, err := i.installRole(ctx, entry)
 	if err != nil {
 		return "", false, logLine, fmt.Errorf("installing %s@%s: %w", entry.GetName(), entry.Version, err)
 	}
 	return oldVersion, ok, logLine, nil
 }
 
+// isBranchHeadInstalled checks if the role installed from a git or hg branch is at the branch's current head,
+// so it doesn't have to be cloned again. Any error of the remote check means the role has to be reinstalled.
+// The ref type of the git role installed before it was recorded is checked in the remote repo (and recorded),
+// so the role installed from a tag is kept as-is
+func (i *Installer) isBranchHeadInstalled(ctx context.Context, entry *models.Entry, info models.GalaxyInstallInfo) bool {
+	if commit, _ := entry.Locked(); commit != "" {
+		return false
+	}
+	sourceType := entry.SourceType()
+	if (sourceType != models.SourceGit && sourceType != models.SourceHg) || info.Version != entry.Version || info.InstallCommit == "" {
+		return false
+	}
+	unknown := info.RefTypeUnknown(entry.Version)
+	if !unknown && !info.IsBranch(entry.Version) {
+		return false
+	}
+	if i.bundleDir != "" { // the bundled commit is the branch head
+		bundled, err := i.bundledInstallInfo(entry)
+		return err == nil && bundled.InstallCommit == info.InstallCommit
+	}
+	if i.offline() { // the branch head can't be checked, keep the installed commit
+		return true
+	}
+	if sourceType == models.SourceHg { // the ref type can't be checked remotely, but the changeset of the installed tag is the same
+		head, _, err := i.remoteHgChangeset(ctx, entry)
+		return err == nil && head == info.InstallCommit
+	}
+	if unknown {
+		isBranch, err := gitref.IsRemoteBranch(ctx, i.runner, entry.Repo(), entry.Version)
+		if err != nil {
+			return false
+		}
+		refType := models.RefTag
+		if isBranch {
+			refType = models.RefBranch
+		}
+		entry.RecordRefType(i.rolesPath, refType) //nolint:errcheck // best effort, the ref type is checked again on the next run
+		if !isBranch {
+			return true
+		}
+	}
+	head, err := gitref.Head(ctx, i.runner, entry.Repo(), entry.Version)
+	return err == nil && head == info.InstallCommit
+}
+
+// entryHost returns the remote host the role is installed from, empty if it's installed from the bundle
+func (i *Installer) entryHost(entry *models.Entry) string {
+	if i.bundleDir != "" {
+		return ""
+	}
+	return entry.Host()
+}
+
 // GetInstalled returns all roles that are already installed
 func (i *Installer) GetInstalled(entries models.File) models.File {
 	installed := models.File{}
@@ -168,12 +346,34 @@ func (i *Installer) GetInstalled(entries models.File) models.File {
 	return installed
 }
 
-// installRole writes specific role version to the target roles dir.
+// installRole writes specific role version to the target roles dir, using the entry's source type.
 // Returns whether the role was installed, a verbose log line, and any error.
-func (i *Installer) installRole(entry *models.Entry) (installed bool, log string, err error) {
+func (i *Installer) installRole(ctx context.Context, entry *models.Entry) (installed bool, log string, err error) {
+	if i.bundleDir != "" {
+		return i.installBundleRole(ctx, entry)
+	}
+	switch entry.SourceType() {
+	case models.SourceGalaxy:
+		return i.installGalaxyRole(ctx, entry)
+	case models.SourceArchive:
+		return i.installArchiveRole(ctx, entry)
+	case models.SourceLocal:
+		return i.installLocalRole(ctx, entry)
+	default:
+		return i.installRepoRole(ctx, entry)
+	}
+}
+
+// installRepoRole writes specific role version from a git (or hg) repository to the target roles dir.
+// Returns whether the role was installed, a verbose log line, and any error.
+func (i *Installer) installRepoRole(ctx context.Context, entry *models.Entry) (installed bool, log string, err error) {
 	name := entry.GetName()
 
-	repo := strings.Replace(entry.Src, "git+", "", 1)
+	repo := entry.Repo()
+	if entry.SourceType() == models.SourceHg && i.offline() {
+		return false, "", fmt.Errorf("hg repo %s is %w", repo, cache.ErrNotCached)
+	}
+	backend := i.vcsFor(entry)
 	tmpdir, err := os.MkdirTemp("", "agru-"+name+"-*")
 	if err != nil {
 		return false, "", fmt.Errorf("creating tmp dir: %w", err)
@@ -183,94 +383,384 @@ func (i *Installer) installRole(entry *models.Entry) (installed bool, log string
 		defer i.cleanupRole(tmpdir, tmpfile)
 	}
 
-	// clone repo
-	var clone strings.Builder
-	clone.WriteString("git clone -q --depth 1 ")
-	// git commit
-	if len(entry.Version) >= 40 {
-		clone.WriteString("-c remote.origin.fetch=+")
-		clone.WriteString(entry.Version)
-		clone.WriteString(":refs/remotes/origin/")
-		clone.WriteString(entry.Version)
-	} else { // git tag
-		clone.WriteString("-b ")
-		clone.WriteString(entry.Version)
-	}
-	clone.WriteString(" ")
-	clone.WriteString(repo)
-	clone.WriteString(" ")
-	clone.WriteString(tmpdir)
-
-	logLine := fmt.Sprintf("[%s] cloning %s @ %s", name, repo, entry.Version)
-	out, err := i.runClone(clone.String(), 0)
+	logLine := fmt.Sprintf("[%s] cloning %s @ %s", name, repo, entry.Ref())
+	dir, sha, err := backend.clone(ctx, repo, entry.Ref(), tmpdir)
 	if err != nil {
-		return false, logLine, fmt.Errorf("cloning repo: %w\n%s", err, out)
+		return false, logLine, err
 	}
-
-	sha, err := i.runner.Run("git rev-parse HEAD", tmpdir)
-	if err != nil {
-		return false, logLine, fmt.Errorf("getting commit hash: %w", err)
+	logLine = fmt.Sprintf("[%s] cloned %s @ %s (sha: %s)", name, repo, entry.Ref(), sha)
+	if commit, _ := entry.Locked(); commit != "" && sha != commit {
+		return false, logLine, fmt.Errorf("cloned commit %s doesn't match the locked commit %s", sha, commit)
 	}
-	logLine = fmt.Sprintf("[%s] cloned %s @ %s (sha: %s)", name, repo, entry.Version, sha)
+
+	installed, err = i.installRepo(ctx, entry, backend, dir, sha, tmpfile)
+	return installed, logLine, err
+}
+
+// installRepo writes the role from the repo dir at the entry's ref (commit sha) to the target roles dir,
+// unless the same commit is already installed. Returns whether the role was installed
+func (i *Installer) installRepo(ctx context.Context, entry *models.Entry, backend vcs, dir, sha, tmpfile string) (bool, error) {
+	name := entry.GetName()
 
 	// check if the role is already installed
 	cachedInfo, _ := entry.GetInstallInfo(i.fsys) //nolint:errcheck // parse failure → empty commit → will reinstall
 	installedCommit := cachedInfo.InstallCommit
 	if sha != "" && installedCommit != "" && sha == installedCommit {
-		return false, logLine, nil
-	}
-
-	// create archive from the cloned source
-	var archive strings.Builder
-	archive.WriteString("git archive --prefix=")
-	archive.WriteString(name)
-	archive.WriteString("/ --output=")
-	archive.WriteString(tmpfile)
-	archive.WriteString(" ")
-	archive.WriteString(entry.Version)
-	out, err = i.runner.Run(archive.String(), tmpdir)
+		return false, nil
+	}
+
+	// create archive from the repo source
+	if err := backend.archive(ctx, dir, entry.Ref(), name+"/", tmpfile); err != nil {
+		return false, err
+	}
+
+	// extract the archive into the staged role dir, dropping the name/ prefix
+	err := i.replaceRole(ctx, name, func(rolesPath string) error {
+		if err := tarball.Extract(tmpfile, path.Join(rolesPath, name), 1); err != nil {
+			return fmt.Errorf("extracting archive: %w", err)
+		}
+		commit, refType := entry.Locked()
+		if commit == "" && backend.refType != nil {
+			refType = backend.refType(ctx, dir, entry.Version)
+		}
+		return i.writeInstallInfo(rolesPath, entry, sha, refType)
+	})
 	if err != nil {
-		return false, logLine, fmt.Errorf("archiving repo: %w\n%s", err, out)
+		return false, err
+	}
+	return true, nil
+}
+
+// installLocalRole writes the role from a local dir to the target roles dir.
+// If the dir is a git repo and the version is pinned, the role is exported at that version,
+// otherwise the dir is copied as-is (without .git).
+// Returns whether the role was installed, a verbose log line, and any error.
+func (i *Installer) installLocalRole(ctx context.Context, entry *models.Entry) (installed bool, log string, err error) {
+	name := entry.GetName()
+	src := entry.LocalPath()
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return false, "", fmt.Errorf("local role dir %s not found", src)
 	}
 
-	// remove existing role directory to ensure stale files from previous versions are cleaned up
-	if err := os.RemoveAll(path.Join(i.rolesPath, name)); err != nil {
-		return false, logLine, fmt.Errorf("removing existing role dir: %w", err)
+	if entry.Version != "" && entry.IsLocalRepo() {
+		return i.installLocalRepo(ctx, entry, src)
 	}
 
-	// extract the archive into roles path
-	out, err = i.runner.Run("tar -xf "+tmpfile, i.rolesPath)
+	logLine := fmt.Sprintf("[%s] copying %s", name, src)
+	err = i.replaceRole(ctx, name, func(rolesPath string) error {
+		if err := copyDir(src, path.Join(rolesPath, name)); err != nil {
+			return fmt.Errorf("copying role dir: %w", err)
+		}
+		return i.writeInstallInfo(rolesPath, entry, "", "")
+	})
 	if err != nil {
-		return false, logLine, fmt.Errorf("extracting archive: %w\n%s", err, out)
+		return false, logLine, err
 	}
+	return true, logLine, nil
+}
 
-	// write install info file
-	outb, err := entry.GenerateInstallInfo(sha)
+// installLocalRepo writes the role from a local git repo at the entry's version to the target roles dir
+func (i *Installer) installLocalRepo(ctx context.Context, entry *models.Entry, src string) (installed bool, log string, err error) {
+	name := entry.GetName()
+	sha, err := i.runner.Run(ctx, runner.Cmd(src, "git", "rev-parse", entry.Ref()+"^{commit}"))
+	if err != nil {
+		return false, "", fmt.Errorf("resolving version %s: %w", entry.Ref(), err)
+	}
+	tmpfile, err := os.CreateTemp("", "agru-"+name+"-*.tar")
 	if err != nil {
-		return false, logLine, fmt.Errorf("generating install info: %w", err)
+		return false, "", fmt.Errorf("creating tmp file: %w", err)
 	}
-	if err := os.WriteFile(path.Join(i.rolesPath, name, "meta", ".galaxy_install_info"), outb, 0o600); err != nil {
-		return false, logLine, fmt.Errorf("writing install info: %w", err)
+	tmpfile.Close()
+	if i.cleanup {
+		defer os.Remove(tmpfile.Name())
 	}
 
+	logLine := fmt.Sprintf("[%s] exporting %s @ %s (sha: %s)", name, src, entry.Version, sha)
+	installed, err = i.installRepo(ctx, entry, i.vcsFor(entry), src, sha, tmpfile.Name())
+	return installed, logLine, err
+}
+
+// copyDir copies the src dir into dst recursively, preserving file modes and symlinks and skipping .git
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(srcPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" && rel != "." {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dstPath := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dstPath, 0o700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dstPath)
+		case info.Mode().IsRegular():
+			return copyFile(srcPath, dstPath, info.Mode().Perm())
+		default:
+			return nil // skip sockets, devices, etc.
+		}
+	})
+}
+
+// copyFile copies a single regular file
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src) //nolint:gosec // that's intended
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm) //nolint:gosec // that's intended
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// vcsFor returns the version control system backend of the entry, git by default
+func (i *Installer) vcsFor(entry *models.Entry) vcs {
+	if entry.SourceType() == models.SourceHg {
+		return vcs{clone: inDir(i.cloneHgRepo), archive: i.archiveHgRepo, refType: i.hgRefType}
+	}
+	return i.gitVCS()
+}
+
+// gitVCS returns the git backend, that uses the cached mirrors (if the cache is enabled) or shallow clones
+func (i *Installer) gitVCS() vcs {
+	if i.cache != nil {
+		return vcs{clone: i.cloneMirror, archive: i.archiveRepo, refType: i.refType}
+	}
+	return vcs{clone: inDir(i.cloneRepo), archive: i.archiveRepo, refType: i.refType}
+}
+
+// inDir adapts the clone func, that clones the repo into the tmp dir, to the vcs' clone signature
+func inDir(clone func(ctx context.Context, repo, version, dir string) (string, error)) func(ctx context.Context, repo, version, tmpdir string) (string, string, error) {
+	return func(ctx context.Context, repo, version, tmpdir string) (string, string, error) {
+		sha, err := clone(ctx, repo, version, tmpdir)
+		return tmpdir, sha, err
+	}
+}
+
+// cloneMirror fetches the cached bare mirror of the git repo and resolves the version (tag, branch or commit) in it.
+// Returns the mirror dir to archive the role from, and the commit hash of the version
+func (i *Installer) cloneMirror(ctx context.Context, repo, version, _ string) (dir, sha string, err error) {
+	dir, err = i.cache.Mirror(ctx, repo)
+	if err != nil {
+		return "", "", err
+	}
+	rev := version
+	if rev == "" {
+		rev = "HEAD"
+	}
+	revParse := runner.Cmd(dir, "git", "rev-parse", "-q", "--verify", rev+"^{commit}")
+	sha, err = i.runner.Run(ctx, revParse)
+	if err != nil && len(version) >= 40 { // the commit is not reachable from the mirrored refs
+		if err := i.cache.FetchCommit(ctx, repo, version); err != nil {
+			return "", "", err
+		}
+		sha, err = i.runner.Run(ctx, revParse)
+	}
+	if err != nil {
+		if i.offline() {
+			return "", "", fmt.Errorf("version %s of %s is %w", version, repo, cache.ErrNotCached)
+		}
+		return "", "", fmt.Errorf("version %s not found in %s", version, repo)
+	}
+	return dir, sha, nil
+}
+
+// refType returns the type of the version (tag, branch or commit) in the git repo dir, empty if unknown
+func (i *Installer) refType(ctx context.Context, dir, version string) string {
+	switch {
+	case version == "":
+		return models.RefBranch
+	case len(version) >= 40:
+		return models.RefCommit
+	}
+	out, err := i.runner.Run(ctx, runner.Cmd(dir, "git", "rev-parse", "--symbolic-full-name", version))
+	if err != nil {
+		return ""
+	}
+	if gitref.IsBranch(out) {
+		return models.RefBranch
+	}
+	return models.RefTag
+}
+
+// cloneRepo clones the git repo at the specific version (tag, branch or commit) into the dir.
+// Returns the commit hash of the cloned HEAD.
+func (i *Installer) cloneRepo(ctx context.Context, repo, version, dir string) (string, error) {
+	args := []string{"git", "clone", "-q", "--depth", "1"}
+	if len(version) >= 40 { // git commit
+		args = append(args, "-c", "remote.origin.fetch=+"+version+":refs/remotes/origin/"+version)
+	} else if version != "" { // git tag
+		args = append(args, "-b", version)
+	}
+	cmd := runner.Cmd("", append(args, repo, dir)...)
+	cmd.BeforeRetry = func() error { return os.RemoveAll(dir) } // git doesn't clone into the non-empty dir
+	if _, err := i.runner.Run(ctx, cmd); err != nil {
+		return "", fmt.Errorf("cloning repo: %w", err)
+	}
+
+	rev := "HEAD"
+	if len(version) >= 40 { // the commit is fetched, but HEAD is the default branch
+		rev = version + "^{commit}"
+	}
+	sha, err := i.runner.Run(ctx, runner.Cmd(dir, "git", "rev-parse", rev))
+	if err != nil {
+		return "", fmt.Errorf("getting commit hash: %w", err)
+	}
+	return sha, nil
+}
+
+// archiveRepo creates a tar archive of the cloned git repo at the specific version, with all paths prefixed
+func (i *Installer) archiveRepo(ctx context.Context, dir, version, prefix, tmpfile string) error {
+	if version == "" {
+		version = "HEAD"
+	}
+	if _, err := i.runner.Run(ctx, runner.Cmd(dir, "git", "archive", "--prefix="+prefix, "--output="+tmpfile, version)); err != nil {
+		return fmt.Errorf("archiving repo: %w", err)
+	}
+	return nil
+}
+
+// installGalaxyRole downloads specific role version from the Ansible Galaxy API and writes it to the target roles dir.
+// Returns whether the role was installed, a verbose log line, and any error.
+func (i *Installer) installGalaxyRole(ctx context.Context, entry *models.Entry) (installed bool, log string, err error) {
+	if i.galaxy == nil {
+		return false, "", errors.New("galaxy client is not configured")
+	}
+	name := entry.GetName()
+	namespace, role, _ := entry.GalaxyRole()
+
+	tmpfile, err := os.CreateTemp("", "agru-"+name+"-*.tar.gz")
+	if err != nil {
+		return false, "", fmt.Errorf("creating tmp file: %w", err)
+	}
+	tmpfile.Close()
+	if i.cleanup {
+		defer os.Remove(tmpfile.Name())
+	}
+
+	logLine := fmt.Sprintf("[%s] downloading %s.%s @ %s", name, namespace, role, entry.Version)
+	key := "galaxy:" + namespace + "." + role + "-" + entry.Version + ".tar.gz"
+	archivePath, err := i.download(key, tmpfile.Name(), func(dst string) error {
+		version, err := i.galaxy.RoleVersion(ctx, namespace, role, entry.Version)
+		if err != nil {
+			return err
+		}
+		logLine = fmt.Sprintf("[%s] downloading %s.%s @ %s from %s", name, namespace, role, version.Name, version.DownloadURL)
+		return i.galaxy.Download(ctx, version.DownloadURL, dst, "")
+	}, nil)
+	if err != nil {
+		return false, logLine, err
+	}
+
+	if err := i.extractRole(ctx, entry, archivePath); err != nil {
+		return false, logLine, err
+	}
 	return true, logLine, nil
 }
 
-// runClone runs git clone with exponential-backoff retry on network failures
-func (i *Installer) runClone(cmd string, attempt int) (string, error) {
-	out, err := i.runner.Run(cmd, "")
-	if err == nil {
-		return out, nil
+// installArchiveRole downloads the role's tarball, verifies its checksum (if set) and writes it to the target roles dir.
+// Returns whether the role was installed, a verbose log line, and any error.
+func (i *Installer) installArchiveRole(ctx context.Context, entry *models.Entry) (installed bool, log string, err error) {
+	if strings.Contains(entry.Src, archive.VersionPlaceholder) && entry.Version == "" {
+		return false, "", fmt.Errorf("%s placeholder is used in src, but version is not set", archive.VersionPlaceholder)
 	}
+	name := entry.GetName()
+	archiveURL := entry.ArchiveURL()
 
-	// fatal: unable to access 'https://github.com/user/repo.git/': Failed to connect to github.com port 443 after 135428 ms: Couldn't connect to server
-	if strings.Contains(out, "Couldn't connect to server") && attempt < RetriesMax {
-		delay := RetryStepDelay * time.Duration(attempt)
-		time.Sleep(delay)
-		return i.runClone(cmd, attempt+1)
+	tmpfile, err := os.CreateTemp("", "agru-"+name+"-*.tar.gz")
+	if err != nil {
+		return false, "", fmt.Errorf("creating tmp file: %w", err)
+	}
+	tmpfile.Close()
+	if i.cleanup {
+		defer os.Remove(tmpfile.Name())
 	}
 
-	return out, err
+	logLine := fmt.Sprintf("[%s] downloading %s", name, archiveURL)
+	archivePath, err := i.download(archiveURL, tmpfile.Name(), func(dst string) error {
+		return i.archive.Download(ctx, archiveURL, dst, entry.Checksum)
+	}, func(archivePath string) error {
+		return archive.Verify(archivePath, entry.Checksum)
+	})
+	if err != nil {
+		return false, logLine, err
+	}
+
+	if err := i.extractRole(ctx, entry, archivePath); err != nil {
+		return false, logLine, err
+	}
+	return true, logLine, nil
+}
+
+// download downloads the archive with the download func into the tmpfile,
+// or into the cache (identified by the key), if it's enabled. The cached archive is checked with the verify func (optional)
+// before it's used without downloading. Returns the path of the downloaded archive
+func (i *Installer) download(key, tmpfile string, download func(dst string) error, verify func(archivePath string) error) (string, error) {
+	if i.cache == nil {
+		return tmpfile, download(tmpfile)
+	}
+	return i.cache.Archive(key, download, verify)
+}
+
+// offline checks if the roles are installed from the cache only, without network access
+func (i *Installer) offline() bool {
+	return i.cache != nil && i.cache.Offline() == cache.OfflineOn
+}
+
+// extractRole replaces the role dir with the contents of the (optionally compressed) tarball,
+// dropping its top-level dir, e.g. ansible-role-docker-6.1.0/, and writes the role's install info
+func (i *Installer) extractRole(ctx context.Context, entry *models.Entry, tmpfile string) error {
+	return i.replaceRole(ctx, entry.GetName(), func(rolesPath string) error {
+		if err := tarball.Extract(tmpfile, entry.GetPath(rolesPath), 1); err != nil {
+			return fmt.Errorf("extracting archive: %w", err)
+		}
+		return i.writeInstallInfo(rolesPath, entry, "", "")
+	})
+}
+
+// writeInstallInfo writes meta/.galaxy_install_info of the role written to the (staging) roles path,
+// refType is the type of the version (see models.Ref* constants), empty if unknown
+func (i *Installer) writeInstallInfo(rolesPath string, entry *models.Entry, sha, refType string) error {
+	files, err := entry.InstallInfoFiles(rolesPath)
+	if err != nil {
+		return fmt.Errorf("computing digest: %w", err)
+	}
+	outb, err := entry.GenerateInstallInfo(sha, refType, files)
+	if err != nil {
+		return fmt.Errorf("generating install info: %w", err)
+	}
+	metaPath := path.Join(entry.GetPath(rolesPath), "meta")
+	if err := os.MkdirAll(metaPath, 0o700); err != nil {
+		return fmt.Errorf("creating meta dir: %w", err)
+	}
+	if err := os.WriteFile(path.Join(metaPath, ".galaxy_install_info"), outb, 0o600); err != nil {
+		return fmt.Errorf("writing install info: %w", err)
+	}
+	return nil
 }
 
 // bootstrapRoles creates the roles directory if it doesn't exist
@@ -1,7 +1,8 @@
 package installer
 
 import (
-	"errors"
+	"archive/tar"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 	"testing/fstest"
 
 	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
 )
 
 // fakeRunner records calls and returns preset outputs matched by prefix
@@ -26,7 +28,8 @@ func newFakeRunner() *fakeRunner {
 	}
 }
 
-func (r *fakeRunner) Run(command, _ string) (string, error) {
+func (r *fakeRunner) Run(_ context.Context, cmd runner.Command) (string, error) {
+	command := cmd.String()
 	r.calls = append(r.calls, command)
 	for key, out := range r.outputs {
 		if strings.HasPrefix(command, key) {
@@ -47,11 +50,11 @@ func (r *fakeRunner) called(prefix string) bool {
 
 // callbackRunner calls a function for each Run invocation
 type callbackRunner struct {
-	fn func(command, dir string) (string, error)
+	fn func(cmd runner.Command) (string, error)
 }
 
-func (r *callbackRunner) Run(command, dir string) (string, error) {
-	return r.fn(command, dir)
+func (r *callbackRunner) Run(_ context.Context, cmd runner.Command) (string, error) {
+	return r.fn(cmd)
 }
 
 func TestGetInstalled(t *testing.T) {
@@ -82,76 +85,38 @@ func TestGetInstalled(t *testing.T) {
 	}
 }
 
-func TestRunCloneSuccess(t *testing.T) {
-	fr := newFakeRunner()
-	fr.outputs["git clone"] = ""
-
-	inst := &Installer{runner: fr}
-	_, err := inst.runClone("git clone -q --depth 1 -b v1.0.0 https://github.com/org/role /tmp/dir", 0)
-	if err != nil {
-		t.Errorf("runClone() unexpected error = %v", err)
+// fakeArchive simulates git (or hg) archive: writes the tar archive with meta/main.yml under the --prefix
+// into the command's --output (or the last argument)
+func fakeArchive(command string) error {
+	var prefix, output string
+	args := strings.Split(command, " ")
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--prefix="):
+			prefix = strings.TrimPrefix(arg, "--prefix=")
+		case strings.HasPrefix(arg, "--output="):
+			output = strings.TrimPrefix(arg, "--output=")
+		}
 	}
-	if !fr.called("git clone") {
-		t.Error("runClone() should have called git clone")
+	if output == "" {
+		output = args[len(args)-1]
 	}
-}
-
-func TestRunCloneRetryOnNetworkError(t *testing.T) {
-	callCount := 0
-	inst := &Installer{runner: &callbackRunner{
-		fn: func(_, _ string) (string, error) {
-			callCount++
-			if callCount == 1 {
-				return "Couldn't connect to server", errors.New("exit status 128")
-			}
-			return "", nil
-		},
-	}}
-
-	_, err := inst.runClone("git clone -q --depth 1 -b v1.0.0 https://example.com/role /tmp/dir", 0)
+	f, err := os.Create(output)
 	if err != nil {
-		t.Errorf("runClone() should succeed after retry, got error = %v", err)
+		return err
 	}
-	if callCount != 2 {
-		t.Errorf("runClone() call count = %d, want 2 (1 failure + 1 retry)", callCount)
+	tw := tar.NewWriter(f)
+	content := "---\n"
+	if err := tw.WriteHeader(&tar.Header{Name: prefix + "meta/main.yml", Mode: 0o644, Size: int64(len(content))}); err != nil {
+		return err
 	}
-}
-
-func TestRunCloneMaxRetries(t *testing.T) {
-	callCount := 0
-	inst := &Installer{runner: &callbackRunner{
-		fn: func(_, _ string) (string, error) {
-			callCount++
-			return "Couldn't connect to server", errors.New("exit status 128")
-		},
-	}}
-
-	_, err := inst.runClone("git clone -q --depth 1 -b v1.0.0 https://example.com/role /tmp/dir", 0)
-	if err == nil {
-		t.Error("runClone() should return error when max retries exceeded")
+	if _, err := tw.Write([]byte(content)); err != nil {
+		return err
 	}
-	// initial call + RetriesMax retries
-	if callCount != RetriesMax+1 {
-		t.Errorf("runClone() call count = %d, want %d (1 initial + %d retries)", callCount, RetriesMax+1, RetriesMax)
-	}
-}
-
-func TestRunCloneNoRetryOnOtherError(t *testing.T) {
-	callCount := 0
-	inst := &Installer{runner: &callbackRunner{
-		fn: func(_, _ string) (string, error) {
-			callCount++
-			return "some other error", errors.New("exit status 128")
-		},
-	}}
-
-	_, err := inst.runClone("git clone -q --depth 1 -b v1.0.0 https://example.com/role /tmp/dir", 0)
-	if err == nil {
-		t.Error("runClone() should return error for non-network failures")
-	}
-	if callCount != 1 {
-		t.Errorf("runClone() should not retry non-network errors, call count = %d, want 1", callCount)
+	if err := tw.Close(); err != nil {
+		return err
 	}
+	return f.Close()
 }
 
 func TestInstallRoleCallsGitInOrder(t *testing.T) {
@@ -164,18 +129,16 @@ func TestInstallRoleCallsGitInOrder(t *testing.T) {
 	commitSHA := "abc123def456abc123def456abc123def456abc12"
 	calledCmds := []string{}
 
-	// Use a callback runner so tar can simulate directory creation
+	// Use a callback runner so git archive can write the archive
 	inst := &Installer{
-		runner: &callbackRunner{fn: func(command, _ string) (string, error) {
+		runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+			command := cmd.String()
 			calledCmds = append(calledCmds, command)
 			if strings.HasPrefix(command, "git rev-parse HEAD") {
 				return commitSHA, nil
 			}
-			if strings.HasPrefix(command, "tar -xf") {
-				// Simulate tar extracting the archive: create role dir structure
-				if err := os.MkdirAll(filepath.Join(rolesPath, "my-role", "meta"), 0o700); err != nil {
-					return "", err
-				}
+			if strings.HasPrefix(command, "git archive") {
+				return "", fakeArchive(command)
 			}
 			return "", nil
 		}},
@@ -189,7 +152,7 @@ func TestInstallRoleCallsGitInOrder(t *testing.T) {
 	entry.Src = "git+https://github.com/org/my-role.git"
 	entry.Version = "v1.0.0"
 
-	ok, _, err := inst.installRole(entry)
+	ok, _, err := inst.installRole(t.Context(), entry)
 	if err != nil {
 		t.Fatalf("installRole() error = %v", err)
 	}
@@ -205,13 +168,44 @@ func TestInstallRoleCallsGitInOrder(t *testing.T) {
 		}
 		return false
 	}
-	for _, expectedCmd := range []string{"git clone", "git rev-parse HEAD", "git archive", "tar -xf"} {
+	for _, expectedCmd := range []string{"git clone", "git rev-parse HEAD", "git archive"} {
 		if !called(expectedCmd) {
 			t.Errorf("installRole() should have called %q, called: %v", expectedCmd, calledCmds)
 		}
 	}
 }
 
+func TestCloneRepoRetriesPartialClone(t *testing.T) {
+	repo := makeGitRepo(t, map[string]string{"tasks/main.yml": "---\n"}, "v1.0.0")
+	shell := runner.New(0)
+	var clones int
+	// the first clone times out and leaves the partially cloned repo behind
+	flaky := &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+		if cmd.Args[1] == "clone" {
+			if clones++; clones == 1 {
+				dir := cmd.Args[len(cmd.Args)-1]
+				if err := os.MkdirAll(filepath.Join(dir, ".git"), 0o700); err != nil {
+					return "", err
+				}
+				return "", &runner.Error{Command: cmd.String(), Err: fmt.Errorf("timed out after 1s: %w", context.DeadlineExceeded)}
+			}
+		}
+		return shell.Run(t.Context(), cmd)
+	}}
+	inst := &Installer{runner: runner.NewRetryRunner(flaky, runner.RetryPolicy{Retries: 2}, nil)}
+
+	sha, err := inst.cloneRepo(t.Context(), "file://"+repo, "v1.0.0", t.TempDir())
+	if err != nil {
+		t.Fatalf("cloneRepo() error = %v", err)
+	}
+	if clones != 2 {
+		t.Errorf("cloneRepo() cloned %d times, want 2", clones)
+	}
+	if len(sha) != 40 {
+		t.Errorf("cloneRepo() = %q, want the commit hash", sha)
+	}
+}
+
 func TestInstallRoleAlreadyUpToDate(t *testing.T) {
 	commitSHA := "abc123def456abc123def456abc123def456abc12"
 
@@ -237,7 +231,7 @@ func TestInstallRoleAlreadyUpToDate(t *testing.T) {
 	entry.Src = "git+https://github.com/org/my-role.git"
 	entry.Version = "v1.0.0"
 
-	ok, _, err := inst.installRole(entry)
+	ok, _, err := inst.installRole(t.Context(), entry)
 	if err != nil {
 		t.Fatalf("installRole() error = %v", err)
 	}
@@ -260,15 +254,14 @@ func TestInstallRoleCommitSHAVersion(t *testing.T) {
 	calledCmds := []string{}
 
 	inst := &Installer{
-		runner: &callbackRunner{fn: func(command, _ string) (string, error) {
+		runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+			command := cmd.String()
 			calledCmds = append(calledCmds, command)
 			if strings.HasPrefix(command, "git rev-parse HEAD") {
 				return commitSHA, nil
 			}
-			if strings.HasPrefix(command, "tar -xf") {
-				if err := os.MkdirAll(filepath.Join(rolesPath, "sha-role", "meta"), 0o700); err != nil {
-					return "", err
-				}
+			if strings.HasPrefix(command, "git archive") {
+				return "", fakeArchive(command)
 			}
 			return "", nil
 		}},
@@ -282,7 +275,7 @@ func TestInstallRoleCommitSHAVersion(t *testing.T) {
 	entry.Src = "git+https://github.com/org/sha-role.git"
 	entry.Version = commitSHA
 
-	_, _, err := inst.installRole(entry)
+	_, _, err := inst.installRole(t.Context(), entry)
 	if err != nil {
 		t.Fatalf("installRole() error = %v", err)
 	}
@@ -302,6 +295,226 @@ func TestInstallRoleCommitSHAVersion(t *testing.T) {
 	}
 }
 
+func TestInstallRoleRecordsBranch(t *testing.T) {
+	tmpDir := t.TempDir()
+	rolesPath := filepath.Join(tmpDir, "roles")
+	if err := os.MkdirAll(rolesPath, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	commitSHA := "abc123def456abc123def456abc123def456abc12"
+	inst := &Installer{
+		runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+			command := cmd.String()
+			switch {
+			case strings.HasPrefix(command, "git rev-parse HEAD"):
+				return commitSHA, nil
+			case strings.HasPrefix(command, "git rev-parse --symbolic-full-name develop"):
+				return "refs/heads/develop", nil
+			case strings.HasPrefix(command, "git archive"):
+				return "", fakeArchive(command)
+			}
+			return "", nil
+		}},
+		fsys:      os.DirFS(rolesPath),
+		rolesPath: rolesPath,
+	}
+
+	entry := &models.Entry{Name: "my-role", Src: "git+https://github.com/org/my-role.git", Version: "develop"}
+	if _, _, err := inst.installRole(t.Context(), entry); err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+
+	info, err := entry.GetInstallInfo(os.DirFS(rolesPath))
+	if err != nil {
+		t.Fatalf("GetInstallInfo() error = %v", err)
+	}
+	if info.InstallRefType != models.RefBranch {
+		t.Errorf("InstallRefType = %q, want %q", info.InstallRefType, models.RefBranch)
+	}
+	if info.InstallCommit != commitSHA {
+		t.Errorf("InstallCommit = %q, want %q", info.InstallCommit, commitSHA)
+	}
+}
+
+func TestInstallRoleLocked(t *testing.T) {
+	lockedSHA := strings.Repeat("a", 40)
+
+	for name, clonedSHA := range map[string]string{"locked commit": lockedSHA, "moved tag": strings.Repeat("b", 40)} {
+		t.Run(name, func(t *testing.T) {
+			rolesPath := t.TempDir()
+			calledCmds := []string{}
+			inst := &Installer{
+				runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+					command := cmd.String()
+					calledCmds = append(calledCmds, command)
+					switch {
+					case strings.HasPrefix(command, "git rev-parse "+lockedSHA+"^{commit}"):
+						return clonedSHA, nil
+					case strings.HasPrefix(command, "git archive"):
+						return "", fakeArchive(command)
+					}
+					return "", nil
+				}},
+				fsys:      os.DirFS(rolesPath),
+				rolesPath: rolesPath,
+				cleanup:   true,
+			}
+
+			entry := &models.Entry{Name: "my-role", Src: "git+https://github.com/org/my-role.git", Version: "v1.0.0"}
+			entry.SetLocked(lockedSHA, models.RefTag)
+			_, _, err := inst.installRole(t.Context(), entry)
+			if clonedSHA != lockedSHA {
+				if err == nil {
+					t.Fatal("installRole() error = nil, want error for commit mismatch")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("installRole() error = %v", err)
+			}
+			if !strings.Contains(calledCmds[0], "remote.origin.fetch=+"+lockedSHA) {
+				t.Errorf("installRole() should clone the locked commit, clone cmd: %q", calledCmds[0])
+			}
+			info, err := entry.GetInstallInfo(os.DirFS(rolesPath))
+			if err != nil {
+				t.Fatalf("GetInstallInfo() error = %v", err)
+			}
+			if info.Version != "v1.0.0" || info.InstallCommit != lockedSHA || info.InstallRefType != models.RefTag {
+				t.Errorf("GetInstallInfo() = %+v, want version v1.0.0, locked commit and tag ref type", info)
+			}
+		})
+	}
+}
+
+func TestProcessEntryBranch(t *testing.T) {
+	installedSHA := "abc123def456abc123def456abc123def456abc12"
+	fsys := fstest.MapFS{
+		"my-role/meta/.galaxy_install_info": &fstest.MapFile{
+			Data: fmt.Appendf(nil, "install_commit: %s\ninstall_ref_type: branch\nversion: develop\n", installedSHA),
+		},
+	}
+	entry := &models.Entry{Name: "my-role", Src: "git+https://github.com/org/my-role.git", Version: "develop"}
+	lsRemote := "git ls-remote -q https://github.com/org/my-role.git refs/heads/develop"
+
+	t.Run("head not moved", func(t *testing.T) {
+		fr := newFakeRunner()
+		fr.outputs[lsRemote] = installedSHA + "\trefs/heads/develop"
+		inst := &Installer{runner: fr, fsys: fsys, rolesPath: t.TempDir()}
+
+		_, installed, _, err := inst.processEntry(t.Context(), entry, fsys)
+		if err != nil {
+			t.Fatalf("processEntry() error = %v", err)
+		}
+		if installed {
+			t.Error("processEntry() installed = true, want false when the branch's head is installed")
+		}
+		if fr.called("git clone") {
+			t.Error("processEntry() should not clone when the branch's head is installed")
+		}
+	})
+
+	t.Run("head moved", func(t *testing.T) {
+		rolesPath := t.TempDir()
+		cloned := false
+		inst := &Installer{
+			runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+				command := cmd.String()
+				switch {
+				case command == lsRemote:
+					return "fff000\trefs/heads/develop", nil
+				case strings.HasPrefix(command, "git clone"):
+					cloned = true
+				case strings.HasPrefix(command, "git archive"):
+					return "", fakeArchive(command)
+				}
+				return "", nil
+			}},
+			fsys:      fsys,
+			rolesPath: rolesPath,
+			cleanup:   true,
+		}
+
+		if _, _, _, err := inst.processEntry(t.Context(), entry, fsys); err != nil {
+			t.Fatalf("processEntry() error = %v", err)
+		}
+		if !cloned {
+			t.Error("processEntry() should clone when the branch's head has moved")
+		}
+	})
+}
+
+func TestProcessEntryUnknownRefType(t *testing.T) {
+	installedSHA := "abc123def456abc123def456abc123def456abc12"
+	// writeLegacyRole creates the role installed before the ref type was recorded
+	writeLegacyRole := func(t *testing.T, version string) string {
+		t.Helper()
+		rolesPath := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(rolesPath, "my-role", "meta"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		data := fmt.Appendf(nil, "install_commit: %s\nversion: %s\n", installedSHA, version)
+		if err := os.WriteFile(filepath.Join(rolesPath, "my-role", "meta", ".galaxy_install_info"), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return rolesPath
+	}
+
+	t.Run("branch head moved", func(t *testing.T) {
+		rolesPath := writeLegacyRole(t, "develop")
+		cloned := false
+		inst := &Installer{
+			runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+				command := cmd.String()
+				switch {
+				case command == "git ls-remote -q https://github.com/org/my-role.git refs/heads/develop refs/tags/develop",
+					command == "git ls-remote -q https://github.com/org/my-role.git refs/heads/develop":
+					return "fff000\trefs/heads/develop", nil
+				case strings.HasPrefix(command, "git clone"):
+					cloned = true
+				case strings.HasPrefix(command, "git archive"):
+					return "", fakeArchive(command)
+				}
+				return "", nil
+			}},
+			fsys:      os.DirFS(rolesPath),
+			rolesPath: rolesPath,
+			cleanup:   true,
+		}
+		entry := &models.Entry{Name: "my-role", Src: "git+https://github.com/org/my-role.git", Version: "develop"}
+
+		if _, _, _, err := inst.processEntry(t.Context(), entry, os.DirFS(rolesPath)); err != nil {
+			t.Fatalf("processEntry() error = %v", err)
+		}
+		if !cloned {
+			t.Error("processEntry() should clone when the head of the branch without the recorded ref type has moved")
+		}
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		rolesPath := writeLegacyRole(t, "v1.0.0")
+		fr := newFakeRunner()
+		fr.outputs["git ls-remote -q https://github.com/org/my-role.git refs/heads/v1.0.0 refs/tags/v1.0.0"] = "fff000\trefs/tags/v1.0.0"
+		inst := &Installer{runner: fr, fsys: os.DirFS(rolesPath), rolesPath: rolesPath}
+		entry := &models.Entry{Name: "my-role", Src: "git+https://github.com/org/my-role.git", Version: "v1.0.0"}
+
+		_, installed, _, err := inst.processEntry(t.Context(), entry, os.DirFS(rolesPath))
+		if err != nil {
+			t.Fatalf("processEntry() error = %v", err)
+		}
+		if installed || fr.called("git clone") {
+			t.Error("processEntry() should keep the role installed from a tag")
+		}
+		info, err := entry.GetInstallInfo(os.DirFS(rolesPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.InstallRefType != models.RefTag || info.InstallCommit != installedSHA {
+			t.Errorf("processEntry() install info = %+v, want the tag ref type recorded", info)
+		}
+	})
+}
+
 func TestInstallMissingConcurrentNoDatRace(t *testing.T) {
 	// Run with -race to detect data races. Uses 4 concurrent workers installing
 	// 8 roles in parallel, exercising shared state (i.fsys, changes).
@@ -313,25 +526,13 @@ func TestInstallMissingConcurrentNoDatRace(t *testing.T) {
 
 	commitSHA := "abc123def456abc123def456abc123def456abc12"
 	inst := &Installer{
-		runner: &callbackRunner{fn: func(command, _ string) (string, error) {
+		runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+			command := cmd.String()
 			if strings.HasPrefix(command, "git rev-parse HEAD") {
 				return commitSHA, nil
 			}
-			if strings.HasPrefix(command, "tar -xf ") {
-				// Extract role name from "tar -xf /tmp/agru-ROLENAME-*"
-				// The archive would contain ROLENAME/ but we simulate by creating the meta dir.
-				// We determine the role from the archive path suffix pattern: agru-ROLENAME-*.tar
-				parts := strings.Split(command, " ")
-				if len(parts) >= 3 {
-					archivePath := parts[2]
-					base := strings.TrimSuffix(archivePath[strings.LastIndex(archivePath, "agru-")+5:], ".tar")
-					// base is "ROLENAME-RANDOMSUFFIX" — strip the random suffix
-					if idx := strings.LastIndex(base, "-"); idx != -1 {
-						roleName := base[:idx]
-						metaDir := filepath.Join(rolesPath, roleName, "meta")
-						_ = os.MkdirAll(metaDir, 0o700)
-					}
-				}
+			if strings.HasPrefix(command, "git archive") {
+				return "", fakeArchive(command)
 			}
 			return "", nil
 		}},
@@ -350,7 +551,7 @@ func TestInstallMissingConcurrentNoDatRace(t *testing.T) {
 		entries[idx].Version = "v1.0.0"
 	}
 
-	if err := inst.InstallMissing(entries, nil); err != nil {
+	if err := inst.InstallMissing(t.Context(), entries, nil, nil); err != nil {
 		t.Fatalf("InstallMissing() concurrent error = %v", err)
 	}
 }
@@ -370,7 +571,7 @@ func TestInstallMissingSkipsIncludeEntries(t *testing.T) {
 	}
 
 	// bootstrapRoles will try os.Stat on the rolesPath (temp dir exists, so no error)
-	err := inst.InstallMissing(entries, nil)
+	err := inst.InstallMissing(t.Context(), entries, nil, nil)
 	if err != nil {
 		t.Fatalf("InstallMissing() error = %v", err)
 	}
@@ -378,3 +579,50 @@ func TestInstallMissingSkipsIncludeEntries(t *testing.T) {
 		t.Error("InstallMissing() should not call git clone for include-only entries")
 	}
 }
+
+func TestInstallMissingCancelled(t *testing.T) {
+	rolesPath := t.TempDir()
+	oldFile := filepath.Join(rolesPath, "role-0", "tasks", "old.yml")
+	if err := os.MkdirAll(filepath.Dir(oldFile), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(oldFile, []byte("---\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	var clones int
+	inst := &Installer{
+		runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+			command := cmd.String()
+			if strings.HasPrefix(command, "git clone") {
+				clones++
+			}
+			if strings.HasPrefix(command, "git archive") {
+				cancel() // 'q' pressed after the role is archived, but before it is extracted
+				return "", fakeArchive(command)
+			}
+			return "abc123def456abc123def456abc123def456abc12", nil
+		}},
+		fsys:      os.DirFS(rolesPath),
+		rolesPath: rolesPath,
+		limit:     1,
+		cleanup:   true,
+	}
+	entries := models.File{
+		{Src: "git+https://github.com/org/role-0.git", Version: "v1.0.0"},
+		{Src: "git+https://github.com/org/role-1.git", Version: "v1.0.0"},
+	}
+
+	err := inst.InstallMissing(ctx, entries, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("InstallMissing() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(oldFile); err != nil {
+		t.Errorf("InstallMissing() should keep the old role after the cancellation: %v", err)
+	}
+	if clones != 1 {
+		t.Errorf("InstallMissing() cloned %d repos, want the roles not started yet to be skipped", clones)
+	}
+}
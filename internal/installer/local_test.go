@@ -0,0 +1,129 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/agru/internal/cache"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+func TestInstallLocalRoleGitRepo(t *testing.T) {
+	repo := makeGitRepo(t, map[string]string{"tasks/main.yml": "---\n"}, "v1.0.0")
+	// uncommitted changes should not be installed for the pinned version
+	if err := os.WriteFile(filepath.Join(repo, "wip.yml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rolesPath := t.TempDir()
+	inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, nil, "")
+	entry := &models.Entry{Src: "file://" + repo, Name: "local", Version: "v1.0.0"}
+
+	ok, _, err := inst.installRole(t.Context(), entry)
+	if err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+	if !ok {
+		t.Error("installRole() = false, want true for new installation")
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "local", "tasks", "main.yml")); err != nil {
+		t.Errorf("installRole() should export the repo: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "local", "wip.yml")); !os.IsNotExist(err) {
+		t.Errorf("installRole() should export only committed files, got: %v", err)
+	}
+	if !entry.IsInstalled(os.DirFS(rolesPath)) {
+		t.Error("IsInstalled() = false after local installation, install info is not written")
+	}
+
+	// second run is a no-op, the same commit is already installed
+	ok, _, err = inst.installRole(t.Context(), entry)
+	if err != nil {
+		t.Fatalf("installRole() second run error = %v", err)
+	}
+	if ok {
+		t.Error("installRole() second run = true, want false for the same commit")
+	}
+}
+
+func TestInstallLocalRoleCopy(t *testing.T) {
+	base := t.TempDir()
+	src := filepath.Join(base, "roles-dev", "foo")
+	for _, dir := range []string{"tasks", ".git"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "tasks", "main.yml"), []byte("---\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("tasks/main.yml", filepath.Join(src, "main.yml")); err != nil {
+		t.Fatal(err)
+	}
+	rolesPath := t.TempDir()
+	inst := New(runner.New(0), nil, rolesPath, "", 0, nil, true, false, false, nil, "")
+	entry := &models.Entry{Src: "../roles-dev/foo"}
+	entry.SetFile(filepath.Join(base, "playbook", "requirements.yml"))
+
+	ok, _, err := inst.installRole(t.Context(), entry)
+	if err != nil {
+		t.Fatalf("installRole() error = %v", err)
+	}
+	if !ok {
+		t.Error("installRole() = false, want true")
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "foo", "tasks", "main.yml")); err != nil {
+		t.Errorf("installRole() should copy the dir resolved against the base dir: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(rolesPath, "foo", "main.yml")); err != nil || target != "tasks/main.yml" {
+		t.Errorf("installRole() should preserve symlinks, got %q, %v", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(rolesPath, "foo", ".git")); !os.IsNotExist(err) {
+		t.Errorf("installRole() should skip .git, got: %v", err)
+	}
+	if entry.IsInstalled(os.DirFS(rolesPath)) {
+		t.Error("IsInstalled() = true, want false for local role without version (always copied)")
+	}
+}
+
+func TestInstallLocalRoleMissingDir(t *testing.T) {
+	inst := New(runner.New(0), nil, t.TempDir(), "", 0, nil, true, false, false, nil, "")
+	entry := &models.Entry{Src: "file:///nonexistent/role"}
+
+	if _, _, err := inst.installRole(t.Context(), entry); err == nil {
+		t.Error("installRole() expected error for missing local dir, got nil")
+	}
+}
+
+func TestInstallRoleFromCache(t *testing.T) {
+	repo := makeGitRepo(t, map[string]string{"tasks/main.yml": "---\n"}, "v1.0.0")
+	rolesPath := t.TempDir()
+	var clones int
+	r := &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+		command := cmd.String()
+		if strings.HasPrefix(command, "git clone") {
+			clones++
+		}
+		return runner.New(0).Run(t.Context(), cmd)
+	}}
+	inst := New(r, nil, rolesPath, "", 0, nil, true, false, false, cache.New(r, filepath.Join(t.TempDir(), "cache"), cache.OfflineOff), "")
+
+	for _, name := range []string{"first", "second"} { // roles that share the src
+		entry := &models.Entry{Src: "git+file://" + repo, Name: name, Version: "v1.0.0"}
+		ok, _, err := inst.installRole(t.Context(), entry)
+		if err != nil {
+			t.Fatalf("installRole(%s) error = %v", name, err)
+		}
+		if !ok {
+			t.Errorf("installRole(%s) = false, want true for new installation", name)
+		}
+		if _, err := os.Stat(filepath.Join(rolesPath, name, "tasks", "main.yml")); err != nil {
+			t.Errorf("installRole(%s) should export the mirror: %v", name, err)
+		}
+	}
+	if clones != 1 {
+		t.Errorf("installRole() cloned the repo %d times, want 1", clones)
+	}
+}
@@ -0,0 +1,57 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/etkecc/agru/internal/gitref"
+	"github.com/etkecc/agru/internal/models"
+)
+
+// LockCommits resolves the commits of the git and hg roles locked without them in their remote repos,
+// e.g. the roles updated by -u, but not installed yet, so every role from a repo is locked with its commit
+func (i *Installer) LockCommits(ctx context.Context, entries models.File, lock *models.Lock) error {
+	for _, entry := range entries {
+		if entry.Include != "" {
+			continue
+		}
+		locked := lock.Role(entry.GetName())
+		if locked == nil || locked.Commit != "" {
+			continue
+		}
+		var err error
+		switch entry.SourceType() {
+		case models.SourceGit:
+			locked.Commit, locked.RefType, err = i.remoteCommit(ctx, entry)
+		case models.SourceHg:
+			locked.Commit, locked.RefType, err = i.remoteHgChangeset(ctx, entry)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("resolving commit of %s@%s: %w", entry.GetName(), entry.Version, err)
+		}
+	}
+	return nil
+}
+
+// remoteCommit returns the commit hash and the ref type of the git role's version in its remote repo
+func (i *Installer) remoteCommit(ctx context.Context, entry *models.Entry) (sha, refType string, err error) {
+	if len(entry.Version) >= 40 {
+		return entry.Version, models.RefCommit, nil
+	}
+	release, err := i.hosts.Acquire(ctx, i.entryHost(entry))
+	if err != nil {
+		return "", "", err
+	}
+	defer release()
+
+	sha, isBranch, err := gitref.Resolve(ctx, i.runner, entry.Repo(), entry.Version)
+	if err != nil {
+		return "", "", err
+	}
+	if isBranch {
+		return sha, models.RefBranch, nil
+	}
+	return sha, models.RefTag, nil
+}
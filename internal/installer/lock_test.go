@@ -0,0 +1,68 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/agru/internal/models"
+)
+
+func TestLockCommits(t *testing.T) {
+	rolesPath := t.TempDir()
+	installedSHA := "abc123def456abc123def456abc123def456abc12"
+	commitSHA := "fff000fff000fff000fff000fff000fff000fff0"
+	entries := models.File{
+		{Name: "tag", Src: "git+https://github.com/org/tag.git", Version: "v2.0.0"}, // updated by -u, not installed yet
+		{Name: "branch", Src: "git+https://github.com/org/branch.git", Version: "develop"},
+		{Name: "commit", Src: "git+https://github.com/org/commit.git", Version: commitSHA},
+		{Name: "hg", Src: "hg+https://hg.example.com/role", Version: "1.0"},
+		{Name: "galaxy", Src: "org.galaxy_role", Version: "1.0.0"},
+	}
+	fr := newFakeRunner()
+	fr.outputs["git ls-remote -q https://github.com/org/tag.git refs/heads/v2.0.0 refs/tags/v2.0.0 refs/tags/v2.0.0^{}"] = "aaa\trefs/tags/v2.0.0\n" + installedSHA + "\trefs/tags/v2.0.0^{}"
+	fr.outputs["git ls-remote -q https://github.com/org/branch.git refs/heads/develop"] = "bbb\trefs/heads/develop"
+	fr.outputs["hg identify --debug -i -r 1.0 https://hg.example.com/role"] = "ccc\n"
+	inst := &Installer{runner: fr, fsys: os.DirFS(rolesPath), rolesPath: rolesPath}
+
+	lock, err := models.NewLock(entries, inst.FS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.LockCommits(t.Context(), entries, lock); err != nil {
+		t.Fatalf("LockCommits() error = %v", err)
+	}
+	expected := map[string][2]string{
+		"tag":    {installedSHA, models.RefTag},
+		"branch": {"bbb", models.RefBranch},
+		"commit": {commitSHA, models.RefCommit},
+		"hg":     {"ccc", ""},
+		"galaxy": {"", ""},
+	}
+	for name, want := range expected {
+		if role := lock.Role(name); role == nil || role.Commit != want[0] || role.RefType != want[1] {
+			t.Errorf("LockCommits() %s = %+v, want commit %q and ref type %q", name, role, want[0], want[1])
+		}
+	}
+
+	// the lock of the roles that are not installed yet pins them in frozen mode
+	if err := lock.Apply(entries); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if commit, _ := entries[0].Locked(); commit != installedSHA {
+		t.Errorf("Locked() = %q, want %q", commit, installedSHA)
+	}
+
+	failing := newFakeRunner()
+	failing.outputs["git ls-remote"] = ""
+	failing.errors["git ls-remote"] = errors.New("network down")
+	inst.runner = failing
+	lock, err = models.NewLock(entries, inst.FS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.LockCommits(t.Context(), entries, lock); err == nil || !strings.Contains(err.Error(), "resolving commit of tag@v2.0.0") {
+		t.Errorf("LockCommits() error = %v, want the resolve error", err)
+	}
+}
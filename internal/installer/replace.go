@@ -0,0 +1,139 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// replacement is a role or collection dir replaced (or removed) during the run,
+// kept in atomic mode to restore the previous version if the run fails
+type replacement struct {
+	name    string
+	dir     string
+	staging string // staging dir with the previous version of the dir in its old subdir (absent if the dir didn't exist)
+}
+
+// replaceDir writes the new contents of the dir (a role or a collection) with the write func into a staging dir next to it,
+// and swaps it in with renames only when the write succeeds and the ctx is not done, so the dir is never left half-written.
+// Returns the staging dir with the previous contents of the dir in its old subdir (absent if the dir didn't exist),
+// it's removed by the caller
+func replaceDir(ctx context.Context, dir string, write func(staged string) error) (string, error) {
+	parent, name := filepath.Dir(dir), filepath.Base(dir)
+	if err := os.MkdirAll(parent, 0o700); err != nil {
+		return "", fmt.Errorf("creating parent dir: %w", err)
+	}
+	staging, err := os.MkdirTemp(parent, ".agru-"+name+"-*")
+	if err != nil {
+		return "", fmt.Errorf("creating staging dir: %w", err)
+	}
+	staged := filepath.Join(staging, "new", name)
+	if err := write(staged); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	// the dir is not touched after the cancellation, see Installer.InstallMissing
+	if err := ctx.Err(); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+
+	old := filepath.Join(staging, "old")
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(staging)
+		return "", fmt.Errorf("moving existing dir: %w", err)
+	}
+	if err := os.Rename(staged, dir); err != nil {
+		if restoreErr := restoreDir(dir, old); restoreErr != nil {
+			return "", fmt.Errorf("swapping in new dir: %w, the previous version is left in %s", err, old)
+		}
+		os.RemoveAll(staging)
+		return "", fmt.Errorf("swapping in new dir: %w", err)
+	}
+	return staging, nil
+}
+
+// restoreDir replaces the dir with its previous version from the old dir, or removes it if there was no previous version
+func restoreDir(dir, old string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(old, dir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// replaceRole replaces the role dir with the role written by the write func into the staging roles path, see replace
+func (i *Installer) replaceRole(ctx context.Context, name string, write func(rolesPath string) error) error {
+	return i.replace(ctx, name, filepath.Join(i.rolesPath, name), func(staged string) error {
+		return write(filepath.Dir(staged))
+	})
+}
+
+// replace replaces the dir of the role or collection (or its part, e.g. the collection info dir) by the name, see replaceDir.
+// In atomic mode the previous version of the dir is kept until the end of the run, to be restored if the run fails
+func (i *Installer) replace(ctx context.Context, name, dir string, write func(staged string) error) error {
+	staging, err := replaceDir(ctx, dir, write)
+	if err != nil {
+		return err
+	}
+	i.keepReplaced(replacement{name: name, dir: dir, staging: staging})
+	return nil
+}
+
+// removeDir removes the dir of the role or collection (or its part) by the name.
+// In atomic mode the dir is moved into a staging dir next to it until the end of the run, to be restored if the run fails
+func (i *Installer) removeDir(name, dir string) error {
+	if !i.atomic {
+		return os.RemoveAll(dir)
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dir), ".agru-"+filepath.Base(dir)+"-*")
+	if err != nil {
+		return fmt.Errorf("creating staging dir: %w", err)
+	}
+	if err := os.Rename(dir, filepath.Join(staging, "old")); err != nil {
+		os.RemoveAll(staging)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("moving existing dir: %w", err)
+	}
+	i.keepReplaced(replacement{name: name, dir: dir, staging: staging})
+	return nil
+}
+
+// keepReplaced keeps the replacement until the end of the run in atomic mode, otherwise removes its staging dir at once
+func (i *Installer) keepReplaced(r replacement) {
+	if !i.atomic {
+		os.RemoveAll(r.staging)
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.replaced = append(i.replaced, r)
+}
+
+// finishReplacements removes the previous versions of the roles and collections replaced during the run (kept in atomic mode),
+// or restores them (in reverse order) if the run failed, reporting the ones that couldn't be restored as errors
+func (i *Installer) finishReplacements(run *installRun) {
+	i.mu.Lock()
+	replaced := i.replaced
+	i.replaced = nil
+	i.mu.Unlock()
+
+	failed := len(run.errs) > 0
+	for idx := len(replaced) - 1; idx >= 0; idx-- {
+		r := replaced[idx]
+		if failed {
+			old := filepath.Join(r.staging, "old")
+			if err := restoreDir(r.dir, old); err != nil {
+				run.fail(Progress{Name: r.name, Status: "error", Err: fmt.Errorf("restoring previous version: %w, it's left in %s", err, old)})
+				continue
+			}
+		}
+		os.RemoveAll(r.staging)
+	}
+}
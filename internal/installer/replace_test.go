@@ -0,0 +1,175 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+)
+
+const replaceTestSHA = "abc123def456abc123def456abc123def456abc12"
+
+// writeOldRole creates the previously installed role with the tasks/old.yml file, and returns the file's path
+func writeOldRole(t *testing.T, rolesPath, name string) string {
+	t.Helper()
+	oldFile := filepath.Join(rolesPath, name, "tasks", "old.yml")
+	if err := os.MkdirAll(filepath.Dir(oldFile), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(oldFile, []byte("---\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return oldFile
+}
+
+// assertNoStaging fails the test if any staging dir is left in the roles path
+func assertNoStaging(t *testing.T, rolesPath string) {
+	t.Helper()
+	staging, err := filepath.Glob(filepath.Join(rolesPath, ".agru-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staging) > 0 {
+		t.Errorf("staging dirs %v are left in the roles path", staging)
+	}
+}
+
+func TestInstallRoleKeepsOldRoleOnFailure(t *testing.T) {
+	rolesPath := t.TempDir()
+	oldFile := writeOldRole(t, rolesPath, "my-role")
+
+	inst := &Installer{
+		runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+			command := cmd.String()
+			if strings.HasPrefix(command, "git archive") {
+				for _, arg := range cmd.Args {
+					if output, ok := strings.CutPrefix(arg, "--output="); ok {
+						return "", os.WriteFile(output, []byte("not a tar archive"), 0o600)
+					}
+				}
+			}
+			return replaceTestSHA, nil
+		}},
+		fsys:      os.DirFS(rolesPath),
+		rolesPath: rolesPath,
+		cleanup:   true,
+	}
+	entry := &models.Entry{Src: "git+https://github.com/org/my-role.git", Version: "v1.0.0"}
+
+	if _, _, err := inst.installRole(t.Context(), entry); err == nil {
+		t.Fatal("installRole() expected error for the corrupted archive, got nil")
+	}
+	if _, err := os.Stat(oldFile); err != nil {
+		t.Errorf("installRole() should keep the old role when the extraction fails: %v", err)
+	}
+	assertNoStaging(t, rolesPath)
+}
+
+func TestInstallMissingAtomic(t *testing.T) {
+	tests := []struct {
+		name   string
+		atomic bool
+	}{
+		{"keeps the replaced roles", false},
+		{"restores the replaced roles", true},
+	}
+	for _, tt := range tests {
+		atomic := tt.atomic
+		t.Run(tt.name, func(t *testing.T) {
+			rolesPath := t.TempDir()
+			oldFile := writeOldRole(t, rolesPath, "role-0")
+
+			inst := &Installer{
+				runner: &callbackRunner{fn: func(cmd runner.Command) (string, error) {
+					command := cmd.String()
+					if strings.HasPrefix(command, "git clone") && strings.Contains(command, "role-2") {
+						return "", errors.New("repository not found")
+					}
+					if strings.HasPrefix(command, "git archive") {
+						return "", fakeArchive(command)
+					}
+					return replaceTestSHA, nil
+				}},
+				fsys:      os.DirFS(rolesPath),
+				rolesPath: rolesPath,
+				limit:     1,
+				cleanup:   true,
+				atomic:    atomic,
+			}
+			entries := models.File{
+				{Src: "git+https://github.com/org/role-0.git", Version: "v1.0.0"},
+				{Src: "git+https://github.com/org/role-1.git", Version: "v1.0.0"},
+				{Src: "git+https://github.com/org/role-2.git", Version: "v1.0.0"},
+			}
+
+			if err := inst.InstallMissing(t.Context(), entries, nil, nil); err == nil {
+				t.Fatal("InstallMissing() expected error for the missing repo, got nil")
+			}
+			_, oldErr := os.Stat(oldFile)
+			_, newErr := os.Stat(filepath.Join(rolesPath, "role-1"))
+			if atomic {
+				if oldErr != nil {
+					t.Errorf("InstallMissing() should restore the previous version of the replaced role: %v", oldErr)
+				}
+				if newErr == nil {
+					t.Error("InstallMissing() should remove the newly installed role")
+				}
+			} else {
+				if oldErr == nil {
+					t.Error("InstallMissing() should replace the role")
+				}
+				if newErr != nil {
+					t.Errorf("InstallMissing() should keep the newly installed role: %v", newErr)
+				}
+			}
+			assertNoStaging(t, rolesPath)
+		})
+	}
+}
+
+func TestInstallMissingAtomicCollections(t *testing.T) {
+	srv := newCollectionServer(t)
+	collectionsPath := t.TempDir()
+	collectionDir := filepath.Join(collectionsPath, "ansible_collections", "community", "general")
+	oldInfo := filepath.Join(collectionsPath, "ansible_collections", "community.general-6.0.0.info")
+	for file, content := range map[string]string{
+		filepath.Join(collectionDir, "MANIFEST.json"):     `{"collection_info": {"namespace": "community", "name": "general", "version": "6.0.0"}}`,
+		filepath.Join(collectionDir, "plugins", "old.py"): "# old module\n",
+		filepath.Join(oldInfo, "GALAXY.yml"):              "version: 6.0.0\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &callbackRunner{fn: func(runner.Command) (string, error) {
+		return "", errors.New("repository not found")
+	}}
+	inst := New(r, galaxy.New(galaxy.Server{URL: srv.URL}), t.TempDir(), collectionsPath, 1, nil, true, false, true, nil, "")
+	entries := models.File{{Src: "git+https://github.com/org/role-0.git", Version: "v1.0.0"}}
+	collections := models.Collections{{Name: "community.general", Version: ">=7.0.0"}}
+
+	if err := inst.InstallMissing(t.Context(), entries, collections, nil); err == nil {
+		t.Fatal("InstallMissing() expected error for the missing repo, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(collectionDir, "plugins", "old.py")); err != nil {
+		t.Errorf("InstallMissing() should restore the previous version of the replaced collection: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(oldInfo, "GALAXY.yml")); err != nil {
+		t.Errorf("InstallMissing() should restore the info dir of the previous version: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(collectionsPath, "ansible_collections", "community.general-8.0.0.info")); err == nil {
+		t.Error("InstallMissing() should remove the info dir of the new version")
+	}
+	for _, dir := range []string{filepath.Dir(collectionDir), filepath.Dir(oldInfo)} {
+		assertNoStaging(t, dir)
+	}
+}
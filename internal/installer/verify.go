@@ -0,0 +1,77 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/etkecc/agru/internal/digest"
+	"github.com/etkecc/agru/internal/models"
+)
+
+// Drift is the difference between the installed role's files and the files recorded at install time
+type Drift struct {
+	Name     string
+	Modified []string
+	Added    []string
+	Deleted  []string
+	Unknown  bool  // no files were recorded at install time, e.g. the role was installed by ansible-galaxy or older agru
+	Err      error // e.g. the role is not installed
+}
+
+// Changed checks if the installed role's files differ from the recorded ones
+func (d Drift) Changed() bool {
+	return len(d.Modified)+len(d.Added)+len(d.Deleted) > 0
+}
+
+// Verify recomputes the digests of the installed roles (without include directive)
+// and compares them with the digests recorded at install time
+func (i *Installer) Verify(entries models.File) []Drift {
+	drifts := make([]Drift, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Include != "" {
+			continue
+		}
+		drifts = append(drifts, i.verifyEntry(entry))
+	}
+	return drifts
+}
+
+// verifyEntry compares the installed role's files with the recorded ones
+func (i *Installer) verifyEntry(entry *models.Entry) Drift {
+	drift := Drift{Name: entry.GetName()}
+	if _, err := fs.Stat(i.fsys, entry.GetName()); err != nil { // unversioned roles are installed with empty version
+		drift.Err = errors.New("not installed")
+		return drift
+	}
+	info, err := entry.GetInstallInfo(i.fsys)
+	if err != nil {
+		drift.Err = fmt.Errorf("reading install info: %w", err)
+		return drift
+	}
+	switch {
+	case info.Version != entry.Version && info.Version == "":
+		drift.Err = fmt.Errorf("unknown version is installed, but %s is required", entry.Version)
+		return drift
+	case info.Version != entry.Version:
+		drift.Err = fmt.Errorf("version %s is installed, but %s is required", info.Version, entry.Version)
+		return drift
+	case info.InstallDigest == "":
+		drift.Unknown = true
+		return drift
+	}
+
+	files, err := entry.InstallInfoFiles(i.rolesPath)
+	if err != nil {
+		drift.Err = fmt.Errorf("computing digest: %w", err)
+		return drift
+	}
+	if digest.Sum(files) == info.InstallDigest {
+		return drift
+	}
+	drift.Modified, drift.Added, drift.Deleted = digest.Diff(info.InstallFiles, files)
+	if !drift.Changed() { // the recorded files don't match the recorded digest
+		drift.Err = errors.New("install info is corrupted, digest mismatch")
+	}
+	return drift
+}
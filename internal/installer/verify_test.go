@@ -0,0 +1,85 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/etkecc/agru/internal/models"
+)
+
+func TestVerify(t *testing.T) {
+	rolesPath := t.TempDir()
+	inst := &Installer{fsys: os.DirFS(rolesPath), rolesPath: rolesPath}
+	install := func(entry *models.Entry, files map[string]string) {
+		t.Helper()
+		for name, content := range files {
+			path := filepath.Join(rolesPath, entry.GetName(), name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := inst.writeInstallInfo(rolesPath, entry, "", ""); err != nil {
+			t.Fatalf("writeInstallInfo() error = %v", err)
+		}
+	}
+
+	intact := &models.Entry{Name: "intact", Version: "v1.0.0"}
+	install(intact, map[string]string{"tasks/main.yml": "- debug: msg=hi\n"})
+	modified := &models.Entry{Name: "modified", Version: "v1.0.0"}
+	install(modified, map[string]string{"tasks/main.yml": "- debug: msg=hi\n", "meta/main.yml": "galaxy_info: {}\n"})
+	rolePath := filepath.Join(rolesPath, "modified")
+	if err := os.WriteFile(filepath.Join(rolePath, "tasks", "main.yml"), []byte("- debug: msg=edited\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rolePath, "tasks", "extra.yml"), []byte("---\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(rolePath, "meta", "main.yml")); err != nil {
+		t.Fatal(err)
+	}
+	legacy := &models.Entry{Name: "legacy", Version: "v1.0.0"}
+	if err := os.MkdirAll(filepath.Join(rolesPath, "legacy", "meta"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rolesPath, "legacy", "meta", ".galaxy_install_info"), []byte("version: v1.0.0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := &models.Entry{Name: "missing", Version: "v1.0.0"}
+	unversioned := &models.Entry{Src: "./local/unversioned"}
+	install(unversioned, map[string]string{"tasks/main.yml": "---\n"})
+	outdated := &models.Entry{Name: "intact", Version: "v2.0.0"}
+
+	drifts := inst.Verify(models.File{intact, modified, legacy, missing, {Include: "other.yml"}})
+	if len(drifts) != 4 {
+		t.Fatalf("Verify() = %d drifts, want 4", len(drifts))
+	}
+	if drifts[0].Changed() || drifts[0].Err != nil || drifts[0].Unknown {
+		t.Errorf("Verify() intact = %+v, want no changes", drifts[0])
+	}
+	if !slices.Equal(drifts[1].Modified, []string{"tasks/main.yml"}) ||
+		!slices.Equal(drifts[1].Added, []string{"tasks/extra.yml"}) ||
+		!slices.Equal(drifts[1].Deleted, []string{"meta/main.yml"}) {
+		t.Errorf("Verify() modified = %+v, want 1 modified, 1 added, and 1 deleted file", drifts[1])
+	}
+	if !drifts[2].Unknown {
+		t.Errorf("Verify() legacy = %+v, want unknown", drifts[2])
+	}
+	if drifts[3].Err == nil {
+		t.Errorf("Verify() missing = %+v, want error", drifts[3])
+	}
+	if drift := inst.Verify(models.File{outdated})[0]; drift.Err == nil {
+		t.Errorf("Verify() outdated = %+v, want error", drift)
+	}
+	if drift := inst.Verify(models.File{unversioned})[0]; drift.Changed() || drift.Err != nil || drift.Unknown {
+		t.Errorf("Verify() unversioned = %+v, want no changes", drift)
+	}
+	versioned := &models.Entry{Src: "./local/unversioned", Version: "v1.0.0"}
+	if drift := inst.Verify(models.File{versioned})[0]; drift.Err == nil {
+		t.Errorf("Verify() versioned = %+v, want error for the role installed without version", drift)
+	}
+}
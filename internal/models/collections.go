@@ -0,0 +1,194 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/etkecc/agru/internal/giturl"
+	"github.com/etkecc/agru/internal/versions"
+)
+
+// CollectionTypeGit is the type of collections installed from a git repository
+const CollectionTypeGit = "git"
+
+var fqcnRegex = regexp.MustCompile(`^[A-Za-z0-9_]+\.[A-Za-z0-9_]+$`)
+
+// Collection is requirements.yml's collections entry structure
+type Collection struct {
+	namespace string `yaml:"-"`
+	name      string `yaml:"-"`
+	Name      string `yaml:"name,omitempty"`
+	Source    string `yaml:"source,omitempty"`
+	Type      string `yaml:"type,omitempty"`
+	Version   string `yaml:"version,omitempty"`
+}
+
+// Collections structure represents collections section of requirements.yml file
+type Collections []*Collection
+
+// CollectionMeta is the collection metadata from galaxy.yml or MANIFEST.json
+type CollectionMeta struct {
+	Namespace string `yaml:"namespace" json:"namespace"`
+	Name      string `yaml:"name" json:"name"`
+	Version   string `yaml:"version" json:"version"`
+}
+
+// IsGit checks if the collection is installed from a git repository
+func (c *Collection) IsGit() bool {
+	return c.Type == CollectionTypeGit || strings.HasPrefix(c.Name, "git+") || strings.HasPrefix(c.Source, "git+")
+}
+
+// Repo returns the git repository URL of the collection,
+// set either as name (ansible-galaxy's way) or as source
+func (c *Collection) Repo() string {
+	repo := c.Source
+	if !fqcnRegex.MatchString(c.Name) {
+		repo = c.Name
+	}
+	return giturl.Normalize(repo)
+}
+
+// LockSrc returns the collection's src in the lockfile: the repo of the git collection, or the name of the galaxy one
+func (c *Collection) LockSrc() string {
+	if c.IsGit() {
+		return c.Repo()
+	}
+	return c.Name
+}
+
+// Host returns the remote host of the collection's git repository, empty for Galaxy collections
+func (c *Collection) Host() string {
+	if !c.IsGit() {
+		return ""
+	}
+	if u, err := giturl.Parse(c.Repo()); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// GetFQCN returns collection's namespace and name, if known.
+// They are known if the collection's name is the FQCN, or after they were set with SetFQCN from the collection metadata
+func (c *Collection) GetFQCN() (namespace, name string, ok bool) {
+	if c.namespace != "" && c.name != "" {
+		return c.namespace, c.name, true
+	}
+	if !fqcnRegex.MatchString(c.Name) {
+		return "", "", false
+	}
+	namespace, name, _ = strings.Cut(c.Name, ".")
+	return namespace, name, true
+}
+
+// SetFQCN sets collection's namespace and name, e.g. from the collection metadata
+func (c *Collection) SetFQCN(namespace, name string) {
+	c.namespace = namespace
+	c.name = name
+}
+
+// GetName returns collection name with the following priority order
+// 1. namespace.name (if known)
+// 2. name, generated from the collection's git repo
+func (c *Collection) GetName() string {
+	if namespace, name, ok := c.GetFQCN(); ok {
+		return namespace + "." + name
+	}
+	return giturl.Name(c.Repo())
+}
+
+// GetPath returns path to the collection relative to the collections path, e.g. ansible_collections/namespace/name.
+// Returns an empty string if the collection's namespace and name are not known yet
+func (c *Collection) GetPath() string {
+	namespace, name, ok := c.GetFQCN()
+	if !ok {
+		return ""
+	}
+	return path.Join("ansible_collections", namespace, name)
+}
+
+// GetInstallInfo parses collection's .galaxy_install_info and returns parsed info.
+// fsys should be rooted at the collections directory (e.g. os.DirFS(collectionsPath)).
+// A missing file (or unknown collection path) returns a zero-value struct with a nil error.
+// A corrupt file returns a zero-value struct with a non-nil error.
+func (c *Collection) GetInstallInfo(fsys fs.FS) (GalaxyInstallInfo, error) {
+	collectionPath := c.GetPath()
+	if collectionPath == "" {
+		return GalaxyInstallInfo{}, nil
+	}
+	return readInstallInfo(fsys, path.Join(collectionPath, ".galaxy_install_info"))
+}
+
+// GenerateInstallInfo generates fresh install info from current state of the collection struct
+func (c *Collection) GenerateInstallInfo(commitSHA string) ([]byte, error) {
+	return generateInstallInfo(c.Version, commitSHA, "", nil)
+}
+
+// GetInstalledVersion returns the installed version of the galaxy collection, taken from its MANIFEST.json,
+// same as ansible-galaxy does. Returns an empty string if the collection is not installed.
+// fsys should be rooted at the collections directory (e.g. os.DirFS(collectionsPath)).
+func (c *Collection) GetInstalledVersion(fsys fs.FS) string {
+	collectionPath := c.GetPath()
+	if collectionPath == "" {
+		return ""
+	}
+	sub, err := fs.Sub(fsys, collectionPath)
+	if err != nil {
+		return ""
+	}
+	meta, err := ParseCollectionMeta(sub)
+	if err != nil {
+		return ""
+	}
+	return meta.Version
+}
+
+// IsInstalled checks if that collection with that specific version is installed.
+// Git collections are checked by their install info, galaxy collections - by the installed version
+// that should satisfy the collection's version constraint.
+// fsys should be rooted at the collections directory (e.g. os.DirFS(collectionsPath)).
+func (c *Collection) IsInstalled(fsys fs.FS) bool {
+	if !c.IsGit() {
+		installed := c.GetInstalledVersion(fsys)
+		return installed != "" && versions.Satisfies(installed, c.Version)
+	}
+
+	info, _ := c.GetInstallInfo(fsys) //nolint:errcheck // parse failure → empty version → treat as not installed
+	if info.Version == "" || c.Version != info.Version {
+		return false
+	}
+
+	return !forcedVersions[c.Version]
+}
+
+// ParseCollectionMeta reads collection metadata from galaxy.yml (collection source tree)
+// or MANIFEST.json (built collection), fsys should be rooted at the collection dir.
+func ParseCollectionMeta(fsys fs.FS) (CollectionMeta, error) {
+	var meta CollectionMeta
+	if fileb, err := fs.ReadFile(fsys, "galaxy.yml"); err == nil {
+		if err := yaml.Unmarshal(fileb, &meta); err != nil {
+			return CollectionMeta{}, fmt.Errorf("parsing galaxy.yml: %w", err)
+		}
+	} else if fileb, err := fs.ReadFile(fsys, "MANIFEST.json"); err == nil {
+		var manifest struct {
+			CollectionInfo CollectionMeta `json:"collection_info"`
+		}
+		if err := json.Unmarshal(fileb, &manifest); err != nil {
+			return CollectionMeta{}, fmt.Errorf("parsing MANIFEST.json: %w", err)
+		}
+		meta = manifest.CollectionInfo
+	} else {
+		return CollectionMeta{}, errors.New("neither galaxy.yml nor MANIFEST.json found")
+	}
+
+	if meta.Namespace == "" || meta.Name == "" {
+		return CollectionMeta{}, errors.New("collection namespace or name is not set")
+	}
+	return meta, nil
+}
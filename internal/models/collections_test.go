@@ -0,0 +1,142 @@
+package models
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestCollectionNames(t *testing.T) {
+	tests := []struct {
+		name         string
+		collection   Collection
+		expectedName string
+		expectedRepo string
+		expectedPath string
+	}{
+		{
+			name:         "git url as name",
+			collection:   Collection{Name: "https://github.com/org/ansible-collection-foo.git", Type: "git"},
+			expectedName: "ansible-collection-foo",
+			expectedRepo: "https://github.com/org/ansible-collection-foo.git",
+		},
+		{
+			name:         "fqcn as name, git url as source",
+			collection:   Collection{Name: "org.foo", Source: "git+https://github.com/org/ansible-collection-foo.git", Type: "git"},
+			expectedName: "org.foo",
+			expectedRepo: "https://github.com/org/ansible-collection-foo.git",
+			expectedPath: "ansible_collections/org/foo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.collection.IsGit() {
+				t.Error("IsGit() = false, want true")
+			}
+			if got := tt.collection.GetName(); got != tt.expectedName {
+				t.Errorf("GetName() = %q, want %q", got, tt.expectedName)
+			}
+			if got := tt.collection.Repo(); got != tt.expectedRepo {
+				t.Errorf("Repo() = %q, want %q", got, tt.expectedRepo)
+			}
+			if got := tt.collection.GetPath(); got != tt.expectedPath {
+				t.Errorf("GetPath() = %q, want %q", got, tt.expectedPath)
+			}
+			if got := tt.collection.Host(); got != "github.com" {
+				t.Errorf("Host() = %q, want %q", got, "github.com")
+			}
+		})
+	}
+}
+
+func TestCollectionSetFQCN(t *testing.T) {
+	c := Collection{Name: "https://github.com/org/ansible-collection-foo.git", Type: "git"}
+	c.SetFQCN("org", "foo")
+	if got := c.GetName(); got != "org.foo" {
+		t.Errorf("GetName() = %q, want org.foo", got)
+	}
+	if got := c.GetPath(); got != "ansible_collections/org/foo" {
+		t.Errorf("GetPath() = %q, want ansible_collections/org/foo", got)
+	}
+}
+
+func TestCollectionIsInstalled(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ansible_collections/org/foo/.galaxy_install_info": &fstest.MapFile{
+			Data: []byte("version: v1.0.0\n"),
+		},
+	}
+
+	if !(&Collection{Name: "org.foo", Type: "git", Version: "v1.0.0"}).IsInstalled(fsys) {
+		t.Error("IsInstalled() = false, want true for installed collection")
+	}
+	if (&Collection{Name: "org.foo", Type: "git", Version: "v2.0.0"}).IsInstalled(fsys) {
+		t.Error("IsInstalled() = true, want false for version mismatch")
+	}
+	if (&Collection{Name: "https://github.com/org/foo.git", Type: "git", Version: "v1.0.0"}).IsInstalled(fsys) {
+		t.Error("IsInstalled() = true, want false for unknown namespace and name")
+	}
+}
+
+func TestGalaxyCollectionIsInstalled(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ansible_collections/community/general/MANIFEST.json": &fstest.MapFile{
+			Data: []byte(`{"collection_info": {"namespace": "community", "name": "general", "version": "8.1.0"}}`),
+		},
+	}
+
+	tests := map[string]bool{
+		"8.1.0":          true,
+		"":               true,
+		"*":              true,
+		">=8.0.0,<9.0.0": true,
+		"8.0.0":          false,
+		">=9.0.0":        false,
+	}
+	for constraint, expected := range tests {
+		c := &Collection{Name: "community.general", Version: constraint}
+		if got := c.IsInstalled(fsys); got != expected {
+			t.Errorf("IsInstalled() with version %q = %v, want %v", constraint, got, expected)
+		}
+	}
+	if (&Collection{Name: "community.docker"}).IsInstalled(fsys) {
+		t.Error("IsInstalled() = true, want false for missing collection")
+	}
+}
+
+func TestParseCollectionMeta(t *testing.T) {
+	t.Run("galaxy.yml", func(t *testing.T) {
+		fsys := fstest.MapFS{"galaxy.yml": &fstest.MapFile{Data: []byte("namespace: org\nname: foo\nversion: 1.0.0\n")}}
+		meta, err := ParseCollectionMeta(fsys)
+		if err != nil {
+			t.Fatalf("ParseCollectionMeta() error = %v", err)
+		}
+		if meta.Namespace != "org" || meta.Name != "foo" || meta.Version != "1.0.0" {
+			t.Errorf("ParseCollectionMeta() = %+v, unexpected values", meta)
+		}
+	})
+
+	t.Run("MANIFEST.json", func(t *testing.T) {
+		fsys := fstest.MapFS{"MANIFEST.json": &fstest.MapFile{Data: []byte(`{"collection_info": {"namespace": "org", "name": "bar", "version": "2.0.0"}}`)}}
+		meta, err := ParseCollectionMeta(fsys)
+		if err != nil {
+			t.Fatalf("ParseCollectionMeta() error = %v", err)
+		}
+		if meta.Namespace != "org" || meta.Name != "bar" {
+			t.Errorf("ParseCollectionMeta() = %+v, unexpected values", meta)
+		}
+	})
+
+	t.Run("missing metadata", func(t *testing.T) {
+		if _, err := ParseCollectionMeta(fstest.MapFS{}); err == nil {
+			t.Error("ParseCollectionMeta() expected error for missing metadata, got nil")
+		}
+	})
+
+	t.Run("incomplete metadata", func(t *testing.T) {
+		fsys := fstest.MapFS{"galaxy.yml": &fstest.MapFile{Data: []byte("name: foo\n")}}
+		if _, err := ParseCollectionMeta(fsys); err == nil {
+			t.Error("ParseCollectionMeta() expected error for missing namespace, got nil")
+		}
+	})
+}
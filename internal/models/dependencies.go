@@ -0,0 +1,90 @@
+package models
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// roleDependency is a single dependency from meta/main.yml or meta/requirements.yml,
+// either in short form ("namespace.name" or "src,version,name") or in map form
+type roleDependency struct {
+	Role    string `yaml:"role"`
+	Src     string `yaml:"src"`
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Scm     string `yaml:"scm"`
+}
+
+// UnmarshalYAML supports both short (string) and map forms of the dependency
+func (d *roleDependency) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		parts := strings.Split(node.Value, ",")
+		d.Src = strings.TrimSpace(parts[0])
+		if len(parts) > 1 {
+			d.Version = strings.TrimSpace(parts[1])
+		}
+		if len(parts) > 2 {
+			d.Name = strings.TrimSpace(parts[2])
+		}
+		return nil
+	}
+	type plain roleDependency
+	return node.Decode((*plain)(d))
+}
+
+// entry converts the dependency to the requirements entry.
+// Returns nil if the dependency refers to a role by its plain name (without namespace or src),
+// because such roles are expected to be provided by the playbook itself
+func (d *roleDependency) entry() *Entry {
+	src := d.Src
+	if src == "" {
+		src = d.Role
+	}
+	if !strings.ContainsAny(src, "./") {
+		return nil
+	}
+	return &Entry{Src: src, Name: d.Name, Version: d.Version, Scm: d.Scm}
+}
+
+// ParseRoleDependencies returns dependencies of the installed role, declared in its meta/main.yml (dependencies key)
+// and meta/requirements.yml, same as ansible-galaxy does.
+// fsys should be rooted at the roles directory (e.g. os.DirFS(rolesPath)).
+func ParseRoleDependencies(fsys fs.FS, roleName string) (File, error) {
+	var deps []roleDependency
+
+	if fileb, err := fs.ReadFile(fsys, path.Join(roleName, "meta", "main.yml")); err == nil {
+		var meta struct {
+			Dependencies []roleDependency `yaml:"dependencies"`
+		}
+		if err := yaml.Unmarshal(fileb, &meta); err != nil {
+			return nil, fmt.Errorf("parsing %s/meta/main.yml: %w", roleName, err)
+		}
+		deps = append(deps, meta.Dependencies...)
+	}
+
+	if fileb, err := fs.ReadFile(fsys, path.Join(roleName, "meta", "requirements.yml")); err == nil {
+		var requirements []roleDependency
+		if err := yaml.Unmarshal(fileb, &requirements); err != nil {
+			var requirementsMap struct {
+				Roles []roleDependency `yaml:"roles"`
+			}
+			if err := yaml.Unmarshal(fileb, &requirementsMap); err != nil {
+				return nil, fmt.Errorf("parsing %s/meta/requirements.yml: %w", roleName, err)
+			}
+			requirements = requirementsMap.Roles
+		}
+		deps = append(deps, requirements...)
+	}
+
+	entries := File{}
+	for _, dep := range deps {
+		if entry := dep.entry(); entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries.Deduplicate(), nil
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseRoleDependencies(t *testing.T) {
+	fsys := fstest.MapFS{
+		"parent/meta/main.yml": &fstest.MapFile{Data: []byte(`---
+galaxy_info:
+  author: test
+dependencies:
+  - geerlingguy.docker
+  - common # plain role name, provided by the playbook
+  - role: geerlingguy.pip
+    vars:
+      pip_package: python3-pip
+  - role: custom
+    src: git+https://github.com/org/ansible-role-custom.git
+    version: v1.0.0
+  - git+https://github.com/org/ansible-role-legacy.git,v2.0.0,legacy
+`)},
+		"parent/meta/requirements.yml": &fstest.MapFile{Data: []byte(`---
+- src: https://github.com/org/ansible-role-extra.git
+  version: v3.0.0
+  name: extra
+- src: geerlingguy.docker # duplicate
+`)},
+	}
+
+	deps, err := ParseRoleDependencies(fsys, "parent")
+	if err != nil {
+		t.Fatalf("ParseRoleDependencies() error = %v", err)
+	}
+	expected := []struct{ name, version string }{
+		{"geerlingguy.docker", ""},
+		{"geerlingguy.pip", ""},
+		{"ansible-role-custom", "v1.0.0"},
+		{"legacy", "v2.0.0"},
+		{"extra", "v3.0.0"},
+	}
+	if len(deps) != len(expected) {
+		t.Fatalf("ParseRoleDependencies() = %d deps, want %d", len(deps), len(expected))
+	}
+	for idx, dep := range deps {
+		if dep.GetName() != expected[idx].name || dep.Version != expected[idx].version {
+			t.Errorf("ParseRoleDependencies()[%d] = %s@%s, want %s@%s", idx, dep.GetName(), dep.Version, expected[idx].name, expected[idx].version)
+		}
+	}
+}
+
+func TestParseRoleDependenciesNoMeta(t *testing.T) {
+	deps, err := ParseRoleDependencies(fstest.MapFS{}, "role")
+	if err != nil {
+		t.Fatalf("ParseRoleDependencies() error = %v", err)
+	}
+	if len(deps) != 0 {
+		t.Errorf("ParseRoleDependencies() = %v, want no deps", deps)
+	}
+}
+
+func TestParseRoleDependenciesInvalid(t *testing.T) {
+	fsys := fstest.MapFS{
+		"role/meta/main.yml": &fstest.MapFile{Data: []byte("dependencies: {invalid")},
+	}
+	if _, err := ParseRoleDependencies(fsys, "role"); err == nil {
+		t.Error("ParseRoleDependencies() expected error for invalid yaml, got nil")
+	}
+}
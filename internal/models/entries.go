@@ -1,39 +1,105 @@
 package models
 
 import (
+	"fmt"
 	"io/fs"
+	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/etkecc/agru/internal/archive"
+	"github.com/etkecc/agru/internal/digest"
+	"github.com/etkecc/agru/internal/giturl"
+	"github.com/etkecc/agru/internal/versions"
 )
 
-var forcedVersions = map[string]bool{
-	"main":   true,
-	"master": true,
-}
+const (
+	// SourceGit is a role installed from a git repository
+	SourceGit = "git"
+	// SourceHg is a role installed from a Mercurial repository
+	SourceHg = "hg"
+	// SourceGalaxy is a role installed from the Ansible Galaxy API, referenced by its namespace.name
+	SourceGalaxy = "galaxy"
+	// SourceArchive is a role installed from a tarball URL, e.g. a release artifact
+	SourceArchive = "archive"
+	// SourceLocal is a role installed from a local dir, e.g. a sibling checkout
+	SourceLocal = "local"
+
+	// RefTag is a version that refers to a tag
+	RefTag = "tag"
+	// RefBranch is a version that refers to a branch, the role is reinstalled when the branch's head changes
+	RefBranch = "branch"
+	// RefCommit is a version that refers to a commit hash
+	RefCommit = "commit"
+)
+
+var (
+	forcedVersions = map[string]bool{
+		"main":   true,
+		"master": true,
+	}
+	galaxyNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+\.[A-Za-z0-9_.-]+$`)
+	localPrefixes   = []string{"file://", "/", "./", "../"}
+)
 
 // GalaxyInstallInfo is meta/.galaxy_install_info struct
 type GalaxyInstallInfo struct {
-	InstallDate   string `yaml:"install_date"`
-	InstallCommit string `yaml:"install_commit,omitempty"` // commit hash, agru's own field to help with versions like main, master
-	Version       string `yaml:"version"`
+	InstallDate    string `yaml:"install_date"`
+	InstallCommit  string `yaml:"install_commit,omitempty"`   // commit hash, agru's own field to help with versions like main, master
+	InstallRefType string `yaml:"install_ref_type,omitempty"` // type of the version (tag, branch, or commit), agru's own field
+	Version        string `yaml:"version"`
+	// InstallDigest is the digest of the installed files, agru's own field to detect local modifications
+	InstallDigest string `yaml:"install_digest,omitempty"`
+	// InstallFiles are the installed files (without the install info itself), agru's own field to detect local modifications
+	InstallFiles []digest.File `yaml:"install_files,omitempty"`
+}
+
+// IsBranch checks if the version was installed from a branch.
+// Roles installed before the ref type was recorded are considered installed from a branch only if the version is main or master,
+// see RefTypeUnknown
+func (i GalaxyInstallInfo) IsBranch(version string) bool {
+	if i.InstallRefType != "" {
+		return i.InstallRefType == RefBranch
+	}
+	return forcedVersions[version]
+}
+
+// RefTypeUnknown checks if the version (except a commit hash) was installed before the ref type was recorded,
+// so it can be told whether it's a branch or a tag only by the remote repo
+func (i GalaxyInstallInfo) RefTypeUnknown(version string) bool {
+	return i.InstallRefType == "" && len(version) < 40
 }
 
 // Entry is requirements.yml's entry structure
 type Entry struct {
 	name             string  `yaml:"-"`
+	file             string  `yaml:"-"` // requirements file the entry was read from
+	lockedCommit     string  `yaml:"-"` // commit hash from the lockfile, set in frozen mode
+	lockedRefType    string  `yaml:"-"` // ref type from the lockfile, set in frozen mode
 	Src              string  `yaml:"src,omitempty"`
+	Scm              string  `yaml:"scm,omitempty"`
 	Version          string  `yaml:"version,omitempty"`
 	Name             string  `yaml:"name,omitempty"`
 	Include          string  `yaml:"include,omitempty"`
+	Checksum         string  `yaml:"checksum,omitempty"`         // archive checksum, e.g. sha256:abcd..., agru's own field
+	Index            string  `yaml:"index,omitempty"`            // page listing archive versions, agru's own field
+	Update           string  `yaml:"update,omitempty"`           // update policy: major, minor or patch, agru's own field
+	AllowPrerelease  *bool   `yaml:"allow_prerelease,omitempty"` // allow updates to pre-releases, agru's own field
+	Pin              bool    `yaml:"pin,omitempty"`              // never update, agru's own field
+	TagPattern       string  `yaml:"tag_pattern,omitempty"`      // regex to filter candidate tags, agru's own field
+	VersionScheme    string  `yaml:"version_scheme,omitempty"`   // semver, calver, mdad, or loose, agru's own field
 	ActivationPrefix *string `yaml:"activation_prefix,omitempty"`
 }
 
 // GetName returns entry name with the following priority order
 // 1. name from the requirements.yml file (if set)
-// 2. name, generated from the entry's src
+// 2. name, generated from the entry's src (archive file name without extension and version for archives)
 func (e *Entry) GetName() string {
 	if e.name != "" {
 		return e.name
@@ -44,10 +110,171 @@ func (e *Entry) GetName() string {
 		return e.name
 	}
 
-	e.name = strings.TrimSuffix(path.Base(e.Src), ".git")
+	if e.SourceType() == SourceArchive {
+		e.name = archive.Name(e.Src, e.Version)
+		return e.name
+	}
+
+	e.name = giturl.Name(e.Src)
 	return e.name
 }
 
+// SourceType returns the type of the entry's source, one of the Source* constants
+func (e *Entry) SourceType() string {
+	if e.Scm == SourceHg || strings.HasPrefix(e.Src, "hg+") {
+		return SourceHg
+	}
+	if _, _, ok := e.GalaxyRole(); ok {
+		return SourceGalaxy
+	}
+	if archive.IsArchive(e.Src) {
+		return SourceArchive
+	}
+	for _, prefix := range localPrefixes {
+		if strings.HasPrefix(e.Src, prefix) {
+			return SourceLocal
+		}
+	}
+	return SourceGit
+}
+
+// GalaxyRole returns namespace and name of the Ansible Galaxy role,
+// referenced either as src or (when src is not set) as name, e.g. geerlingguy.docker
+func (e *Entry) GalaxyRole() (namespace, name string, ok bool) {
+	src := e.Src
+	if src == "" {
+		src = e.Name
+	}
+	if !galaxyNameRegex.MatchString(src) {
+		return "", "", false
+	}
+	namespace, name, _ = strings.Cut(src, ".")
+	return namespace, name, true
+}
+
+// ArchiveURL returns the archive URL of the entry, with the version placeholder replaced by the entry's version
+func (e *Entry) ArchiveURL() string {
+	return archive.URL(e.Src, e.Version)
+}
+
+// Repo returns the repository URL of the entry, without scm prefix (e.g. git+ or hg+),
+// git URLs are normalized to the form git understands
+func (e *Entry) Repo() string {
+	if repo, ok := strings.CutPrefix(e.Src, "hg+"); ok {
+		return repo
+	}
+	if e.SourceType() == SourceHg {
+		return e.Src
+	}
+	return giturl.Normalize(e.Src)
+}
+
+// Host returns the remote host the entry is checked and installed from (of git, hg and archive sources),
+// empty for Galaxy and local roles, and for local repos
+func (e *Entry) Host() string {
+	switch e.SourceType() {
+	case SourceGit, SourceHg:
+		if u, err := giturl.Parse(e.Repo()); err == nil {
+			return u.Host
+		}
+	case SourceArchive:
+		if u, err := url.Parse(e.ArchiveURL()); err == nil {
+			return u.Hostname()
+		}
+	}
+	return ""
+}
+
+// Policy returns the entry's update policy, with the entry's own fields overriding the defaults
+func (e *Entry) Policy(defaults versions.Policy) (versions.Policy, error) {
+	policy := defaults
+	if e.Update != "" {
+		policy.Update = e.Update
+	}
+	if e.AllowPrerelease != nil {
+		policy.AllowPrerelease = *e.AllowPrerelease
+	}
+	if e.Pin {
+		policy.Pin = true
+	}
+	if e.VersionScheme != "" {
+		scheme, err := versions.SchemeByName(e.VersionScheme)
+		if err != nil {
+			return policy, err
+		}
+		policy.Scheme = scheme
+	}
+	if e.TagPattern != "" {
+		pattern, err := regexp.Compile(e.TagPattern)
+		if err != nil {
+			return policy, fmt.Errorf("parsing tag_pattern: %w", err)
+		}
+		policy.TagPattern = pattern
+	}
+	return policy, policy.Validate()
+}
+
+// SetFile sets the requirements file the entry was read from,
+// relative local paths and includes are resolved against its dir
+func (e *Entry) SetFile(path string) {
+	e.file = path
+}
+
+// GetFile returns the requirements file the entry was read from, if known
+func (e *Entry) GetFile() string {
+	return e.file
+}
+
+// SetLocked sets the commit hash and ref type from the lockfile, so exactly that commit is installed
+func (e *Entry) SetLocked(commit, refType string) {
+	e.lockedCommit = commit
+	e.lockedRefType = refType
+}
+
+// Locked returns the commit hash and ref type from the lockfile, empty if the entry is not locked
+func (e *Entry) Locked() (commit, refType string) {
+	return e.lockedCommit, e.lockedRefType
+}
+
+// Ref returns the ref to install: the locked commit hash if the entry is locked, the version otherwise
+func (e *Entry) Ref() string {
+	if e.lockedCommit != "" {
+		return e.lockedCommit
+	}
+	return e.Version
+}
+
+// LocalPath returns the path to the local role dir, without file:// prefix
+// and resolved against the dir of the requirements file the entry was read from
+func (e *Entry) LocalPath() string {
+	return e.resolvePath(strings.TrimPrefix(e.Src, "file://"))
+}
+
+// IncludePath returns the path to the included requirements file,
+// resolved against the dir of the requirements file the entry was read from
+func (e *Entry) IncludePath() string {
+	return e.resolvePath(e.Include)
+}
+
+// resolvePath resolves the relative path against the dir of the requirements file the entry was read from
+func (e *Entry) resolvePath(relPath string) string {
+	if filepath.IsAbs(relPath) || e.file == "" {
+		return relPath
+	}
+	return filepath.Join(filepath.Dir(e.file), relPath)
+}
+
+// IsLocalRepo checks if the local role dir is a git repo, either a working copy or a bare repo
+func (e *Entry) IsLocalRepo() bool {
+	localPath := e.LocalPath()
+	if _, err := os.Stat(filepath.Join(localPath, ".git")); err == nil {
+		return true
+	}
+	_, headErr := os.Stat(filepath.Join(localPath, "HEAD"))
+	_, objectsErr := os.Stat(filepath.Join(localPath, "objects"))
+	return headErr == nil && objectsErr == nil
+}
+
 // GetPath returns path to the entry in filesystem
 func (e *Entry) GetPath(rolesPath string) string {
 	return path.Join(rolesPath, e.GetName())
@@ -68,32 +295,32 @@ func (e *Entry) installInfoRelPath() string {
 // A missing file returns a zero-value struct with a nil error.
 // A corrupt file returns a zero-value struct with a non-nil error.
 func (e *Entry) GetInstallInfo(fsys fs.FS) (GalaxyInstallInfo, error) {
-	relPath := e.installInfoRelPath()
-	fileb, err := fs.ReadFile(fsys, relPath)
-	if err != nil {
-		return GalaxyInstallInfo{}, nil //nolint:nilerr // missing file is not an error
-	}
+	return readInstallInfo(fsys, e.installInfoRelPath())
+}
 
-	var info GalaxyInstallInfo
-	if err := yaml.Unmarshal(fileb, &info); err != nil {
-		return GalaxyInstallInfo{}, err
-	}
-	return info, nil
+// GenerateInstallInfo generates fresh install info from current state of the entry struct,
+// refType is the type of the version (see RefTag, RefBranch, RefCommit), empty if unknown,
+// files are the installed files (see InstallInfoFiles)
+func (e *Entry) GenerateInstallInfo(commitSHA, refType string, files []digest.File) ([]byte, error) {
+	return generateInstallInfo(e.Version, commitSHA, refType, files)
 }
 
-// GenerateInstallInfo generates fresh install info from current state of the entry struct
-func (e *Entry) GenerateInstallInfo(commitSHA string) ([]byte, error) {
-	info := GalaxyInstallInfo{
-		InstallDate:   time.Now().UTC().Format("Mon 02 Jan 2006 03:04:05 PM "), // the trailing space is done by ansible-galaxy
-		InstallCommit: commitSHA,
-		Version:       e.Version,
-	}
-	return yaml.Marshal(info)
+// InstallInfoFiles returns the files of the installed role dir, without the install info itself
+func (e *Entry) InstallInfoFiles(rolesPath string) ([]digest.File, error) {
+	return digest.Tree(e.GetPath(rolesPath), path.Join("meta", ".galaxy_install_info"))
 }
 
 // IsInstalled checks if that entry with that specific version is installed.
+// Local roles without version are never considered installed, because they are copied as-is,
+// and roles installed from a branch are not considered installed, because the branch's head has to be checked
+// (same as git and hg roles installed before the ref type was recorded, see RefTypeUnknown).
+// Locked roles are considered installed only if the locked commit is installed.
 // fsys should be rooted at the roles directory (e.g. os.DirFS(rolesPath)).
 func (e *Entry) IsInstalled(fsys fs.FS) bool {
+	if e.SourceType() == SourceLocal && e.Version == "" {
+		return false
+	}
+
 	_, err := fs.Stat(fsys, e.GetName())
 	if err != nil {
 		return false
@@ -103,9 +330,60 @@ func (e *Entry) IsInstalled(fsys fs.FS) bool {
 	if e.Version != info.Version {
 		return false
 	}
+	if e.lockedCommit != "" {
+		return info.InstallCommit == e.lockedCommit
+	}
+	if sourceType := e.SourceType(); (sourceType == SourceGit || sourceType == SourceHg) && info.RefTypeUnknown(e.Version) {
+		return false // the installer checks the remote repo
+	}
 
-	if forcedVersions[e.Version] {
-		return false
+	return !info.IsBranch(e.Version)
+}
+
+// RecordRefType writes the ref type (see RefTag, RefBranch, RefCommit) into the installed role's install info,
+// keeping the rest of it as-is, e.g. for the role installed before the ref type was recorded
+func (e *Entry) RecordRefType(rolesPath, refType string) error {
+	info, err := e.GetInstallInfo(os.DirFS(rolesPath))
+	if err != nil {
+		return err
+	}
+	info.InstallRefType = refType
+	outb, err := yaml.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("generating install info: %w", err)
+	}
+	if err := os.WriteFile(e.GetInstallInfoPath(rolesPath), outb, 0o600); err != nil {
+		return fmt.Errorf("writing install info: %w", err)
+	}
+	return nil
+}
+
+// readInstallInfo parses install info file by its path within fsys.
+// A missing file returns a zero-value struct with a nil error.
+func readInstallInfo(fsys fs.FS, relPath string) (GalaxyInstallInfo, error) {
+	fileb, err := fs.ReadFile(fsys, relPath)
+	if err != nil {
+		return GalaxyInstallInfo{}, nil //nolint:nilerr // missing file is not an error
 	}
-	return true
+
+	var info GalaxyInstallInfo
+	if err := yaml.Unmarshal(fileb, &info); err != nil {
+		return GalaxyInstallInfo{}, err
+	}
+	return info, nil
+}
+
+// generateInstallInfo generates fresh install info for the version, commit, ref type, and installed files
+func generateInstallInfo(version, commitSHA, refType string, files []digest.File) ([]byte, error) {
+	info := GalaxyInstallInfo{
+		InstallDate:    time.Now().UTC().Format("Mon 02 Jan 2006 03:04:05 PM "), // the trailing space is done by ansible-galaxy
+		InstallCommit:  commitSHA,
+		InstallRefType: refType,
+		Version:        version,
+		InstallFiles:   files,
+	}
+	if files != nil {
+		info.InstallDigest = digest.Sum(files)
+	}
+	return yaml.Marshal(info)
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"testing/fstest"
@@ -30,6 +32,11 @@ func TestGetName(t *testing.T) {
 			entry:    Entry{Src: "https://github.com/org/my-role"},
 			expected: "my-role",
 		},
+		{
+			name:     "name derived from archive file name without version",
+			entry:    Entry{Src: "https://artifacts.example.com/my-role-{version}.tar.gz", Version: "1.2.3"},
+			expected: "my-role",
+		},
 		{
 			name:     "cached name returned on second call",
 			entry:    Entry{Src: "git+https://github.com/org/role.git"},
@@ -73,7 +80,7 @@ func TestGenerateInstallInfo(t *testing.T) {
 	entry := Entry{Name: "my-role", Version: "v1.2.3"}
 	commitSHA := "abc123def456"
 
-	outb, err := entry.GenerateInstallInfo(commitSHA)
+	outb, err := entry.GenerateInstallInfo(commitSHA, RefTag, nil)
 	if err != nil {
 		t.Fatalf("GenerateInstallInfo() error = %v", err)
 	}
@@ -175,8 +182,205 @@ func TestIsInstalled(t *testing.T) {
 
 	t.Run("installed when dir exists and version matches", func(t *testing.T) {
 		entry := Entry{Name: "my-role", Version: "v1.0.0"}
-		if !entry.IsInstalled(makeFS("my-role", "v1.0.0")) {
+		if !entry.IsInstalled(makeFS("my-role", "v1.0.0\ninstall_ref_type: tag")) {
 			t.Error("IsInstalled() = false, want true for installed role")
 		}
 	})
+
+	t.Run("not installed when the ref type is unknown", func(t *testing.T) {
+		entry := Entry{Name: "my-role", Version: "develop"}
+		if entry.IsInstalled(makeFS("my-role", "develop")) {
+			t.Error("IsInstalled() = true, want false for git role installed before the ref type was recorded")
+		}
+		galaxy := Entry{Src: "org.my_role", Name: "my-role", Version: "v1.0.0"}
+		if !galaxy.IsInstalled(makeFS("my-role", "v1.0.0")) {
+			t.Error("IsInstalled() = false, want true for galaxy role without the ref type")
+		}
+	})
+
+	t.Run("not installed when installed from a branch", func(t *testing.T) {
+		entry := Entry{Name: "my-role", Version: "develop"}
+		fsys := makeFS("my-role", "develop\ninstall_ref_type: branch")
+		if entry.IsInstalled(fsys) {
+			t.Error("IsInstalled() = true, want false for branch")
+		}
+	})
+
+	t.Run("locked commit", func(t *testing.T) {
+		fsys := makeFS("my-role", "v1.0.0\ninstall_commit: aaa")
+		entry := Entry{Name: "my-role", Version: "v1.0.0"}
+		entry.SetLocked("aaa", RefTag)
+		if !entry.IsInstalled(fsys) {
+			t.Error("IsInstalled() = false, want true for installed locked commit")
+		}
+		entry.SetLocked("bbb", RefTag)
+		if entry.IsInstalled(fsys) {
+			t.Error("IsInstalled() = true, want false for different locked commit")
+		}
+	})
+
+	t.Run("installed when main is a tag", func(t *testing.T) {
+		entry := Entry{Name: "my-role", Version: "main"}
+		if !entry.IsInstalled(makeFS("my-role", "main\ninstall_ref_type: tag")) {
+			t.Error("IsInstalled() = false, want true for tag named main")
+		}
+	})
+}
+
+func TestRecordRefType(t *testing.T) {
+	rolesPath := t.TempDir()
+	entry := &Entry{Name: "my-role", Version: "develop"}
+	if err := os.MkdirAll(filepath.Join(rolesPath, "my-role", "meta"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	data := "install_date: today\ninstall_commit: aaa\nversion: develop\ninstall_digest: sha256:bbb\n"
+	if err := os.WriteFile(entry.GetInstallInfoPath(rolesPath), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := entry.RecordRefType(rolesPath, RefBranch); err != nil {
+		t.Fatalf("RecordRefType() error = %v", err)
+	}
+	info, err := entry.GetInstallInfo(os.DirFS(rolesPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := GalaxyInstallInfo{InstallDate: "today", InstallCommit: "aaa", InstallRefType: RefBranch, Version: "develop", InstallDigest: "sha256:bbb"}
+	if info.InstallRefType != expected.InstallRefType || info.InstallCommit != expected.InstallCommit ||
+		info.InstallDate != expected.InstallDate || info.InstallDigest != expected.InstallDigest || info.Version != expected.Version {
+		t.Errorf("RecordRefType() install info = %+v, want %+v", info, expected)
+	}
+}
+
+func TestIsBranch(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     GalaxyInstallInfo
+		version  string
+		expected bool
+	}{
+		{name: "branch", info: GalaxyInstallInfo{InstallRefType: RefBranch}, version: "develop", expected: true},
+		{name: "tag", info: GalaxyInstallInfo{InstallRefType: RefTag}, version: "main", expected: false},
+		{name: "commit", info: GalaxyInstallInfo{InstallRefType: RefCommit}, version: "abc123", expected: false},
+		{name: "legacy main", info: GalaxyInstallInfo{}, version: "main", expected: true},
+		{name: "legacy master", info: GalaxyInstallInfo{}, version: "master", expected: true},
+		{name: "legacy tag", info: GalaxyInstallInfo{}, version: "v1.0.0", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.IsBranch(tt.version); got != tt.expected {
+				t.Errorf("IsBranch(%q) = %v, want %v", tt.version, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSourceType(t *testing.T) {
+	tests := []struct {
+		name      string
+		entry     Entry
+		expected  string
+		namespace string
+		role      string
+	}{
+		{
+			name:     "git src",
+			entry:    Entry{Src: "git+https://github.com/geerlingguy/ansible-role-docker", Name: "geerlingguy.docker"},
+			expected: SourceGit,
+		},
+		{
+			name:      "galaxy src",
+			entry:     Entry{Src: "geerlingguy.docker"},
+			expected:  SourceGalaxy,
+			namespace: "geerlingguy",
+			role:      "docker",
+		},
+		{
+			name:      "galaxy name without src",
+			entry:     Entry{Name: "community.my_role"},
+			expected:  SourceGalaxy,
+			namespace: "community",
+			role:      "my_role",
+		},
+		{
+			name:     "hg scm",
+			entry:    Entry{Src: "https://hg.example.com/role", Scm: "hg"},
+			expected: SourceHg,
+		},
+		{
+			name:     "hg+ src",
+			entry:    Entry{Src: "hg+https://hg.example.com/role"},
+			expected: SourceHg,
+		},
+		{
+			name:     "local relative src",
+			entry:    Entry{Src: "../roles-dev/foo"},
+			expected: SourceLocal,
+		},
+		{
+			name:     "local file:// src",
+			entry:    Entry{Src: "file:///srv/roles/foo"},
+			expected: SourceLocal,
+		},
+		{
+			name:     "archive src",
+			entry:    Entry{Src: "https://artifacts.example.com/role-{version}.tar.gz", Version: "1.2.3"},
+			expected: SourceArchive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.SourceType(); got != tt.expected {
+				t.Errorf("SourceType() = %q, want %q", got, tt.expected)
+			}
+			namespace, role, _ := tt.entry.GalaxyRole()
+			if namespace != tt.namespace || role != tt.role {
+				t.Errorf("GalaxyRole() = %q, %q, want %q, %q", namespace, role, tt.namespace, tt.role)
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		src      string
+		file     string
+		expected string
+	}{
+		{"file:///srv/roles/foo", "/playbook/requirements.yml", "/srv/roles/foo"},
+		{"/srv/roles/foo", "/playbook/requirements.yml", "/srv/roles/foo"},
+		{"../roles-dev/foo", "/srv/playbook/requirements.yml", "/srv/roles-dev/foo"},
+		{"./roles/foo", "/srv/playbook/requirements.yml", "/srv/playbook/roles/foo"},
+		{"./roles/foo", "", "./roles/foo"},
+	}
+	for _, tt := range tests {
+		entry := Entry{Src: tt.src}
+		entry.SetFile(tt.file)
+		if got := entry.LocalPath(); got != tt.expected {
+			t.Errorf("LocalPath(%q) = %q, want %q", tt.src, got, tt.expected)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		entry    Entry
+		expected string
+	}{
+		{Entry{Src: "git+https://github.com/org/role.git"}, "github.com"},
+		{Entry{Src: "git@gitlab.example.com:org/role.git"}, "gitlab.example.com"},
+		{Entry{Src: "ssh://git@gitlab.example.com:2222/org/role.git"}, "gitlab.example.com"},
+		{Entry{Src: "https://hg.example.com/role", Scm: "hg"}, "hg.example.com"},
+		{Entry{Src: "https://example.com/releases/role-{version}.tar.gz", Version: "1.0.0"}, "example.com"},
+		{Entry{Src: "geerlingguy.docker"}, ""},
+		{Entry{Src: "./roles/foo"}, ""},
+		{Entry{Src: "git+file:///srv/repos/role.git"}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.entry.Host(); got != tt.expected {
+			t.Errorf("Host(%q) = %q, want %q", tt.entry.Src, got, tt.expected)
+		}
+	}
 }
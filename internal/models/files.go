@@ -37,9 +37,10 @@ func (r File) RolesLen() int {
 	return size
 }
 
-// FileMap structure represents requirements.yml file with roles key
+// FileMap structure represents requirements.yml file with roles and collections keys
 type FileMap struct {
-	Roles []*Entry `yaml:"roles"`
+	Roles       []*Entry    `yaml:"roles,omitempty"`
+	Collections Collections `yaml:"collections,omitempty"`
 }
 
 // Slice returns the slice of roles from of RequirementsFileMap
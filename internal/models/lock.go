@@ -0,0 +1,242 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lockHeader is written at the top of the lockfile
+const lockHeader = "# generated by agru, do not edit manually\n"
+
+// Lock is requirements.lock structure, with the exact commits the roles were resolved to
+type Lock struct {
+	Roles       []*LockedRole       `yaml:"roles"`
+	Collections []*LockedCollection `yaml:"collections,omitempty"` // bundled collections only, see AddCollections
+}
+
+// LockedRole is a single role of the lockfile
+type LockedRole struct {
+	Name    string `yaml:"name"`
+	Src     string `yaml:"src"`
+	Version string `yaml:"version,omitempty"`
+	Commit  string `yaml:"commit,omitempty"`   // commit hash the version was resolved to, empty for roles without commits, e.g. galaxy or archive
+	RefType string `yaml:"ref_type,omitempty"` // type of the version (tag, branch, or commit)
+	// Dependency is set for the roles installed as dependencies of other roles (from their meta/main.yml),
+	// not listed in the requirements file, see AddDependencies
+	Dependency bool `yaml:"dependency,omitempty"`
+}
+
+// LockedCollection is a single bundled collection of the lockfile
+type LockedCollection struct {
+	Name    string `yaml:"name"` // namespace.name
+	Src     string `yaml:"src"`  // name of the galaxy collection or repo of the git collection, see Collection.LockSrc
+	Version string `yaml:"version,omitempty"`
+}
+
+// LockPath returns the path to the lockfile of the requirements file, e.g. requirements.yml -> requirements.lock
+func LockPath(requirementsPath string) string {
+	return strings.TrimSuffix(requirementsPath, filepath.Ext(requirementsPath)) + ".lock"
+}
+
+// NewLock creates a lock of the roles (without include directive) from their install info.
+// fsys should be rooted at the roles directory (e.g. os.DirFS(rolesPath)).
+func NewLock(entries File, fsys fs.FS) (*Lock, error) {
+	lock := &Lock{Roles: make([]*LockedRole, 0, len(entries))}
+	for _, entry := range entries {
+		if entry.Include != "" {
+			continue
+		}
+		locked, err := lockRole(entry, fsys)
+		if err != nil {
+			return nil, err
+		}
+		lock.Roles = append(lock.Roles, locked)
+	}
+	lock.sortRoles()
+	return lock, nil
+}
+
+// AddDependencies adds the role dependencies (e.g. the ones installed during the run) to the lock,
+// so they are installed at the locked commits in frozen mode too, see ApplyDependency.
+// fsys should be rooted at the roles directory (e.g. os.DirFS(rolesPath)).
+func (l *Lock) AddDependencies(deps File, fsys fs.FS) error {
+	for _, dep := range deps {
+		locked, err := lockRole(dep, fsys)
+		if err != nil {
+			return err
+		}
+		locked.Dependency = true
+		l.Roles = append(l.Roles, locked)
+	}
+	l.sortRoles()
+	return nil
+}
+
+// KeepDependencies adds the role dependencies locked in the previous lock, e.g. when the roles are not installed during the run
+func (l *Lock) KeepDependencies(previous *Lock) {
+	for _, role := range previous.Roles {
+		if role.Dependency {
+			l.Roles = append(l.Roles, role)
+		}
+	}
+	l.sortRoles()
+}
+
+// lockRole returns the locked role of the entry from its install info
+func lockRole(entry *Entry, fsys fs.FS) (*LockedRole, error) {
+	info, err := entry.GetInstallInfo(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("reading install info of %s: %w", entry.GetName(), err)
+	}
+	locked := &LockedRole{Name: entry.GetName(), Src: entry.Src, Version: entry.Version}
+	if info.Version == entry.Version {
+		locked.Commit = info.InstallCommit
+		locked.RefType = info.InstallRefType
+	}
+	return locked, nil
+}
+
+// sortRoles sorts the locked roles by name
+func (l *Lock) sortRoles() {
+	sort.Slice(l.Roles, func(i, j int) bool {
+		return l.Roles[i].Name < l.Roles[j].Name
+	})
+}
+
+// AddCollections adds the installed collections to the lock, so they can be found by their src in the bundle,
+// even if their namespace and name are known only after cloning.
+// fsys should be rooted at the collections directory (e.g. os.DirFS(collectionsPath)).
+func (l *Lock) AddCollections(collections Collections, fsys fs.FS) error {
+	for _, collection := range collections {
+		version := collection.GetInstalledVersion(fsys)
+		if collection.IsGit() {
+			info, err := collection.GetInstallInfo(fsys)
+			if err != nil {
+				return fmt.Errorf("reading install info of %s: %w", collection.GetName(), err)
+			}
+			version = info.Version
+		}
+		if version == "" {
+			return fmt.Errorf("%s is not installed", collection.GetName())
+		}
+		l.Collections = append(l.Collections, &LockedCollection{Name: collection.GetName(), Src: collection.LockSrc(), Version: version})
+	}
+	sort.Slice(l.Collections, func(i, j int) bool {
+		return l.Collections[i].Name < l.Collections[j].Name
+	})
+	return nil
+}
+
+// Collection returns the locked collection with the collection's src, nil if it's not in the lock
+func (l *Lock) Collection(collection *Collection) *LockedCollection {
+	src := collection.LockSrc()
+	for _, locked := range l.Collections {
+		if locked.Src == src {
+			return locked
+		}
+	}
+	return nil
+}
+
+// Role returns the locked role by the name, nil if it's not in the lock
+func (l *Lock) Role(name string) *LockedRole {
+	for _, role := range l.Roles {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+// ReadLock reads the lockfile
+func ReadLock(path string) (*Lock, error) {
+	fileb, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lockfile %s: %w", path, err)
+	}
+	var lock Lock
+	if err := yaml.Unmarshal(fileb, &lock); err != nil {
+		return nil, fmt.Errorf("unmarshalling lockfile %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// Write writes the lockfile
+func (l *Lock) Write(path string) error {
+	outb, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("marshalling lockfile: %w", err)
+	}
+	if err := os.WriteFile(path, append([]byte(lockHeader), outb...), 0o600); err != nil {
+		return fmt.Errorf("writing lockfile %s: %w", path, err)
+	}
+	return nil
+}
+
+// Apply checks that the roles (without include directive) match the lockfile, and sets their locked commits.
+// All disagreements (roles missing in the lockfile or the requirements file, different src or version) are returned as a single error.
+// The locked dependencies are checked when they are discovered, see ApplyDependency
+func (l *Lock) Apply(entries File) error {
+	locked := make(map[string]*LockedRole, len(l.Roles))
+	for _, role := range l.Roles {
+		locked[role.Name] = role
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if entry.Include != "" {
+			continue
+		}
+		name := entry.GetName()
+		role, ok := locked[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s is not in the lockfile", name))
+			continue
+		}
+		delete(locked, name)
+		if err := role.apply(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, role := range l.Roles {
+		if _, ok := locked[role.Name]; ok && !role.Dependency {
+			errs = append(errs, fmt.Errorf("%s is in the lockfile, but not in the requirements file", role.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ApplyDependency checks that the role dependency matches its locked version, and sets its locked commit
+func (l *Lock) ApplyDependency(dep *Entry) error {
+	name := dep.GetName()
+	for _, role := range l.Roles {
+		if role.Name == name && role.Dependency {
+			return role.apply(dep)
+		}
+	}
+	return fmt.Errorf("dependency %s is not in the lockfile", name)
+}
+
+// apply checks that the entry's src and version match the locked ones, and sets the entry's locked commit.
+// The git and hg roles have to be locked with the commit, the empty commit is valid only for the roles without commits
+func (role *LockedRole) apply(entry *Entry) error {
+	name := entry.GetName()
+	if role.Src != entry.Src {
+		return fmt.Errorf("%s src is %s, but %s is locked", name, entry.Src, role.Src)
+	}
+	if role.Version != entry.Version {
+		return fmt.Errorf("%s version is %s, but %s is locked", name, entry.Version, role.Version)
+	}
+	if sourceType := entry.SourceType(); role.Commit == "" && (sourceType == SourceGit || sourceType == SourceHg) {
+		return fmt.Errorf("%s@%s is locked without a commit", name, role.Version)
+	}
+	entry.SetLocked(role.Commit, role.RefType)
+	return nil
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLockPath(t *testing.T) {
+	tests := map[string]string{
+		"requirements.yml":          "requirements.lock",
+		"setup/requirements.yaml":   "setup/requirements.lock",
+		"requirements":              "requirements.lock",
+		"../playbook/roles.dev.yml": "../playbook/roles.dev.lock",
+	}
+	for path, expected := range tests {
+		if got := LockPath(path); got != expected {
+			t.Errorf("LockPath(%q) = %q, want %q", path, got, expected)
+		}
+	}
+}
+
+func TestNewLock(t *testing.T) {
+	fsys := fstest.MapFS{
+		"role-a/meta/.galaxy_install_info": &fstest.MapFile{
+			Data: []byte("install_commit: aaa\ninstall_ref_type: tag\nversion: v1.0.0\n"),
+		},
+		"role-b/meta/.galaxy_install_info": &fstest.MapFile{
+			Data: []byte("install_commit: bbb\nversion: v1.0.0\n"),
+		},
+	}
+	entries := File{
+		{Name: "role-b", Src: "git+https://github.com/org/role-b.git", Version: "v2.0.0"}, // installed version differs
+		{Name: "role-a", Src: "git+https://github.com/org/role-a.git", Version: "v1.0.0"},
+		{Include: "other.yml"},
+		{Name: "role-c", Src: "geerlingguy.docker", Version: "7.0.0"}, // not installed
+	}
+
+	lock, err := NewLock(entries, fsys)
+	if err != nil {
+		t.Fatalf("NewLock() error = %v", err)
+	}
+	expected := []LockedRole{
+		{Name: "role-a", Src: "git+https://github.com/org/role-a.git", Version: "v1.0.0", Commit: "aaa", RefType: RefTag},
+		{Name: "role-b", Src: "git+https://github.com/org/role-b.git", Version: "v2.0.0"},
+		{Name: "role-c", Src: "geerlingguy.docker", Version: "7.0.0"},
+	}
+	if len(lock.Roles) != len(expected) {
+		t.Fatalf("NewLock() roles = %d, want %d", len(lock.Roles), len(expected))
+	}
+	for i, role := range lock.Roles {
+		if *role != expected[i] {
+			t.Errorf("NewLock() role %d = %+v, want %+v", i, *role, expected[i])
+		}
+	}
+}
+
+func TestLockWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requirements.lock")
+	lock := &Lock{Roles: []*LockedRole{{Name: "role-a", Src: "git+https://github.com/org/role-a.git", Version: "v1.0.0", Commit: "aaa"}}}
+	if err := lock.Write(path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	got, err := ReadLock(path)
+	if err != nil {
+		t.Fatalf("ReadLock() error = %v", err)
+	}
+	if len(got.Roles) != 1 || *got.Roles[0] != *lock.Roles[0] {
+		t.Errorf("ReadLock() = %+v, want %+v", got.Roles, lock.Roles)
+	}
+
+	if _, err := ReadLock(filepath.Join(t.TempDir(), "missing.lock")); err == nil {
+		t.Error("ReadLock() error = nil, want error for missing lockfile")
+	}
+}
+
+func TestLockAddCollections(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ansible_collections/org/foo/.galaxy_install_info": &fstest.MapFile{Data: []byte("version: v1.0.0\n")},
+		"ansible_collections/community/general/MANIFEST.json": &fstest.MapFile{
+			Data: []byte(`{"collection_info": {"namespace": "community", "name": "general", "version": "8.0.0"}}`),
+		},
+	}
+	git := &Collection{Name: "git+https://github.com/org/foo.git", Version: "v1.0.0"}
+	git.SetFQCN("org", "foo")
+	galaxy := &Collection{Name: "community.general", Version: ">=7.0.0"}
+
+	lock := &Lock{}
+	if err := lock.AddCollections(Collections{git, galaxy}, fsys); err != nil {
+		t.Fatalf("AddCollections() error = %v", err)
+	}
+	if len(lock.Collections) != 2 || *lock.Collections[0] != (LockedCollection{Name: "community.general", Src: "community.general", Version: "8.0.0"}) ||
+		*lock.Collections[1] != (LockedCollection{Name: "org.foo", Src: "https://github.com/org/foo.git", Version: "v1.0.0"}) {
+		t.Errorf("AddCollections() = %+v, want the installed collections", lock.Collections)
+	}
+	if got := lock.Collection(&Collection{Name: "git+https://github.com/org/foo.git"}); got == nil || got.Name != "org.foo" {
+		t.Errorf("Collection() = %+v, want org.foo found by its repo", got)
+	}
+	if got := lock.Collection(&Collection{Name: "community.docker"}); got != nil {
+		t.Errorf("Collection() = %+v, want nil", got)
+	}
+	if err := lock.AddCollections(Collections{{Name: "community.docker"}}, fsys); err == nil {
+		t.Error("AddCollections() error = nil, want error for the collection that is not installed")
+	}
+}
+
+func TestLockAddDependencies(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dep-a/meta/.galaxy_install_info": &fstest.MapFile{
+			Data: []byte("install_commit: aaa\ninstall_ref_type: tag\nversion: v1.0.0\n"),
+		},
+	}
+	lock := &Lock{Roles: []*LockedRole{{Name: "role-b", Src: "git+https://github.com/org/role-b.git", Version: "v1.0.0", Commit: "bbb"}}}
+
+	if err := lock.AddDependencies(File{{Name: "dep-a", Src: "git+https://github.com/org/dep-a.git", Version: "v1.0.0"}}, fsys); err != nil {
+		t.Fatalf("AddDependencies() error = %v", err)
+	}
+	expected := []LockedRole{
+		{Name: "dep-a", Src: "git+https://github.com/org/dep-a.git", Version: "v1.0.0", Commit: "aaa", RefType: RefTag, Dependency: true},
+		{Name: "role-b", Src: "git+https://github.com/org/role-b.git", Version: "v1.0.0", Commit: "bbb"},
+	}
+	if len(lock.Roles) != len(expected) {
+		t.Fatalf("AddDependencies() roles = %d, want %d", len(lock.Roles), len(expected))
+	}
+	for i, role := range lock.Roles {
+		if *role != expected[i] {
+			t.Errorf("AddDependencies() role %d = %+v, want %+v", i, *role, expected[i])
+		}
+	}
+
+	kept := &Lock{}
+	kept.KeepDependencies(lock)
+	if len(kept.Roles) != 1 || *kept.Roles[0] != expected[0] {
+		t.Errorf("KeepDependencies() = %+v, want only the dependency", kept.Roles)
+	}
+}
+
+func TestLockApplyDependency(t *testing.T) {
+	lock := &Lock{Roles: []*LockedRole{
+		{Name: "role-a", Src: "git+https://github.com/org/role-a.git", Version: "v1.0.0", Commit: "aaa"},
+		{Name: "dep-a", Src: "git+https://github.com/org/dep-a.git", Version: "v1.0.0", Commit: "ddd", RefType: RefTag, Dependency: true},
+	}}
+
+	dep := &Entry{Src: "git+https://github.com/org/dep-a.git", Version: "v1.0.0"}
+	if err := lock.ApplyDependency(dep); err != nil {
+		t.Fatalf("ApplyDependency() error = %v", err)
+	}
+	if commit, refType := dep.Locked(); commit != "ddd" || refType != RefTag {
+		t.Errorf("Locked() = %q, %q, want %q, %q", commit, refType, "ddd", RefTag)
+	}
+
+	tests := []struct {
+		name     string
+		dep      *Entry
+		expected string
+	}{
+		{"version", &Entry{Src: "git+https://github.com/org/dep-a.git", Version: "v2.0.0"}, "dep-a version is v2.0.0, but v1.0.0 is locked"},
+		{"missing", &Entry{Src: "git+https://github.com/org/dep-b.git", Version: "v1.0.0"}, "dependency dep-b is not in the lockfile"},
+		{"not a dependency", &Entry{Src: "git+https://github.com/org/role-a.git", Version: "v1.0.0"}, "dependency role-a is not in the lockfile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := lock.ApplyDependency(tt.dep); err == nil || err.Error() != tt.expected {
+				t.Errorf("ApplyDependency() error = %v, want %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLockApply(t *testing.T) {
+	lock := &Lock{Roles: []*LockedRole{
+		{Name: "role-a", Src: "git+https://github.com/org/role-a.git", Version: "v1.0.0", Commit: "aaa", RefType: RefTag},
+		{Name: "role-b", Src: "git+https://github.com/org/role-b.git", Version: "v1.0.0", Commit: "bbb"},
+		{Name: "dep-a", Src: "git+https://github.com/org/dep-a.git", Version: "v1.0.0", Commit: "ddd", Dependency: true}, // not in the requirements file
+	}}
+
+	t.Run("match", func(t *testing.T) {
+		entries := File{
+			{Name: "role-a", Src: "git+https://github.com/org/role-a.git", Version: "v1.0.0"},
+			{Name: "role-b", Src: "git+https://github.com/org/role-b.git", Version: "v1.0.0"},
+			{Include: "other.yml"},
+		}
+		if err := lock.Apply(entries); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+		if commit, refType := entries[0].Locked(); commit != "aaa" || refType != RefTag {
+			t.Errorf("Locked() = %q, %q, want %q, %q", commit, refType, "aaa", RefTag)
+		}
+		if ref := entries[1].Ref(); ref != "bbb" {
+			t.Errorf("Ref() = %q, want %q", ref, "bbb")
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		entries := File{
+			{Name: "role-a", Src: "git+https://github.com/org/role-a.git", Version: "v2.0.0"},
+			{Name: "role-c", Src: "git+https://github.com/org/role-c.git", Version: "v1.0.0"},
+		}
+		err := lock.Apply(entries)
+		if err == nil {
+			t.Fatal("Apply() error = nil, want error")
+		}
+		for _, expected := range []string{
+			"role-a version is v2.0.0, but v1.0.0 is locked",
+			"role-c is not in the lockfile",
+			"role-b is in the lockfile, but not in the requirements file",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Apply() error = %q, want it to contain %q", err, expected)
+			}
+		}
+		if commit, _ := entries[0].Locked(); commit != "" {
+			t.Errorf("Locked() = %q, want empty for mismatched role", commit)
+		}
+	})
+
+	t.Run("without commit", func(t *testing.T) {
+		lock := &Lock{Roles: []*LockedRole{
+			{Name: "role-git", Src: "git+https://github.com/org/role-git.git", Version: "v2.0.0"},
+			{Name: "role-hg", Src: "hg+https://hg.example.com/role-hg", Version: "1.0"},
+			{Name: "role-galaxy", Src: "org.role_galaxy", Version: "1.0.0"},
+		}}
+		entries := File{
+			{Name: "role-git", Src: "git+https://github.com/org/role-git.git", Version: "v2.0.0"},
+			{Name: "role-hg", Src: "hg+https://hg.example.com/role-hg", Version: "1.0"},
+			{Name: "role-galaxy", Src: "org.role_galaxy", Version: "1.0.0"},
+		}
+		err := lock.Apply(entries)
+		if err == nil {
+			t.Fatal("Apply() error = nil, want error")
+		}
+		for _, expected := range []string{"role-git@v2.0.0 is locked without a commit", "role-hg@1.0 is locked without a commit"} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Apply() error = %q, want it to contain %q", err, expected)
+			}
+		}
+		if strings.Contains(err.Error(), "role-galaxy") {
+			t.Errorf("Apply() error = %q, want the galaxy role locked without a commit", err)
+		}
+	})
+}
@@ -1,59 +1,96 @@
 package parser
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/etkecc/go-kit/workpool"
 	"gopkg.in/yaml.v3"
 
+	"github.com/etkecc/agru/internal/archive"
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/gitref"
+	"github.com/etkecc/agru/internal/giturl"
+	"github.com/etkecc/agru/internal/hostlimit"
 	"github.com/etkecc/agru/internal/models"
 	"github.com/etkecc/agru/internal/runner"
+	"github.com/etkecc/agru/internal/versions"
 )
 
-var ignoredVersions = map[string]bool{
-	"main":   true,
-	"master": true,
-}
+var (
+	ignoredVersions = map[string]bool{
+		"main":   true,
+		"master": true,
+	}
+	// hgIgnoredVersions are hg's floating refs, similar to git's main/master
+	hgIgnoredVersions = map[string]bool{
+		"default": true,
+		"tip":     true,
+	}
+)
 
 // CheckProgress represents a version check result for a single role.
 type CheckProgress struct {
+	File   string // requirements file the role or collection came from
 	Name   string
 	OldVer string
 	NewVer string // empty = up to date; non-empty = newer version found
-	Err    error
+	// HeldBack is the newest version, that was not selected because of the update policy (e.g. a new major version),
+	// empty if there is no such version
+	HeldBack string
+	// Branch is the branch the installed role tracks (HEAD for the default branch),
+	// empty if the role is not installed from a branch
+	Branch string
+	// Commits is the number of new commits on the branch since the installed commit, -1 if unknown
+	Commits int
+	Err     error
 }
 
 // Parser handles parsing and updating of Ansible Galaxy requirements.yml files.
-// It uses a Runner to check for newer versions of roles via git ls-remote.
+// It uses a Runner to check for newer versions of roles via git ls-remote,
+// a Galaxy API client to check for newer versions of Galaxy roles,
+// and an archive client to check for newer versions of tarball roles.
+// The newer versions are selected according to the update policy,
+// roles installed from git branches are checked for new commits instead.
+// The checks run in a worker pool, limited per host with the Limiter shared with the installer.
 type Parser struct {
-	runner runner.Runner
+	runner  runner.Runner
+	galaxy  *galaxy.Client
+	archive *archive.Client
+	fsys    fs.FS // installed roles, rooted at the roles dir, may be nil
+	policy  versions.Policy
+	limit   int                // max number of parallel checks, 0 - no limit
+	hosts   *hostlimit.Limiter // per-host limits, may be nil
 }
 
-// New creates a new Parser with the given runner
-func New(r runner.Runner) *Parser {
-	return &Parser{runner: r}
+// New creates a new Parser with the given runner, Galaxy API client, installed roles FS (may be nil),
+// the default update policy (can be overridden per role in the requirements file),
+// the max number of parallel checks (0 - no limit) and the per-host limits (may be nil)
+func New(r runner.Runner, g *galaxy.Client, rolesFS fs.FS, policy versions.Policy, limit int, hosts *hostlimit.Limiter) *Parser {
+	return &Parser{runner: r, galaxy: g, archive: archive.New(), fsys: rolesFS, policy: policy, limit: limit, hosts: hosts}
 }
 
 // ParseFile parses requirements.yml file
 func (p *Parser) ParseFile(path string) (main, additional models.File, err error) {
-	fileb, err := os.ReadFile(path)
+	req, _, err := p.readFile(path)
 	if err != nil {
-		return models.File{}, models.File{}, fmt.Errorf("reading file %s: %w", path, err)
-	}
-	var req models.File
-	if err := yaml.Unmarshal(fileb, &req); err != nil {
-		var reqMap models.FileMap
-		if err := yaml.Unmarshal(fileb, &reqMap); err != nil {
-			return models.File{}, models.File{}, fmt.Errorf("unmarshalling yaml %s: %w", path, err)
-		}
-		req = reqMap.Slice()
+		return models.File{}, models.File{}, err
 	}
 	req = req.Deduplicate()
 	req.Sort()
 
-	additional, err = p.parseAdditionalFile(req)
+	chain := []string{path}
+	if abs, absErr := filepath.Abs(path); absErr == nil {
+		chain = []string{abs}
+	}
+	additional, err = p.parseAdditionalFile(req, chain, map[string]bool{})
 	if err != nil {
 		return models.File{}, models.File{}, fmt.Errorf("parsing additional file: %w", err)
 	}
@@ -61,28 +98,94 @@ func (p *Parser) ParseFile(path string) (main, additional models.File, err error
 	return req, additional, nil
 }
 
-// parseAdditionalFile parses additional requirements.yml files referenced via include
-func (p *Parser) parseAdditionalFile(req models.File) (models.File, error) {
+// ParseCollections parses collections section of requirements.yml file
+func (p *Parser) ParseCollections(path string) (models.Collections, error) {
+	_, collections, err := p.readFile(path)
+	if err != nil {
+		return models.Collections{}, err
+	}
+	return collections, nil
+}
+
+// readFile reads and unmarshals requirements.yml file, either in list format (roles only)
+// or in map format (roles and collections keys)
+func (p *Parser) readFile(path string) (models.File, models.Collections, error) {
+	fileb, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading file %s: %w", path, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(fileb, &doc); err != nil {
+		return nil, nil, fmt.Errorf("unmarshalling yaml %s: %w", path, err)
+	}
+	rolesNode, collectionsNode, err := sections(&doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshalling yaml %s: %w", path, err)
+	}
+	var req models.File
+	collections := models.Collections{}
+	if rolesNode != nil {
+		if err := rolesNode.Decode(&req); err != nil {
+			return nil, nil, fmt.Errorf("unmarshalling yaml %s: %w", path, err)
+		}
+	}
+	if collectionsNode != nil {
+		if err := collectionsNode.Decode(&collections); err != nil {
+			return nil, nil, fmt.Errorf("unmarshalling yaml %s: %w", path, err)
+		}
+	}
+	for _, entry := range req {
+		entry.SetFile(path)
+	}
+	return req, collections, nil
+}
+
+// parseAdditionalFile parses additional requirements.yml files referenced via include, recursively.
+// Include paths are resolved relative to the including file, chain contains absolute paths
+// of the files that led to req (the last one is req's file itself) and is used to detect include cycles.
+// Files included more than once (e.g. a shared file included by several other files) are parsed only once
+func (p *Parser) parseAdditionalFile(req models.File, chain []string, visited map[string]bool) (models.File, error) {
 	additional := make([]*models.Entry, 0)
 	for _, entry := range req {
-		if entry.Include != "" {
-			// no recursive iteration over deeper levels, because it's not used anywhere
-			additionalLvl1, additionalLvl2, err := p.ParseFile(entry.Include)
-			if err != nil {
-				return nil, err
-			}
-			additional = append(additional, additionalLvl1...)
-			additional = append(additional, additionalLvl2...)
+		if entry.Include == "" {
+			continue
+		}
+		path, err := filepath.Abs(entry.IncludePath())
+		if err != nil {
+			return nil, fmt.Errorf("resolving include %s: %w", entry.Include, err)
+		}
+		if slices.Contains(chain, path) {
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(append(slices.Clone(chain), path), " -> "))
+		}
+		if visited[path] {
+			continue
 		}
+		visited[path] = true
+
+		included, _, err := p.readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		included = included.Deduplicate()
+		included.Sort()
+		nested, err := p.parseAdditionalFile(included, append(slices.Clone(chain), path), visited)
+		if err != nil {
+			return nil, err
+		}
+		additional = append(additional, included...)
+		additional = append(additional, nested...)
 	}
 
 	return additional, nil
 }
 
-// UpdateFile updates the requirements.yml file with the latest versions.
+// UpdateFile updates the requirements.yml file and the included files with the latest versions of roles and git collections.
+// Each entry is written back to the file it came from (see models.Entry.GetFile), the collections and entries without file
+// are written to the requirementsPath.
 // Progress events are sent to the progress channel (if non-nil); the channel is closed when all checks complete.
-func (p *Parser) UpdateFile(entries models.File, requirementsPath string, progress chan<- CheckProgress) error {
-	_, errs := p.checkVersions(entries, progress)
+// The checks are stopped when the ctx is done, and the files are not written then.
+func (p *Parser) UpdateFile(ctx context.Context, entries models.File, collections models.Collections, requirementsPath string, progress chan<- CheckProgress) error {
+	_, errs := p.checkVersions(ctx, entries, collections, requirementsPath, progress)
 
 	if len(errs) > 0 {
 		errStrs := make([]string, 0, len(errs))
@@ -91,66 +194,142 @@ func (p *Parser) UpdateFile(entries models.File, requirementsPath string, progre
 		}
 		return fmt.Errorf("errors occurred during updating:\n%s", strings.Join(errStrs, "\n"))
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	outb, err := yaml.Marshal(entries)
-	if err != nil {
-		return fmt.Errorf("marshaling yaml: %w", err)
+	files := map[string]models.File{requirementsPath: {}}
+	order := []string{requirementsPath}
+	for _, entry := range entries {
+		file := entryFile(entry, requirementsPath)
+		if _, ok := files[file]; !ok {
+			order = append(order, file)
+		}
+		files[file] = append(files[file], entry)
 	}
-	outb = append([]byte("---\n\n"), outb...) // preserve the separator to make yaml lint happy
-	if err := os.WriteFile(requirementsPath, outb, 0o600); err != nil {
-		return fmt.Errorf("writing file %s: %w", requirementsPath, err)
+	for _, file := range order {
+		var fileCollections models.Collections
+		if file == requirementsPath {
+			fileCollections = collections
+		}
+		if err := writeFile(file, files[file], fileCollections); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// entryFile returns the requirements file the entry came from, or the fallback if it's unknown
+func entryFile(entry *models.Entry, fallback string) string {
+	if file := entry.GetFile(); file != "" {
+		return file
+	}
+	return fallback
+}
+
 // checkEntry checks a single entry for a newer version and updates it in place.
-func (p *Parser) checkEntry(i int, entry *models.Entry, entries models.File, mu *sync.Mutex, changes *models.UpdatedItems, errs *[]error, progress chan<- CheckProgress) {
-	newVersion, err := p.getNewVersion(entry.Src, entry.Version)
+func (p *Parser) checkEntry(ctx context.Context, i int, entry *models.Entry, entries models.File, file string, mu *sync.Mutex, changes *models.UpdatedItems, errs *[]error, progress chan<- CheckProgress) {
+	var (
+		commits                                   int
+		branch, newVersion, heldBack, newChecksum string
+	)
+	release, err := p.hosts.Acquire(ctx, entry.Host())
+	if err == nil {
+		commits, branch, err = p.getBranchCommits(ctx, entry)
+		if err == nil && branch == "" {
+			newVersion, heldBack, err = p.getEntryNewVersion(ctx, entry)
+		}
+		if err == nil && newVersion != "" {
+			newChecksum, err = p.getNewChecksum(ctx, entry, newVersion)
+		}
+		release()
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	if err != nil {
 		*errs = append(*errs, fmt.Errorf("getting new version for %s@%s: %w", entry.GetName(), entry.Version, err))
 		if progress != nil {
-			progress <- CheckProgress{Name: entry.GetName(), OldVer: entry.Version, Err: err}
+			progress <- CheckProgress{File: file, Name: entry.GetName(), OldVer: entry.Version, Err: err}
 		}
 		return
 	}
 	if newVersion != "" {
 		*changes = changes.Add(entry.GetName(), entry.Version, newVersion)
 		if progress != nil {
-			progress <- CheckProgress{Name: entry.GetName(), OldVer: entry.Version, NewVer: newVersion}
+			progress <- CheckProgress{File: file, Name: entry.GetName(), OldVer: entry.Version, NewVer: newVersion, HeldBack: heldBack}
 		}
 		entry.Version = newVersion
+		if newChecksum != "" {
+			entry.Checksum = newChecksum
+		}
 		entries[i] = entry
 		return
 	}
 	if progress != nil {
-		progress <- CheckProgress{Name: entry.GetName(), OldVer: entry.Version}
+		progress <- CheckProgress{File: file, Name: entry.GetName(), OldVer: entry.Version, HeldBack: heldBack, Branch: branch, Commits: commits}
+	}
+}
+
+// checkCollection checks a single collection for a newer version and updates it in place.
+func (p *Parser) checkCollection(ctx context.Context, collection *models.Collection, file string, mu *sync.Mutex, changes *models.UpdatedItems, errs *[]error, progress chan<- CheckProgress) {
+	var newVersion, heldBack string
+	release, err := p.hosts.Acquire(ctx, collection.Host())
+	if err == nil {
+		newVersion, heldBack, err = p.getCollectionNewVersion(ctx, collection)
+		release()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("getting new version for %s@%s: %w", collection.GetName(), collection.Version, err))
+		if progress != nil {
+			progress <- CheckProgress{File: file, Name: collection.GetName(), OldVer: collection.Version, Err: err}
+		}
+		return
+	}
+	if newVersion != "" {
+		*changes = changes.Add(collection.GetName(), collection.Version, newVersion)
+		if progress != nil {
+			progress <- CheckProgress{File: file, Name: collection.GetName(), OldVer: collection.Version, NewVer: newVersion, HeldBack: heldBack}
+		}
+		collection.Version = newVersion
+		return
+	}
+	if progress != nil {
+		progress <- CheckProgress{File: file, Name: collection.GetName(), OldVer: collection.Version, HeldBack: heldBack}
 	}
 }
 
-// checkVersions concurrently checks all entries for newer versions and updates them in place.
+// checkVersions concurrently checks all entries and git collections for newer versions and updates them in place,
+// running up to the parser's limit of checks at once, and up to the host's limit per host.
 // Returns the set of updated items and any errors encountered.
 // Progress events are sent to the progress channel (if non-nil); the channel is closed when done.
-func (p *Parser) checkVersions(entries models.File, progress chan<- CheckProgress) (models.UpdatedItems, []error) {
+func (p *Parser) checkVersions(ctx context.Context, entries models.File, collections models.Collections, requirementsPath string, progress chan<- CheckProgress) (models.UpdatedItems, []error) {
 	var (
 		mu      sync.Mutex
-		wg      sync.WaitGroup
 		changes models.UpdatedItems
 		errs    []error
 	)
 
+	limit := p.limit
+	if limit == 0 {
+		limit = len(entries) + len(collections)
+	}
+	wp := workpool.New(limit)
 	for i, entry := range entries {
 		if entry.Include != "" { // skip entries with include directive
 			continue
 		}
-		wg.Add(1)
-		go func(i int, entry *models.Entry) {
-			defer wg.Done()
-			p.checkEntry(i, entry, entries, &mu, &changes, &errs, progress)
-		}(i, entry)
+		wp.Do(func() {
+			p.checkEntry(ctx, i, entry, entries, entryFile(entry, requirementsPath), &mu, &changes, &errs, progress)
+		})
+	}
+	for _, collection := range collections {
+		wp.Do(func() {
+			p.checkCollection(ctx, collection, requirementsPath, &mu, &changes, &errs, progress)
+		})
 	}
-	wg.Wait()
+	wp.Run()
 	if progress != nil {
 		close(progress)
 	}
@@ -184,36 +363,298 @@ func (p *Parser) MergeFiles(mainReq models.File, additionalReqs ...models.File)
 	return entries
 }
 
+// getEntryNewVersion checks for newer version of the entry allowed by its update policy, using the entry's source type.
+// Returns the new version (if any) and the newest version held back by the policy (if any)
+func (p *Parser) getEntryNewVersion(ctx context.Context, entry *models.Entry) (newVersion, heldBack string, err error) {
+	policy, err := entry.Policy(p.policy)
+	if err != nil {
+		return "", "", err
+	}
+	if policy.Pin {
+		return "", "", nil
+	}
+
+	switch entry.SourceType() {
+	case models.SourceGalaxy:
+		namespace, name, _ := entry.GalaxyRole()
+		return p.getNewGalaxyVersion(ctx, namespace, name, entry.Version, policy)
+	case models.SourceArchive:
+		if ignoredVersions[entry.Version] {
+			return "", "", nil
+		}
+		return p.archive.NewVersion(ctx, entry.Src, entry.Index, entry.Version, policy)
+	case models.SourceLocal:
+		return p.getNewLocalVersion(ctx, entry, policy)
+	case models.SourceHg:
+		return p.getNewHgVersion(ctx, entry.Repo(), entry.Version, policy)
+	default:
+		return p.getNewVersion(ctx, entry.Src, entry.Version, policy)
+	}
+}
+
+// getBranchCommits returns the number of new commits on the branch the installed role tracks, since the installed commit
+// (-1 if the installed commit is not on the branch anymore), and the branch (HEAD for the default branch).
+// The branch is empty if the role is not installed from a git or hg branch
+func (p *Parser) getBranchCommits(ctx context.Context, entry *models.Entry) (commits int, branch string, err error) {
+	if p.fsys == nil || (entry.SourceType() != models.SourceGit && entry.SourceType() != models.SourceHg) {
+		return 0, "", nil
+	}
+	info, _ := entry.GetInstallInfo(p.fsys) //nolint:errcheck // parse failure → not installed from a branch
+	if info.Version != entry.Version || info.InstallCommit == "" {
+		return 0, "", nil
+	}
+	if entry.SourceType() == models.SourceHg {
+		return p.getHgBranchCommits(ctx, entry, info)
+	}
+	if info.RefTypeUnknown(entry.Version) { // installed before the ref type was recorded
+		isBranch, err := gitref.IsRemoteBranch(ctx, p.runner, entry.Repo(), entry.Version)
+		if err != nil || !isBranch {
+			return 0, "", err
+		}
+	} else if !info.IsBranch(entry.Version) {
+		return 0, "", nil
+	}
+
+	branch = entry.Version
+	if branch == "" {
+		branch = gitref.Ref("")
+	}
+	head, err := gitref.Head(ctx, p.runner, entry.Repo(), entry.Version)
+	if err != nil {
+		return 0, branch, err
+	}
+	if head == info.InstallCommit {
+		return 0, branch, nil
+	}
+	commits, err = p.countCommits(ctx, entry.Repo(), entry.Version, info.InstallCommit)
+	return commits, branch, err
+}
+
+// countCommits returns the number of commits on the branch (the default branch if empty) since the commit,
+// or -1 if the commit is not on the branch (e.g. after force push). A bare treeless clone of the branch is used
+func (p *Parser) countCommits(ctx context.Context, repo, branch, since string) (int, error) {
+	tmpdir, err := os.MkdirTemp("", "agru-commits-*")
+	if err != nil {
+		return 0, fmt.Errorf("creating tmp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	args := []string{"git", "clone", "-q", "--bare", "--filter=tree:0", "--single-branch"}
+	if branch != "" {
+		args = append(args, "-b", branch)
+	}
+	if _, err := p.runner.Run(ctx, runner.Cmd("", append(args, repo, tmpdir)...)); err != nil {
+		return 0, fmt.Errorf("cloning repo: %w", err)
+	}
+
+	out, err := p.runner.Run(ctx, runner.Cmd(tmpdir, "git", "rev-list", "--count", since+"..HEAD"))
+	if err != nil {
+		return -1, nil //nolint:nilerr // the installed commit is unknown to the branch
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("counting commits: %w", err)
+	}
+	return count, nil
+}
+
+// getHgBranchCommits is getBranchCommits of the hg role. The hg roles installed before the ref type was recorded
+// are considered installed from a branch only if the version is a floating ref (tip, the default branch)
+func (p *Parser) getHgBranchCommits(ctx context.Context, entry *models.Entry, info models.GalaxyInstallInfo) (commits int, branch string, err error) {
+	branch = entry.Version
+	if branch == "" {
+		branch = "default"
+	}
+	if info.RefTypeUnknown(entry.Version) {
+		if !hgIgnoredVersions[branch] {
+			return 0, "", nil
+		}
+	} else if !info.IsBranch(entry.Version) {
+		return 0, "", nil
+	}
+
+	head, err := p.runner.Run(ctx, runner.Cmd("", "hg", "identify", "--debug", "-i", "-r", branch, entry.Repo()))
+	if err != nil {
+		return 0, branch, fmt.Errorf("identifying changeset: %w", err)
+	}
+	if strings.TrimSpace(head) == info.InstallCommit {
+		return 0, branch, nil
+	}
+
+	tmpdir, err := os.MkdirTemp("", "agru-hg-*")
+	if err != nil {
+		return 0, branch, fmt.Errorf("creating tmp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if _, err := p.runner.Run(ctx, runner.Cmd("", "hg", "clone", "-q", "-U", "-r", branch, entry.Repo(), tmpdir)); err != nil {
+		return 0, branch, fmt.Errorf("running hg clone: %w", err)
+	}
+	out, err := p.runner.Run(ctx, runner.Cmd("", "hg", "log", "-R", tmpdir, "-r", "only("+branch+", "+info.InstallCommit+")", "-T", "x"))
+	if err != nil {
+		return -1, branch, nil //nolint:nilerr // the installed changeset is unknown to the branch
+	}
+	return len(strings.TrimSpace(out)), branch, nil
+}
+
+// getNewChecksum returns the checksum of the entry's archive in the new version,
+// only if the entry is an archive with a pinned checksum
+func (p *Parser) getNewChecksum(ctx context.Context, entry *models.Entry, newVersion string) (string, error) {
+	if entry.SourceType() != models.SourceArchive || entry.Checksum == "" {
+		return "", nil
+	}
+	checksum, err := p.archive.Checksum(ctx, archive.URL(entry.Src, newVersion))
+	if err != nil {
+		return "", fmt.Errorf("getting checksum of the new version: %w", err)
+	}
+	return checksum, nil
+}
+
+// getCollectionNewVersion returns a newer version of the collection allowed by the default update policy (if any),
+// and the newest version held back by the policy (if any).
+// Git collections are checked with ls-remote, galaxy collections - on the galaxy servers,
+// but only if the version is pinned exactly, because ranges (e.g. ">=1.0.0") are resolved on install
+func (p *Parser) getCollectionNewVersion(ctx context.Context, collection *models.Collection) (newVersion, heldBack string, err error) {
+	if collection.IsGit() {
+		return p.getNewVersion(ctx, collection.Repo(), collection.Version, p.policy)
+	}
+	if !versions.IsExact(collection.Version) {
+		return "", "", nil
+	}
+	if p.galaxy == nil {
+		return "", "", fmt.Errorf("galaxy client is not configured")
+	}
+	namespace, name, ok := collection.GetFQCN()
+	if !ok {
+		return "", "", fmt.Errorf("collection name %q is not in namespace.name format", collection.Name)
+	}
+
+	available, err := p.galaxy.CollectionVersions(ctx, collection.Source, namespace, name)
+	if err != nil {
+		return "", "", fmt.Errorf("getting galaxy collection versions: %w", err)
+	}
+	current := strings.TrimSpace(collection.Version)
+	prefix := ""
+	if strings.HasPrefix(current, "==") {
+		prefix = "=="
+		current = strings.TrimSpace(strings.TrimPrefix(current, "=="))
+	}
+	newVersion, heldBack = p.policy.Select(current, available)
+	if newVersion != "" {
+		newVersion = prefix + newVersion
+	}
+	return newVersion, heldBack, nil
+}
+
+// getNewGalaxyVersion checks for newer role version available on the Galaxy server
+func (p *Parser) getNewGalaxyVersion(ctx context.Context, namespace, name, version string, policy versions.Policy) (newVersion, heldBack string, err error) {
+	if ignoredVersions[version] {
+		return "", "", nil
+	}
+	if p.galaxy == nil {
+		return "", "", fmt.Errorf("galaxy client is not configured")
+	}
+
+	available, err := p.galaxy.RoleVersions(ctx, namespace, name)
+	if err != nil {
+		return "", "", fmt.Errorf("getting galaxy role versions: %w", err)
+	}
+	newVersion, heldBack = policy.Select(version, galaxy.RoleVersionNames(available))
+	return newVersion, heldBack, nil
+}
+
 // getNewVersion checks for newer git tag available on the src's remote
-func (p *Parser) getNewVersion(src, version string) (string, error) {
+func (p *Parser) getNewVersion(ctx context.Context, src, version string, policy versions.Policy) (newVersion, heldBack string, err error) {
 	if ignoredVersions[version] {
-		return "", nil
+		return "", "", nil
 	}
 
-	// not a git repo
-	if !strings.Contains(src, "git") {
-		return "", nil
+	// not a git repo, same source detection as the installer uses, e.g. archive URLs are skipped
+	if (&models.Entry{Src: src}).SourceType() != models.SourceGit {
+		return "", "", nil
+	}
+
+	return p.getNewTag(ctx, giturl.Normalize(src), version, policy)
+}
+
+// getNewLocalVersion checks for newer git tag available in the local role dir,
+// only if the dir is a git repo and the version is pinned
+func (p *Parser) getNewLocalVersion(ctx context.Context, entry *models.Entry, policy versions.Policy) (newVersion, heldBack string, err error) {
+	if entry.Version == "" || ignoredVersions[entry.Version] {
+		return "", "", nil
+	}
+	if !entry.IsLocalRepo() { // not a git repo, copied as-is
+		return "", "", nil
+	}
+	return p.getNewTag(ctx, entry.LocalPath(), entry.Version, policy)
+}
+
+// getNewHgVersion checks for newer tag available in the hg repo.
+// hg can't list remote tags, so the repo is cloned without working copy to read them
+func (p *Parser) getNewHgVersion(ctx context.Context, repo, version string, policy versions.Policy) (newVersion, heldBack string, err error) {
+	if version == "" || ignoredVersions[version] || hgIgnoredVersions[version] {
+		return "", "", nil
 	}
 
-	repo := strings.Replace(src, "git+https", "https", 1)
-	tags, err := p.runner.Run("git ls-remote -tq --sort=-version:refname "+repo, "")
+	tmpdir, err := os.MkdirTemp("", "agru-hg-*")
 	if err != nil {
-		return "", fmt.Errorf("running git ls-remote: %w", err)
+		return "", "", fmt.Errorf("creating tmp dir: %w", err)
 	}
-	if tags == "" {
-		return "", nil
+	defer os.RemoveAll(tmpdir)
+
+	if _, err := p.runner.Run(ctx, runner.Cmd("", "hg", "clone", "-q", "-U", repo, tmpdir)); err != nil {
+		return "", "", fmt.Errorf("running hg clone: %w", err)
+	}
+	tags, err := p.runner.Run(ctx, runner.Cmd("", "hg", "tags", "-q", "-R", tmpdir))
+	if err != nil {
+		return "", "", fmt.Errorf("running hg tags: %w", err)
 	}
 
-	lastline := strings.Split(tags, "\n")[0]
-	tagidx := strings.Index(lastline, "refs/tags/")
-	if tagidx == -1 {
-		return "", fmt.Errorf("cannot find tag in git ls-remote output, lastline: %s", lastline)
+	list := []string{}
+	for _, tag := range strings.Split(tags, "\n") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !hgIgnoredVersions[tag] {
+			list = append(list, tag)
+		}
 	}
-	last := strings.Replace(lastline[tagidx:], "refs/tags/", "", 1)
-	last = strings.Replace(last, "^{}", "", 1) // NOTE: very weird case with some github repos, didn't find out why it does that
-	if last != version {
-		return last, nil
+	newVersion, heldBack = policy.Select(version, list)
+	return newVersion, heldBack, nil
+}
+
+// getNewTag returns the newest git tag of the repo allowed by the policy, if it's newer than the version,
+// and the newest tag held back by the policy.
+// Tags are sorted by the policy's version scheme, not by git, and junk tags (e.g. "latest") are ignored
+func (p *Parser) getNewTag(ctx context.Context, repo, version string, policy versions.Policy) (newVersion, heldBack string, err error) {
+	out, err := p.runner.Run(ctx, runner.Cmd("", "git", "ls-remote", "-tq", "--refs", repo))
+	if err != nil {
+		return "", "", fmt.Errorf("running git ls-remote: %w", err)
 	}
+	tags, err := parseTags(out)
+	if err != nil {
+		return "", "", err
+	}
+	newVersion, heldBack = policy.Select(version, tags)
+	return newVersion, heldBack, nil
+}
 
-	return "", nil
+// parseTags returns the unique tag names from the git ls-remote output (lines of "<sha>\trefs/tags/<tag>")
+func parseTags(out string) ([]string, error) {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		_, ref, ok := strings.Cut(line, "\t")
+		tag, isTag := strings.CutPrefix(strings.TrimSpace(ref), "refs/tags/")
+		if !ok || !isTag {
+			return nil, fmt.Errorf("cannot find tag in git ls-remote output, line: %s", line)
+		}
+		tag = strings.TrimSuffix(tag, "^{}") // peeled annotated tag, in case the server ignores --refs
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
 }
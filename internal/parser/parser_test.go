@@ -1,12 +1,28 @@
 package parser
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"gopkg.in/yaml.v3"
+
+	"github.com/etkecc/agru/internal/galaxy"
+	"github.com/etkecc/agru/internal/hostlimit"
 	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/runner"
+	"github.com/etkecc/agru/internal/versions"
 )
 
 // fakeRunner records calls and returns preset outputs
@@ -23,7 +39,8 @@ func newFakeRunner() *fakeRunner {
 	}
 }
 
-func (r *fakeRunner) Run(command, _ string) (string, error) {
+func (r *fakeRunner) Run(_ context.Context, cmd runner.Command) (string, error) {
+	command := cmd.String()
 	r.calls = append(r.calls, command)
 	if err, ok := r.errors[command]; ok {
 		return r.outputs[command], err
@@ -52,7 +69,7 @@ func TestParseFileDirectList(t *testing.T) {
   name: custom-name
 `
 	path := writeTemp(t, content)
-	p := New(newFakeRunner())
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
 
 	main, additional, err := p.ParseFile(path)
 	if err != nil {
@@ -81,7 +98,7 @@ func TestParseFileMapFormat(t *testing.T) {
     version: v2.0.0
 `
 	path := writeTemp(t, content)
-	p := New(newFakeRunner())
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
 
 	main, _, err := p.ParseFile(path)
 	if err != nil {
@@ -99,7 +116,7 @@ func TestParseFileDeduplicate(t *testing.T) {
   version: v2.0.0
 `
 	path := writeTemp(t, content)
-	p := New(newFakeRunner())
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
 
 	main, _, err := p.ParseFile(path)
 	if err != nil {
@@ -127,7 +144,7 @@ func TestParseFileWithInclude(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	p := New(newFakeRunner())
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
 	main, additional, err := p.ParseFile(mainPath)
 	if err != nil {
 		t.Fatalf("ParseFile() error = %v", err)
@@ -144,8 +161,72 @@ func TestParseFileWithInclude(t *testing.T) {
 	}
 }
 
+func TestParseFileWithNestedRelativeInclude(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"playbook/requirements.yml": "- src: git+https://github.com/org/main-role.git\n  version: v1.0.0\n- include: ../shared/common.yml\n",
+		"shared/common.yml":         "- src: git+https://github.com/org/common-role.git\n  version: v2.0.0\n- include: nested/extra.yml\n",
+		"shared/nested/extra.yml":   "- src: git+https://github.com/org/extra-role.git\n  version: v3.0.0\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(t.TempDir()) // includes must not depend on the CWD
+
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
+	_, additional, err := p.ParseFile(filepath.Join(tmpDir, "playbook", "requirements.yml"))
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"common-role": filepath.Join(tmpDir, "shared", "common.yml"),
+		"extra-role":  filepath.Join(tmpDir, "shared", "nested", "extra.yml"),
+	}
+	found := 0
+	for _, entry := range additional {
+		if entry.Include != "" {
+			continue
+		}
+		found++
+		if file, ok := expected[entry.GetName()]; !ok || entry.GetFile() != file {
+			t.Errorf("ParseFile() additional %q GetFile() = %q, want %q", entry.GetName(), entry.GetFile(), file)
+		}
+	}
+	if found != len(expected) {
+		t.Errorf("ParseFile() additional roles = %d, want %d", found, len(expected))
+	}
+}
+
+func TestParseFileIncludeCycle(t *testing.T) {
+	tmpDir := t.TempDir()
+	aPath := filepath.Join(tmpDir, "a.yml")
+	bPath := filepath.Join(tmpDir, "b.yml")
+	if err := os.WriteFile(aPath, []byte("- include: b.yml\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bPath, []byte("- include: ./a.yml\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
+	_, _, err := p.ParseFile(aPath)
+	if err == nil {
+		t.Fatal("ParseFile() expected include cycle error, got nil")
+	}
+	if chain := aPath + " -> " + bPath + " -> " + aPath; !strings.Contains(err.Error(), chain) {
+		t.Errorf("ParseFile() error = %q, want chain %q", err, chain)
+	}
+}
+
 func TestParseFileNotFound(t *testing.T) {
-	p := New(newFakeRunner())
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
 	_, _, err := p.ParseFile("/nonexistent/requirements.yml")
 	if err == nil {
 		t.Error("ParseFile() expected error for missing file, got nil")
@@ -153,10 +234,10 @@ func TestParseFileNotFound(t *testing.T) {
 }
 
 func TestGetNewVersionSkipsIgnored(t *testing.T) {
-	p := New(newFakeRunner())
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
 
 	for _, version := range []string{"main", "master"} {
-		newVer, err := p.getNewVersion("git+https://github.com/org/role.git", version)
+		newVer, _, err := p.getNewVersion(t.Context(), "git+https://github.com/org/role.git", version, versions.Policy{})
 		if err != nil {
 			t.Errorf("getNewVersion(%q) error = %v", version, err)
 		}
@@ -167,8 +248,8 @@ func TestGetNewVersionSkipsIgnored(t *testing.T) {
 }
 
 func TestGetNewVersionSkipsNonGit(t *testing.T) {
-	p := New(newFakeRunner())
-	newVer, err := p.getNewVersion("https://example.com/role.tar.gz", "v1.0.0")
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
+	newVer, _, err := p.getNewVersion(t.Context(), "https://example.com/role.tar.gz", "v1.0.0", versions.Policy{})
 	if err != nil {
 		t.Errorf("getNewVersion() error = %v", err)
 	}
@@ -177,14 +258,29 @@ func TestGetNewVersionSkipsNonGit(t *testing.T) {
 	}
 }
 
+func TestGetNewVersionGitPath(t *testing.T) {
+	fr := newFakeRunner()
+	repo := "https://scm.example.com/git/role"
+	fr.outputs["git ls-remote -tq --refs "+repo] = "abc123\trefs/tags/v2.0.0\ndef456\trefs/tags/v1.0.0"
+
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	newVer, _, err := p.getEntryNewVersion(t.Context(), &models.Entry{Src: repo, Version: "v1.0.0"})
+	if err != nil {
+		t.Fatalf("getEntryNewVersion() error = %v", err)
+	}
+	if newVer != "v2.0.0" {
+		t.Errorf("getEntryNewVersion() = %q, want v2.0.0 for the git src without the .git suffix", newVer)
+	}
+}
+
 func TestGetNewVersionReturnsNewTag(t *testing.T) {
 	fr := newFakeRunner()
 	repo := "https://github.com/org/role.git"
-	cmd := "git ls-remote -tq --sort=-version:refname " + repo
+	cmd := "git ls-remote -tq --refs " + repo
 	fr.outputs[cmd] = "abc123\trefs/tags/v2.0.0\ndef456\trefs/tags/v1.0.0"
 
-	p := New(fr)
-	newVer, err := p.getNewVersion("git+"+repo, "v1.0.0")
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	newVer, _, err := p.getNewVersion(t.Context(), "git+"+repo, "v1.0.0", versions.Policy{})
 	if err != nil {
 		t.Fatalf("getNewVersion() error = %v", err)
 	}
@@ -196,11 +292,11 @@ func TestGetNewVersionReturnsNewTag(t *testing.T) {
 func TestGetNewVersionSameVersion(t *testing.T) {
 	fr := newFakeRunner()
 	repo := "https://github.com/org/role.git"
-	cmd := "git ls-remote -tq --sort=-version:refname " + repo
+	cmd := "git ls-remote -tq --refs " + repo
 	fr.outputs[cmd] = "abc123\trefs/tags/v1.0.0"
 
-	p := New(fr)
-	newVer, err := p.getNewVersion("git+"+repo, "v1.0.0")
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	newVer, _, err := p.getNewVersion(t.Context(), "git+"+repo, "v1.0.0", versions.Policy{})
 	if err != nil {
 		t.Fatalf("getNewVersion() error = %v", err)
 	}
@@ -212,12 +308,12 @@ func TestGetNewVersionSameVersion(t *testing.T) {
 func TestGetNewVersionHandlesCurlyBrace(t *testing.T) {
 	fr := newFakeRunner()
 	repo := "https://github.com/org/role.git"
-	cmd := "git ls-remote -tq --sort=-version:refname " + repo
+	cmd := "git ls-remote -tq --refs " + repo
 	// Some GitHub repos append ^{} to tag refs
 	fr.outputs[cmd] = "abc123\trefs/tags/v2.0.0^{}\ndef456\trefs/tags/v1.0.0"
 
-	p := New(fr)
-	newVer, err := p.getNewVersion("git+"+repo, "v1.0.0")
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	newVer, _, err := p.getNewVersion(t.Context(), "git+"+repo, "v1.0.0", versions.Policy{})
 	if err != nil {
 		t.Fatalf("getNewVersion() error = %v", err)
 	}
@@ -229,19 +325,23 @@ func TestGetNewVersionHandlesCurlyBrace(t *testing.T) {
 func TestUpdateFile(t *testing.T) {
 	fr := newFakeRunner()
 	repo := "https://github.com/org/role-a.git"
-	cmd := "git ls-remote -tq --sort=-version:refname " + repo
+	cmd := "git ls-remote -tq --refs " + repo
 	fr.outputs[cmd] = "abc\trefs/tags/v2.0.0\ndef\trefs/tags/v1.0.0"
 
-	entries := models.File{
-		{Src: "git+" + repo, Version: "v1.0.0"},
+	content := `---
+# roles
+- src: git+https://github.com/org/role-a.git
+  version: v1.0.0 # pinned
+  name: role-a
+`
+	tmpPath := writeTemp(t, content)
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	entries, _, err := p.ParseFile(tmpPath)
+	if err != nil {
+		t.Fatal(err)
 	}
-	// Manually set name to avoid path.Base parsing
-	entries[0].Name = "role-a"
 
-	tmpPath := writeTemp(t, "")
-	p := New(fr)
-
-	if err := p.UpdateFile(entries, tmpPath, nil); err != nil {
+	if err := p.UpdateFile(t.Context(), entries, nil, tmpPath, nil); err != nil {
 		t.Fatalf("UpdateFile() error = %v", err)
 	}
 
@@ -250,13 +350,156 @@ func TestUpdateFile(t *testing.T) {
 		t.Errorf("UpdateFile() entry version = %q, want v2.0.0", entries[0].Version)
 	}
 
-	// File should be written
-	content, err := os.ReadFile(tmpPath)
+	// Only the version should be changed in the file
+	updated, err := os.ReadFile(tmpPath)
 	if err != nil {
 		t.Fatalf("reading updated file: %v", err)
 	}
-	if !strings.HasPrefix(string(content), "---\n") {
-		t.Errorf("UpdateFile() file content should start with ---, got: %q", string(content[:20]))
+	if expected := strings.Replace(content, "v1.0.0", "v2.0.0", 1); string(updated) != expected {
+		t.Errorf("UpdateFile() file content = %q, want %q", updated, expected)
+	}
+}
+
+func TestUpdateFileCancelled(t *testing.T) {
+	fr := newFakeRunner()
+	fr.outputs["git ls-remote -tq --refs https://github.com/org/role-a.git"] = "abc\trefs/tags/v2.0.0"
+	content := "---\n- src: git+https://github.com/org/role-a.git\n  version: v1.0.0\n"
+	path := writeTemp(t, content)
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	entries, _, err := p.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := p.UpdateFile(ctx, entries, nil, path, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("UpdateFile() error = %v, want %v", err, context.Canceled)
+	}
+	if updated, err := os.ReadFile(path); err != nil || string(updated) != content {
+		t.Errorf("UpdateFile() should not write the file after the cancellation, got %q, %v", updated, err)
+	}
+}
+
+func TestUpdateFilePreservesFormatting(t *testing.T) {
+	fr := newFakeRunner()
+	for _, name := range []string{"role-b", "role-c", "role-d"} {
+		fr.outputs["git ls-remote -tq --refs https://github.com/org/"+name+".git"] = "abc\trefs/tags/v2.0.0"
+	}
+	fr.outputs["git ls-remote -tq --refs https://github.com/org/role-a.git"] = "abc\trefs/tags/v1.0.0"
+
+	content := `---
+
+roles:
+  # unchanged role, kept above others despite sorting
+  - src: git+https://github.com/org/role-z.git
+    version: main
+
+  - src: git+https://github.com/org/role-b.git
+    version: "v1.0.0"   # double quoted
+    custom_key: keep me
+  - {src: 'git+https://github.com/org/role-c.git', version: 'v1.0.0'}
+  - src: git+https://github.com/org/role-a.git
+    version: v1.0.0
+  - src: git+https://github.com/org/role-d.git
+    name: role-d
+    version: v1.0.0`
+	path := writeTemp(t, content)
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	entries, _, err := p.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UpdateFile(t.Context(), entries, nil, path, nil); err != nil {
+		t.Fatalf("UpdateFile() error = %v", err)
+	}
+
+	expected := strings.NewReplacer(
+		`"v1.0.0"`, `"v2.0.0"`,
+		`'v1.0.0'`, `'v2.0.0'`,
+		"name: role-d\n    version: v1.0.0", "name: role-d\n    version: v2.0.0",
+	).Replace(content)
+	updated, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(updated) != expected {
+		t.Errorf("UpdateFile() file content =\n%s\nwant\n%s", updated, expected)
+	}
+}
+
+func TestUpdateFileAddsMissingKey(t *testing.T) {
+	src := newSource([]byte("- src: git+https://github.com/org/role-a.git\n  name: role-a\n# end\n"))
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src.data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	mapping := doc.Content[0].Content[0]
+
+	e, err := src.scalarEdit(mapping, "version", "", "v1.0.0")
+	if err != nil {
+		t.Fatalf("scalarEdit() error = %v", err)
+	}
+	expected := "- src: git+https://github.com/org/role-a.git\n  name: role-a\n  version: v1.0.0\n# end\n"
+	if got := string(src.apply([]edit{*e})); got != expected {
+		t.Errorf("scalarEdit() = %q, want %q", got, expected)
+	}
+}
+
+func TestUpdateFileIncluded(t *testing.T) {
+	fr := newFakeRunner()
+	fr.outputs["git ls-remote -tq --refs https://github.com/org/main-role.git"] = "abc\trefs/tags/v1.1.0"
+	fr.outputs["git ls-remote -tq --refs https://github.com/org/shared-role.git"] = "abc\trefs/tags/v2.1.0"
+
+	tmpDir := t.TempDir()
+	mainPath := filepath.Join(tmpDir, "requirements.yml")
+	mainContent := "- src: git+https://github.com/org/main-role.git\n  version: v1.0.0\n- include: shared.yml\n- include: other.yml\n"
+	sharedPath := filepath.Join(tmpDir, "shared.yml")
+	sharedContent := "# shared roles\n- src: git+https://github.com/org/shared-role.git\n  version: v2.0.0\n"
+	files := map[string]string{
+		mainPath:                           mainContent,
+		sharedPath:                         sharedContent,
+		filepath.Join(tmpDir, "other.yml"): "- include: shared.yml\n", // shared file is included twice
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	entries, installOnly, err := p.ParseFile(mainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := make(chan CheckProgress, 10)
+	if err := p.UpdateFile(t.Context(), append(entries, installOnly...), nil, mainPath, progress); err != nil {
+		t.Fatalf("UpdateFile() error = %v", err)
+	}
+
+	checked := map[string]string{}
+	for event := range progress {
+		if _, ok := checked[event.Name]; ok {
+			t.Errorf("UpdateFile() checked %s more than once", event.Name)
+		}
+		checked[event.Name] = event.File
+	}
+	if checked["main-role"] != mainPath || checked["shared-role"] != sharedPath {
+		t.Errorf("UpdateFile() progress files = %v, want main-role in %s, shared-role in %s", checked, mainPath, sharedPath)
+	}
+
+	for path, expected := range map[string]string{
+		mainPath:   strings.Replace(mainContent, "v1.0.0", "v1.1.0", 1),
+		sharedPath: strings.Replace(sharedContent, "v2.0.0", "v2.1.0", 1),
+	} {
+		updated, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(updated) != expected {
+			t.Errorf("UpdateFile() %s content = %q, want %q", path, updated, expected)
+		}
 	}
 }
 
@@ -270,7 +513,7 @@ func TestMergeFiles(t *testing.T) {
 		{Name: "role-c", Version: "v3.0.0"},
 	}
 
-	p := New(newFakeRunner())
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
 	result := p.MergeFiles(main, additional)
 
 	if len(result) != 3 {
@@ -299,7 +542,7 @@ func TestMergeFilesSorted(t *testing.T) {
 		{Name: "mango"},
 	}
 
-	p := New(newFakeRunner())
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
 	result := p.MergeFiles(main, additional)
 
 	if result[0].GetName() != "alpha" || result[1].GetName() != "mango" || result[2].GetName() != "zebra" {
@@ -312,3 +555,528 @@ func TestMergeFilesSorted(t *testing.T) {
 		}())
 	}
 }
+
+func TestGetEntryNewVersionGalaxy(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"available_versions": {"v1": "v1/"}}`))
+	})
+	mux.HandleFunc("/api/v1/roles/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"results": [{"id": 1}]}`))
+	})
+	mux.HandleFunc("/api/v1/roles/1/versions/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"results": [{"name": "6.9.0"}, {"name": "6.10.0"}, {"name": "6.1.0"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	fr := newFakeRunner()
+	p := New(fr, galaxy.New(galaxy.Server{URL: srv.URL}), nil, versions.Policy{}, 0, nil)
+
+	newVer, _, err := p.getEntryNewVersion(t.Context(), &models.Entry{Src: "geerlingguy.docker", Version: "6.1.0"})
+	if err != nil {
+		t.Fatalf("getEntryNewVersion() error = %v", err)
+	}
+	if newVer != "6.10.0" {
+		t.Errorf("getEntryNewVersion() = %q, want 6.10.0", newVer)
+	}
+	if len(fr.calls) != 0 {
+		t.Errorf("getEntryNewVersion() should not run git for galaxy roles, calls: %v", fr.calls)
+	}
+}
+
+func TestParseCollections(t *testing.T) {
+	content := `roles:
+  - src: git+https://github.com/org/role-a.git
+    version: v1.0.0
+collections:
+  - name: https://github.com/org/ansible-collection-foo.git
+    type: git
+    version: v1.0.0
+  - name: community.general
+    version: '>=8.0.0'
+`
+	path := writeTemp(t, content)
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
+
+	collections, err := p.ParseCollections(path)
+	if err != nil {
+		t.Fatalf("ParseCollections() error = %v", err)
+	}
+	if len(collections) != 2 {
+		t.Fatalf("ParseCollections() len = %d, want 2", len(collections))
+	}
+	if !collections[0].IsGit() || collections[1].IsGit() {
+		t.Errorf("ParseCollections() unexpected collection types: %+v, %+v", collections[0], collections[1])
+	}
+
+	listPath := writeTemp(t, "- src: git+https://github.com/org/role-a.git\n  version: v1.0.0\n")
+	collections, err = p.ParseCollections(listPath)
+	if err != nil {
+		t.Fatalf("ParseCollections() list format error = %v", err)
+	}
+	if len(collections) != 0 {
+		t.Errorf("ParseCollections() list format len = %d, want 0", len(collections))
+	}
+}
+
+func TestUpdateFileKeepsCollections(t *testing.T) {
+	fr := newFakeRunner()
+	roleRepo := "https://github.com/org/role-a.git"
+	fr.outputs["git ls-remote -tq --refs "+roleRepo] = "abc\trefs/tags/v2.0.0"
+	collectionRepo := "https://github.com/org/ansible-collection-foo.git"
+	fr.outputs["git ls-remote -tq --refs "+collectionRepo] = "abc\trefs/tags/v1.1.0\ndef\trefs/tags/v1.0.0"
+
+	content := `roles:
+  - src: git+https://github.com/org/role-a.git
+    version: v1.0.0
+collections:
+  - name: https://github.com/org/ansible-collection-foo.git
+    type: git
+    version: v1.0.0
+  - name: community.general
+    version: '>=8.0.0'
+`
+	path := writeTemp(t, content)
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	entries, _, err := p.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collections, err := p.ParseCollections(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UpdateFile(t.Context(), entries, collections, path, nil); err != nil {
+		t.Fatalf("UpdateFile() error = %v", err)
+	}
+
+	updatedEntries, _, err := p.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updatedEntries) != 1 || updatedEntries[0].Version != "v2.0.0" {
+		t.Errorf("UpdateFile() roles = %+v, want role-a@v2.0.0", updatedEntries)
+	}
+	updatedCollections, err := p.ParseCollections(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updatedCollections) != 2 {
+		t.Fatalf("UpdateFile() dropped collections, got %d, want 2", len(updatedCollections))
+	}
+	if updatedCollections[0].Version != "v1.1.0" {
+		t.Errorf("UpdateFile() git collection version = %q, want v1.1.0", updatedCollections[0].Version)
+	}
+	if updatedCollections[1].Version != ">=8.0.0" {
+		t.Errorf("UpdateFile() galaxy collection version = %q, want >=8.0.0 (ranges are resolved on install)", updatedCollections[1].Version)
+	}
+}
+
+func TestGetCollectionNewVersionGalaxy(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"available_versions": {"v3": "v3/"}}`))
+	})
+	mux.HandleFunc("/api/v3/collections/community/general/versions/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"links": {"next": null}, "data": [{"version": "7.0.0"}, {"version": "8.1.0"}, {"version": "9.0.0-rc.1"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	p := New(newFakeRunner(), galaxy.New(galaxy.Server{URL: srv.URL}), nil, versions.Policy{}, 0, nil)
+
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{"7.0.0", "8.1.0"},
+		{"==7.0.0", "==8.1.0"}, // operator is kept
+		{"8.1.0", ""},
+		{">=7.0.0", ""}, // ranges are resolved on install
+		{"", ""},
+	}
+	for _, tt := range tests {
+		newVer, _, err := p.getCollectionNewVersion(t.Context(), &models.Collection{Name: "community.general", Version: tt.version})
+		if err != nil {
+			t.Fatalf("getCollectionNewVersion(%q) error = %v", tt.version, err)
+		}
+		if newVer != tt.expected {
+			t.Errorf("getCollectionNewVersion(%q) = %q, want %q", tt.version, newVer, tt.expected)
+		}
+	}
+}
+
+func TestUpdateFileArchiveChecksum(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/releases/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<a href="role-1.0.0.tar.gz">role-1.0.0.tar.gz</a> <a href="role-1.1.0.tar.gz">role-1.1.0.tar.gz</a>`))
+	})
+	mux.HandleFunc("/releases/role-1.1.0.tar.gz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("archive 1.1.0"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	fr := newFakeRunner()
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	entries := models.File{
+		{Src: srv.URL + "/releases/role-{version}.tar.gz", Version: "1.0.0", Checksum: "sha256:0000", Index: srv.URL + "/releases/"},
+	}
+
+	if err := p.UpdateFile(t.Context(), entries, nil, writeTemp(t, ""), nil); err != nil {
+		t.Fatalf("UpdateFile() error = %v", err)
+	}
+	if entries[0].Version != "1.1.0" {
+		t.Errorf("UpdateFile() entry version = %q, want 1.1.0", entries[0].Version)
+	}
+	sum := sha256.Sum256([]byte("archive 1.1.0"))
+	if expected := "sha256:" + hex.EncodeToString(sum[:]); entries[0].Checksum != expected {
+		t.Errorf("UpdateFile() entry checksum = %q, want %q", entries[0].Checksum, expected)
+	}
+	if len(fr.calls) != 0 {
+		t.Errorf("UpdateFile() should not run git for archive roles, calls: %v", fr.calls)
+	}
+}
+
+func TestGetEntryNewVersionLocal(t *testing.T) {
+	base := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(base, "roles-dev", "foo", ".git"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(base, "roles-dev", "bar"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(base, "playbook", "requirements.yml")
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	content := `- src: ../roles-dev/foo
+  version: v1.0.0
+- src: ../roles-dev/bar
+  version: v1.0.0
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fr := newFakeRunner()
+	fr.outputs["git ls-remote -tq --refs "+filepath.Join(base, "roles-dev", "foo")] = "abc\trefs/tags/v1.1.0"
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+	entries, _, err := p.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"foo": "v1.1.0", "bar": ""} // bar is not a git repo
+	for _, entry := range entries {
+		newVer, _, err := p.getEntryNewVersion(t.Context(), entry)
+		if err != nil {
+			t.Fatalf("getEntryNewVersion(%s) error = %v", entry.GetName(), err)
+		}
+		if newVer != expected[entry.GetName()] {
+			t.Errorf("getEntryNewVersion(%s) = %q, want %q", entry.GetName(), newVer, expected[entry.GetName()])
+		}
+	}
+	if len(fr.calls) != 1 {
+		t.Errorf("getEntryNewVersion() calls = %v, want a single ls-remote of the local git repo", fr.calls)
+	}
+}
+
+// callbackRunner calls a function for each Run invocation
+type callbackRunner struct {
+	fn func(command string) (string, error)
+}
+
+func (r *callbackRunner) Run(_ context.Context, cmd runner.Command) (string, error) {
+	return r.fn(cmd.String())
+}
+
+func TestGetEntryNewVersionHg(t *testing.T) {
+	var calls []string
+	p := New(&callbackRunner{fn: func(command string) (string, error) {
+		calls = append(calls, command)
+		if strings.HasPrefix(command, "hg tags") {
+			return "tip\nv1.10.0\nv1.9.0\nv1.0.0\n", nil
+		}
+		return "", nil
+	}}, nil, nil, versions.Policy{}, 0, nil)
+
+	newVer, _, err := p.getEntryNewVersion(t.Context(), &models.Entry{Src: "hg+https://hg.example.com/role", Version: "v1.0.0"})
+	if err != nil {
+		t.Fatalf("getEntryNewVersion() error = %v", err)
+	}
+	if newVer != "v1.10.0" {
+		t.Errorf("getEntryNewVersion() = %q, want v1.10.0", newVer)
+	}
+	if len(calls) != 2 || !strings.HasPrefix(calls[0], "hg clone -q -U https://hg.example.com/role ") {
+		t.Errorf("getEntryNewVersion() calls = %v, want hg clone and hg tags", calls)
+	}
+
+	newVer, _, err = p.getEntryNewVersion(t.Context(), &models.Entry{Src: "https://hg.example.com/role", Scm: "hg", Version: "default"})
+	if err != nil || newVer != "" {
+		t.Errorf("getEntryNewVersion() = %q, %v, want no update for the default branch", newVer, err)
+	}
+}
+
+func TestUpdateFileKeepsScm(t *testing.T) {
+	path := writeTemp(t, `- src: https://hg.example.com/role
+  scm: hg
+  version: default
+`)
+	p := New(newFakeRunner(), nil, nil, versions.Policy{}, 0, nil)
+	entries, _, err := p.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateFile(t.Context(), entries, nil, path, nil); err != nil {
+		t.Fatalf("UpdateFile() error = %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "scm: hg") {
+		t.Errorf("UpdateFile() should keep the scm field, got:\n%s", content)
+	}
+}
+
+func TestGetNewVersionSSH(t *testing.T) {
+	tests := map[string]string{
+		"git@github.com:org/role.git":           "git@github.com:org/role.git",
+		"git+ssh://git@github.com/org/role.git": "ssh://git@github.com/org/role.git",
+		"ssh://git@example.com:2222/org/role":   "ssh://git@example.com:2222/org/role",
+	}
+	for src, repo := range tests {
+		fr := newFakeRunner()
+		fr.outputs["git ls-remote -tq --refs "+repo] = "abc\trefs/tags/v2.0.0"
+		p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+
+		newVer, _, err := p.getNewVersion(t.Context(), src, "v1.0.0", versions.Policy{})
+		if err != nil {
+			t.Fatalf("getNewVersion(%q) error = %v", src, err)
+		}
+		if newVer != "v2.0.0" {
+			t.Errorf("getNewVersion(%q) = %q, want v2.0.0, calls: %v", src, newVer, fr.calls)
+		}
+	}
+}
+
+func TestUpdateFilePolicy(t *testing.T) {
+	fr := newFakeRunner()
+	for _, name := range []string{"role-minor", "role-pinned", "role-prerelease", "role-default"} {
+		fr.outputs["git ls-remote -tq --refs https://github.com/org/"+name+".git"] = "a\trefs/tags/v3.0.0-rc.1\nb\trefs/tags/v2.0.0\nc\trefs/tags/v1.1.0\nd\trefs/tags/v1.0.0"
+	}
+	path := writeTemp(t, `- src: git+https://github.com/org/role-minor.git
+  version: v1.0.0
+  update: minor
+- src: git+https://github.com/org/role-pinned.git
+  version: v1.0.0
+  pin: true
+- src: git+https://github.com/org/role-prerelease.git
+  version: v1.0.0
+  allow_prerelease: true
+- src: git+https://github.com/org/role-default.git
+  version: v1.0.0
+`)
+	p := New(fr, nil, nil, versions.Policy{Update: versions.UpdatePatch}, 0, nil)
+	entries, _, err := p.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := make(chan CheckProgress, len(entries))
+	if err := p.UpdateFile(t.Context(), entries, nil, path, progress); err != nil {
+		t.Fatalf("UpdateFile() error = %v", err)
+	}
+
+	expected := map[string][2]string{ // new version, held back version
+		"role-minor":      {"v1.1.0", "v2.0.0"},
+		"role-pinned":     {"", ""},
+		"role-prerelease": {"", "v3.0.0-rc.1"}, // patch policy by default
+		"role-default":    {"", "v2.0.0"},
+	}
+	for event := range progress {
+		if got := [2]string{event.NewVer, event.HeldBack}; got != expected[event.Name] {
+			t.Errorf("UpdateFile() %s new, held back = %v, want %v", event.Name, got, expected[event.Name])
+		}
+	}
+}
+
+func TestGetEntryNewVersionSchemes(t *testing.T) {
+	fr := newFakeRunner()
+	fr.outputs["git ls-remote -tq --refs https://github.com/org/role-calver.git"] = "a\trefs/tags/2024.12.01\nb\trefs/tags/2024.9.30\nc\trefs/tags/latest"
+	fr.outputs["git ls-remote -tq --refs https://github.com/org/role-mdad.git"] = "a\trefs/tags/v1.2.3\nb\trefs/tags/v1.2.3-1\nc\trefs/tags/v1.2.3-0\nd\trefs/tags/test-foo"
+	fr.outputs["git ls-remote -tq --refs https://github.com/org/role-prefixed.git"] = "a\trefs/tags/release-1.9\nb\trefs/tags/release-1.10\nc\trefs/tags/v5.0.0"
+	p := New(fr, nil, nil, versions.Policy{}, 0, nil)
+
+	tests := []struct {
+		entry    *models.Entry
+		expected string
+	}{
+		{&models.Entry{Src: "git+https://github.com/org/role-calver.git", Version: "2024.10.01", VersionScheme: "calver"}, "2024.12.01"},
+		{&models.Entry{Src: "git+https://github.com/org/role-mdad.git", Version: "v1.2.3-0", VersionScheme: "mdad"}, "v1.2.3-1"},
+		{&models.Entry{Src: "git+https://github.com/org/role-prefixed.git", Version: "release-1.9", TagPattern: `^release-(.+)$`}, "release-1.10"},
+	}
+	for _, tt := range tests {
+		newVer, _, err := p.getEntryNewVersion(t.Context(), tt.entry)
+		if err != nil {
+			t.Fatalf("getEntryNewVersion(%s) error = %v", tt.entry.GetName(), err)
+		}
+		if newVer != tt.expected {
+			t.Errorf("getEntryNewVersion(%s) = %q, want %q", tt.entry.GetName(), newVer, tt.expected)
+		}
+	}
+
+	if _, _, err := p.getEntryNewVersion(t.Context(), &models.Entry{Src: "git+https://github.com/org/role-calver.git", Version: "1", VersionScheme: "pep440"}); err == nil {
+		t.Error("getEntryNewVersion() expected error for unknown version scheme, got nil")
+	}
+}
+
+func TestGetBranchCommits(t *testing.T) {
+	installed := "abc123def456abc123def456abc123def456abc12"
+	fsys := fstest.MapFS{
+		"role-develop/meta/.galaxy_install_info": &fstest.MapFile{
+			Data: []byte("install_commit: " + installed + "\ninstall_ref_type: branch\nversion: develop\n"),
+		},
+		"role-main/meta/.galaxy_install_info": &fstest.MapFile{ // installed before the ref type was recorded
+			Data: []byte("install_commit: " + installed + "\nversion: main\n"),
+		},
+		"role-tag/meta/.galaxy_install_info": &fstest.MapFile{
+			Data: []byte("install_commit: " + installed + "\ninstall_ref_type: tag\nversion: v1.0.0\n"),
+		},
+		"role-old-tag/meta/.galaxy_install_info": &fstest.MapFile{ // installed before the ref type was recorded
+			Data: []byte("install_commit: " + installed + "\nversion: v1.0.0\n"),
+		},
+	}
+	p := New(&callbackRunner{fn: func(command string) (string, error) {
+		switch {
+		case command == "git ls-remote -q https://github.com/org/role-develop.git refs/heads/develop":
+			return "fff000\trefs/heads/develop", nil
+		case command == "git ls-remote -q https://github.com/org/role-main.git refs/heads/main refs/tags/main",
+			command == "git ls-remote -q https://github.com/org/role-main.git refs/heads/main":
+			return installed + "\trefs/heads/main", nil
+		case command == "git ls-remote -q https://github.com/org/role-old-tag.git refs/heads/v1.0.0 refs/tags/v1.0.0":
+			return "fff000\trefs/tags/v1.0.0\nfff111\trefs/tags/v1.0.0^{}", nil
+		case strings.HasPrefix(command, "git clone -q --bare --filter=tree:0 --single-branch -b develop "):
+			return "", nil
+		case command == "git rev-list --count "+installed+"..HEAD":
+			return "3", nil
+		}
+		return "", fmt.Errorf("unexpected command %q", command)
+	}}, nil, fsys, versions.Policy{}, 0, nil)
+
+	tests := []struct {
+		name    string
+		entry   *models.Entry
+		branch  string
+		commits int
+	}{
+		{name: "new commits", entry: &models.Entry{Src: "git+https://github.com/org/role-develop.git", Version: "develop"}, branch: "develop", commits: 3},
+		{name: "up to date", entry: &models.Entry{Src: "git+https://github.com/org/role-main.git", Version: "main"}, branch: "main"},
+		{name: "tag", entry: &models.Entry{Src: "git+https://github.com/org/role-tag.git", Version: "v1.0.0"}},
+		{name: "tag without the ref type", entry: &models.Entry{Src: "git+https://github.com/org/role-old-tag.git", Version: "v1.0.0"}},
+		{name: "not installed", entry: &models.Entry{Src: "git+https://github.com/org/role-missing.git", Version: "develop"}},
+		{name: "other version installed", entry: &models.Entry{Src: "git+https://github.com/org/role-develop.git", Version: "feature"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commits, branch, err := p.getBranchCommits(t.Context(), tt.entry)
+			if err != nil {
+				t.Fatalf("getBranchCommits() error = %v", err)
+			}
+			if branch != tt.branch || commits != tt.commits {
+				t.Errorf("getBranchCommits() = %d, %q, want %d, %q", commits, branch, tt.commits, tt.branch)
+			}
+		})
+	}
+}
+
+func TestGetHgBranchCommits(t *testing.T) {
+	installed := "0123456789abcdef0123456789abcdef01234567"
+	fsys := fstest.MapFS{
+		"role-stable/meta/.galaxy_install_info": &fstest.MapFile{
+			Data: []byte("install_commit: " + installed + "\ninstall_ref_type: branch\nversion: stable\n"),
+		},
+		"role-default/meta/.galaxy_install_info": &fstest.MapFile{ // installed before the ref type was recorded
+			Data: []byte("install_commit: " + installed + "\nversion: default\n"),
+		},
+		"role-tag/meta/.galaxy_install_info": &fstest.MapFile{ // installed before the ref type was recorded
+			Data: []byte("install_commit: " + installed + "\nversion: v1.0.0\n"),
+		},
+	}
+	p := New(&callbackRunner{fn: func(command string) (string, error) {
+		switch {
+		case command == "hg identify --debug -i -r stable https://hg.example.com/role-stable":
+			return "fedcba9876543210fedcba9876543210fedcba98\n", nil
+		case command == "hg identify --debug -i -r default https://hg.example.com/role-default":
+			return installed + "\n", nil
+		case strings.HasPrefix(command, "hg clone -q -U -r stable https://hg.example.com/role-stable "):
+			return "", nil
+		case strings.HasPrefix(command, "hg log -R ") && strings.HasSuffix(command, " -r only(stable, "+installed+") -T x"):
+			return "xx", nil
+		}
+		return "", fmt.Errorf("unexpected command %q", command)
+	}}, nil, fsys, versions.Policy{}, 0, nil)
+
+	tests := []struct {
+		name    string
+		entry   *models.Entry
+		branch  string
+		commits int
+	}{
+		{name: "new commits", entry: &models.Entry{Src: "hg+https://hg.example.com/role-stable", Version: "stable"}, branch: "stable", commits: 2},
+		{name: "default branch up to date", entry: &models.Entry{Src: "hg+https://hg.example.com/role-default", Version: "default"}, branch: "default"},
+		{name: "tag", entry: &models.Entry{Src: "hg+https://hg.example.com/role-tag", Version: "v1.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commits, branch, err := p.getBranchCommits(t.Context(), tt.entry)
+			if err != nil {
+				t.Fatalf("getBranchCommits() error = %v", err)
+			}
+			if branch != tt.branch || commits != tt.commits {
+				t.Errorf("getBranchCommits() = %d, %q, want %d, %q", commits, branch, tt.commits, tt.branch)
+			}
+		})
+	}
+}
+
+func TestCheckVersionsHostLimit(t *testing.T) {
+	var (
+		mu                sync.Mutex
+		running, maxHosts = map[string]int{}, map[string]int{}
+	)
+	hosts := []string{"github.com", "gitlab.example.com"}
+	p := New(&callbackRunner{fn: func(command string) (string, error) {
+		host := hosts[0]
+		if strings.Contains(command, hosts[1]) {
+			host = hosts[1]
+		}
+		mu.Lock()
+		running[host]++
+		maxHosts[host] = max(maxHosts[host], running[host])
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running[host]--
+		mu.Unlock()
+		return "", nil
+	}}, nil, nil, versions.Policy{}, 0, hostlimit.New(hostlimit.Limits{hosts[0]: 2, hostlimit.Any: 1}))
+
+	var entries models.File
+	for idx := range 6 {
+		for _, host := range hosts {
+			entries = append(entries, &models.Entry{Src: fmt.Sprintf("git+https://%s/org/role-%d.git", host, idx), Version: "v1.0.0"})
+		}
+	}
+	if _, errs := p.checkVersions(t.Context(), entries, nil, "", nil); len(errs) > 0 {
+		t.Fatalf("checkVersions() errors = %v", errs)
+	}
+	if maxHosts[hosts[0]] != 2 {
+		t.Errorf("checkVersions() ran %d checks of %s at once, want 2", maxHosts[hosts[0]], hosts[0])
+	}
+	if maxHosts[hosts[1]] != 1 {
+		t.Errorf("checkVersions() ran %d checks of %s at once, want 1 (the * limit)", maxHosts[hosts[1]], hosts[1])
+	}
+}
@@ -0,0 +1,296 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/etkecc/agru/internal/models"
+)
+
+// edit is a single replacement of the source bytes [start:end) with text
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+// sections returns the roles and collections nodes of the requirements.yml document,
+// either in list format (roles only) or in map format (roles and collections keys).
+// Any of the returned nodes may be nil
+func sections(doc *yaml.Node) (roles, collections *yaml.Node, err error) {
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil, nil, nil
+		}
+		doc = doc.Content[0]
+	}
+	switch doc.Kind {
+	case 0:
+		return nil, nil, nil
+	case yaml.SequenceNode:
+		return doc, nil, nil
+	case yaml.MappingNode:
+		return mappingValue(doc, "roles"), mappingValue(doc, "collections"), nil
+	default:
+		return nil, nil, fmt.Errorf("line %d: expected a list of roles or a map with roles and collections keys", doc.Line)
+	}
+}
+
+// mappingValue returns the value node of the key in the mapping node, or nil if there is no such key
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// writeFile updates the versions (and checksums) of the changed roles and collections in the requirements.yml file,
+// by replacing only their scalars in the original file, so comments, order, quoting and unknown keys are preserved.
+// The file is not written at all if nothing has changed
+func writeFile(path string, entries models.File, collections models.Collections) error {
+	fileb, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading file %s: %w", path, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(fileb, &doc); err != nil {
+		return fmt.Errorf("unmarshalling yaml %s: %w", path, err)
+	}
+	rolesNode, collectionsNode, err := sections(&doc)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	src := newSource(fileb)
+	edits, err := src.roleEdits(rolesNode, entries)
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", path, err)
+	}
+	collectionEdits, err := src.collectionEdits(collectionsNode, collections)
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", path, err)
+	}
+	edits = append(edits, collectionEdits...)
+	if len(edits) == 0 {
+		return nil
+	}
+
+	if err := os.WriteFile(path, src.apply(edits), 0o600); err != nil {
+		return fmt.Errorf("writing file %s: %w", path, err)
+	}
+	return nil
+}
+
+// source is the original requirements.yml file content, with node positions (line, column) mapped to byte offsets
+type source struct {
+	data  []byte
+	lines []int // byte offsets of the lines' starts
+}
+
+// newSource creates a new source from the file content
+func newSource(data []byte) *source {
+	lines := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return &source{data: data, lines: lines}
+}
+
+// roleEdits returns the edits for the roles (the first occurrence of each role name) whose version or checksum has changed
+func (s *source) roleEdits(node *yaml.Node, entries models.File) ([]edit, error) {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil, nil
+	}
+	updated := make(map[string]*models.Entry, len(entries))
+	for _, entry := range entries {
+		if entry.Include == "" {
+			updated[entry.GetName()] = entry
+		}
+	}
+
+	var edits []edit
+	seen := make(map[string]bool, len(node.Content))
+	for _, item := range node.Content {
+		var original models.Entry
+		if err := item.Decode(&original); err != nil {
+			return nil, fmt.Errorf("line %d: %w", item.Line, err)
+		}
+		name := original.GetName()
+		entry, ok := updated[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		for _, field := range [][3]string{
+			{"version", original.Version, entry.Version},
+			{"checksum", original.Checksum, entry.Checksum},
+		} {
+			fieldEdit, err := s.scalarEdit(item, field[0], field[1], field[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s of %s: %w", field[0], name, err)
+			}
+			if fieldEdit != nil {
+				edits = append(edits, *fieldEdit)
+			}
+		}
+	}
+	return edits, nil
+}
+
+// collectionEdits returns the edits for the collections (the first occurrence of each name and source) whose version has changed
+func (s *source) collectionEdits(node *yaml.Node, collections models.Collections) ([]edit, error) {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil, nil
+	}
+	updated := make(map[[2]string]*models.Collection, len(collections))
+	for _, collection := range collections {
+		updated[[2]string{collection.Name, collection.Source}] = collection
+	}
+
+	var edits []edit
+	for _, item := range node.Content {
+		var original models.Collection
+		if err := item.Decode(&original); err != nil {
+			return nil, fmt.Errorf("line %d: %w", item.Line, err)
+		}
+		key := [2]string{original.Name, original.Source}
+		collection, ok := updated[key]
+		if !ok {
+			continue
+		}
+		delete(updated, key)
+		versionEdit, err := s.scalarEdit(item, "version", original.Version, collection.Version)
+		if err != nil {
+			return nil, fmt.Errorf("version of %s: %w", original.Name, err)
+		}
+		if versionEdit != nil {
+			edits = append(edits, *versionEdit)
+		}
+	}
+	return edits, nil
+}
+
+// scalarEdit returns the edit that changes the value of the key in the mapping node from oldValue to newValue,
+// keeping the original quoting style. If the key is missing, it is added after the mapping's last key.
+// Returns nil if the value hasn't changed
+func (s *source) scalarEdit(mapping *yaml.Node, key, oldValue, newValue string) (*edit, error) {
+	if oldValue == newValue {
+		return nil, nil
+	}
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a map", mapping.Line)
+	}
+
+	value := mappingValue(mapping, key)
+	if value == nil {
+		return s.insertEdit(mapping, key, newValue)
+	}
+	if value.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("line %d: expected a scalar", value.Line)
+	}
+
+	start := s.offset(value.Line, value.Column)
+	var end int
+	var text string
+	switch value.Style {
+	case yaml.DoubleQuotedStyle:
+		end = s.quotedEnd(start, '"')
+		text = strconv.Quote(newValue)
+	case yaml.SingleQuotedStyle:
+		end = s.quotedEnd(start, '\'')
+		text = "'" + strings.ReplaceAll(newValue, "'", "''") + "'"
+	case 0:
+		end = start + len(value.Value)
+		text = newValue
+		if end > len(s.data) || string(s.data[start:end]) != value.Value {
+			return nil, fmt.Errorf("line %d: unsupported multiline or tagged value", value.Line)
+		}
+	default:
+		return nil, fmt.Errorf("line %d: unsupported value style", value.Line)
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("line %d: unterminated quoted value", value.Line)
+	}
+	return &edit{start: start, end: end, text: text}, nil
+}
+
+// insertEdit returns the edit that adds the key with the value to the block mapping node,
+// on a new line after the mapping's last (single-line) value, with the same indentation as the mapping's keys
+func (s *source) insertEdit(mapping *yaml.Node, key, value string) (*edit, error) {
+	if mapping.Style&yaml.FlowStyle != 0 || len(mapping.Content) < 2 {
+		return nil, fmt.Errorf("line %d: can't add %s to the flow or empty map", mapping.Line, key)
+	}
+	last := mapping.Content[len(mapping.Content)-1]
+	if last.Kind != yaml.ScalarNode || last.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		return nil, fmt.Errorf("line %d: can't add %s after the multiline value", last.Line, key)
+	}
+
+	text := strings.Repeat(" ", mapping.Content[0].Column-1) + key + ": " + value + "\n"
+	if last.Line >= len(s.lines) { // the last line without trailing newline
+		if !bytes.HasSuffix(s.data, []byte("\n")) {
+			text = "\n" + text
+		}
+		return &edit{start: len(s.data), end: len(s.data), text: text}, nil
+	}
+	start := s.lines[last.Line]
+	return &edit{start: start, end: start, text: text}, nil
+}
+
+// offset returns the byte offset of the 1-based line and (character) column
+func (s *source) offset(line, column int) int {
+	if line < 1 || line > len(s.lines) {
+		return len(s.data)
+	}
+	offset := s.lines[line-1]
+	for i := 1; i < column && offset < len(s.data); i++ {
+		_, size := utf8.DecodeRune(s.data[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// quotedEnd returns the byte offset right after the closing quote of the quoted scalar starting at start,
+// or -1 if the scalar is not terminated
+func (s *source) quotedEnd(start int, quote byte) int {
+	for i := start + 1; i < len(s.data); i++ {
+		switch {
+		case quote == '"' && s.data[i] == '\\':
+			i++
+		case s.data[i] == quote && quote == '\'' && i+1 < len(s.data) && s.data[i+1] == '\'':
+			i++
+		case s.data[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// apply returns the source with the edits applied
+func (s *source) apply(edits []edit) []byte {
+	slices.SortStableFunc(edits, func(a, b edit) int {
+		return a.start - b.start
+	})
+	var out bytes.Buffer
+	var last int
+	for _, e := range edits {
+		out.Write(s.data[last:e.start])
+		out.WriteString(e.text)
+		last = e.end
+	}
+	out.Write(s.data[last:])
+	return out.Bytes()
+}
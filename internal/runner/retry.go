@@ -0,0 +1,156 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// permanentErrors are the stderr patterns (lowercase) of the failures that won't go away on retry,
+	// e.g. a missing tag, denied auth or a nonexistent repo, checked before the transientErrors
+	permanentErrors = []string{
+		"not found",
+		"authentication failed",
+		"permission denied",
+		"access denied",
+		"could not read username",
+		"does not appear to be a git repository",
+		"unknown revision",
+	}
+	// transientErrors are the stderr patterns (lowercase) of the network failures that may go away on retry
+	transientErrors = []string{
+		"could not resolve host",                     // DNS
+		"temporary failure in name resolution",       // DNS
+		"couldn't connect to server",                 // curl
+		"failed to connect",                          // curl
+		"connection reset",                           // TCP or TLS reset
+		"connection timed out",                       // TCP
+		"operation timed out",                        // curl
+		"gnutls_handshake() failed",                  // TLS
+		"tls connection was non-properly terminated", // TLS
+		"ssl_read",                            // TLS
+		"ssl_connect",                         // TLS
+		"unexpected disconnect",               // git
+		"early eof",                           // git
+		"the remote end hung up unexpectedly", // git
+		"rpc failed",                          // git over http
+		"too many requests",                   // HTTP 429
+	}
+	// httpStatusRegex matches the HTTP 429 and 5xx statuses in git and curl errors,
+	// e.g. "The requested URL returned error: 503" or "HTTP 502"
+	httpStatusRegex = regexp.MustCompile(`(?:error:|http(?:/[0-9.]+)?) (?:429|5[0-9][0-9])\b`)
+)
+
+// RetryPolicy is the policy of retrying the commands failed with transient errors, see IsTransient
+type RetryPolicy struct {
+	Retries  int           // max number of retries of a command, 0 - no retries
+	Delay    time.Duration // delay before the first retry, doubled before each next one
+	MaxDelay time.Duration // max delay before a retry
+}
+
+// DefaultRetryPolicy returns the retry policy with the default delays and the retries count
+func DefaultRetryPolicy(retries int) RetryPolicy {
+	return RetryPolicy{Retries: retries, Delay: time.Second, MaxDelay: 30 * time.Second}
+}
+
+// Backoff returns the delay before the retry (starting with 1): the policy's delay doubled on each retry
+// (up to the max delay), with jitter, so the commands failed at the same time don't retry at the same time
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.Delay
+	for range retry - 1 {
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(half+1) //nolint:gosec // jitter doesn't need a secure random
+}
+
+// IsTransient checks if the command failed with an error that may go away on retry:
+// DNS errors, TLS resets, HTTP 429/5xx, "early EOF" and timeouts.
+// The cancellation and the failures such as a missing tag, denied auth or a nonexistent repo are not transient
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) { // the runner's timeout, see ShellRunner
+		return true
+	}
+	var runErr *Error
+	if !errors.As(err, &runErr) {
+		return false
+	}
+	stderr := strings.ToLower(runErr.Stderr)
+	for _, pattern := range permanentErrors {
+		if strings.Contains(stderr, pattern) {
+			return false
+		}
+	}
+	for _, pattern := range transientErrors {
+		if strings.Contains(stderr, pattern) {
+			return true
+		}
+	}
+	return httpStatusRegex.MatchString(stderr)
+}
+
+// RetryRunner runs the commands with the wrapped runner, and retries the ones failed with transient errors
+// according to the policy. The commands with stdin are not retried, as their stdin is already consumed.
+// It implements the Runner interface.
+type RetryRunner struct {
+	runner  Runner
+	policy  RetryPolicy
+	onRetry func(cmd Command, retry int, delay time.Duration, err error)
+}
+
+// NewRetryRunner creates a new RetryRunner, onRetry (optional) is called before each retry, e.g. to log it
+func NewRetryRunner(r Runner, policy RetryPolicy, onRetry func(cmd Command, retry int, delay time.Duration, err error)) *RetryRunner {
+	return &RetryRunner{runner: r, policy: policy, onRetry: onRetry}
+}
+
+// Run executes the command with the wrapped runner, retrying it on transient errors until the retries are exhausted
+// or the ctx is done. The command's BeforeRetry func is called before each retry.
+// The error of the retried command reports the number of attempts
+func (r *RetryRunner) Run(ctx context.Context, cmd Command) (string, error) {
+	for attempt := 1; ; attempt++ {
+		out, err := r.runner.Run(ctx, cmd)
+		if err == nil || attempt > r.policy.Retries || cmd.Stdin != nil || ctx.Err() != nil || !IsTransient(err) {
+			return out, retriedError(err, attempt)
+		}
+
+		delay := r.policy.Backoff(attempt) // the retry number is the number of the failed attempts
+		if r.onRetry != nil {
+			r.onRetry(cmd, attempt, delay, err)
+		}
+		select {
+		case <-ctx.Done():
+			return out, retriedError(err, attempt)
+		case <-time.After(delay):
+		}
+		if cmd.BeforeRetry != nil {
+			if retryErr := cmd.BeforeRetry(); retryErr != nil {
+				return out, retriedError(fmt.Errorf("%w, can't retry: %w", err, retryErr), attempt)
+			}
+		}
+	}
+}
+
+// retriedError adds the number of attempts to the error of the retried command
+func retriedError(err error, attempts int) error {
+	if err == nil || attempts == 1 {
+		return err
+	}
+	return fmt.Errorf("%w (attempts: %d)", err, attempts)
+}
@@ -0,0 +1,183 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubRunner fails the first failures runs with the err, and succeeds afterwards
+type stubRunner struct {
+	err      error
+	failures int
+	calls    int
+}
+
+func (r *stubRunner) Run(_ context.Context, _ Command) (string, error) {
+	r.calls++
+	if r.calls <= r.failures {
+		return "", r.err
+	}
+	return "ok", nil
+}
+
+func stderrError(stderr string) error {
+	return &Error{Command: "git clone", Stderr: stderr, Err: errors.New("exit status 128")}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"dns", stderrError("fatal: unable to access 'https://github.com/org/role.git/': Could not resolve host: github.com"), true},
+		{"ssh dns", stderrError("ssh: Could not resolve hostname github.com: Temporary failure in name resolution"), true},
+		{"connect", stderrError("fatal: unable to access 'https://github.com/org/role.git/': Failed to connect to github.com port 443 after 135428 ms: Couldn't connect to server"), true},
+		{"tls reset", stderrError("fatal: unable to access 'https://github.com/org/role.git/': OpenSSL SSL_read: Connection was reset, errno 10054"), true},
+		{"gnutls", stderrError("error: RPC failed; curl 56 GnuTLS recv error (-110): The TLS connection was non-properly terminated."), true},
+		{"http 429", stderrError("fatal: unable to access 'https://github.com/org/role.git/': The requested URL returned error: 429"), true},
+		{"http 503", stderrError("fatal: unable to access 'https://github.com/org/role.git/': The requested URL returned error: 503"), true},
+		{"http 502 rpc", stderrError("error: RPC failed; HTTP 502 curl 22 The requested URL returned error: 502"), true},
+		{"early eof", stderrError("fetch-pack: unexpected disconnect while reading sideband packet\nfatal: early EOF"), true},
+		{"timeout", &Error{Command: "git clone", Err: fmt.Errorf("timed out after 10m0s: %w", context.DeadlineExceeded)}, true},
+		{"missing tag", stderrError("warning: Could not find remote branch v9.9.9 to clone.\nfatal: Remote branch v9.9.9 not found in upstream origin"), false},
+		{"missing repo", stderrError("remote: Repository not found.\nfatal: repository 'https://github.com/org/nope.git/' not found"), false},
+		{"auth denied", stderrError("git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository."), false},
+		{"auth failed", stderrError("remote: Invalid username or token.\nfatal: Authentication failed for 'https://github.com/org/role.git/'"), false},
+		{"http 404", stderrError("fatal: unable to access 'https://example.com/role.git/': The requested URL returned error: 404"), false},
+		{"cancelled", &Error{Command: "git clone", Err: context.Canceled}, false},
+		{"other", errors.New("exec: \"git\": executable file not found in $PATH"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.expected {
+			t.Errorf("IsTransient(%s) = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Delay: time.Second, MaxDelay: 5 * time.Second}
+	tests := []struct {
+		retry    int
+		expected time.Duration // the delay without jitter, the jittered delay is within [expected/2, expected]
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 10 {
+			if got := p.Backoff(tt.retry); got < tt.expected/2 || got > tt.expected {
+				t.Errorf("Backoff(%d) = %s, want within [%s, %s]", tt.retry, got, tt.expected/2, tt.expected)
+			}
+		}
+	}
+	if got := (RetryPolicy{}).Backoff(1); got != 0 {
+		t.Errorf("Backoff() = %s, want 0 without the delay", got)
+	}
+}
+
+func TestRetryRunner(t *testing.T) {
+	transient := stderrError("fatal: early EOF")
+	permanent := stderrError("fatal: Remote branch v9.9.9 not found in upstream origin")
+	tests := []struct {
+		name          string
+		err           error
+		failures      int
+		retries       int
+		expectedCalls int
+		expectedErr   bool
+	}{
+		{"succeeds without retries", nil, 0, 3, 1, false},
+		{"retries transient errors", transient, 2, 3, 3, false},
+		{"fails after the retries", transient, 10, 3, 4, true},
+		{"fails fast on permanent errors", permanent, 10, 3, 1, true},
+		{"no retries", transient, 10, 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubRunner{err: tt.err, failures: tt.failures}
+			var retries []int
+			r := NewRetryRunner(stub, RetryPolicy{Retries: tt.retries, Delay: time.Millisecond}, func(_ Command, retry int, _ time.Duration, _ error) {
+				retries = append(retries, retry)
+			})
+
+			out, err := r.Run(t.Context(), Cmd("", "git", "clone"))
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.expectedErr)
+			}
+			if !tt.expectedErr && out != "ok" {
+				t.Errorf("Run() = %q, want %q", out, "ok")
+			}
+			if stub.calls != tt.expectedCalls {
+				t.Errorf("Run() called the command %d times, want %d", stub.calls, tt.expectedCalls)
+			}
+			if len(retries) != tt.expectedCalls-1 {
+				t.Errorf("Run() reported retries %v, want %d", retries, tt.expectedCalls-1)
+			}
+		})
+	}
+}
+
+func TestRetryRunnerError(t *testing.T) {
+	stub := &stubRunner{err: stderrError("fatal: early EOF"), failures: 10}
+	_, err := NewRetryRunner(stub, RetryPolicy{Retries: 2}, nil).Run(t.Context(), Cmd("", "git", "clone"))
+	var runErr *Error
+	if !errors.As(err, &runErr) {
+		t.Fatalf("Run() error = %v, want *Error", err)
+	}
+	if !strings.Contains(err.Error(), "attempts: 3") {
+		t.Errorf("Run() error = %q, want it to report the attempts", err)
+	}
+}
+
+func TestRetryRunnerCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	stub := &stubRunner{err: stderrError("fatal: early EOF"), failures: 10}
+	r := NewRetryRunner(stub, RetryPolicy{Retries: 5, Delay: time.Hour}, func(Command, int, time.Duration, error) {
+		cancel()
+	})
+
+	start := time.Now()
+	if _, err := r.Run(ctx, Cmd("", "git", "clone")); err == nil {
+		t.Fatal("Run() expected error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run() took %s, want to stop waiting for the retry on cancellation", elapsed)
+	}
+	if stub.calls != 1 {
+		t.Errorf("Run() called the command %d times, want 1", stub.calls)
+	}
+}
+
+func TestRetryRunnerBeforeRetry(t *testing.T) {
+	stub := &stubRunner{err: stderrError("fatal: early EOF"), failures: 2}
+	var resets int
+	cmd := Cmd("", "git", "clone")
+	cmd.BeforeRetry = func() error {
+		resets++
+		return nil
+	}
+	if _, err := NewRetryRunner(stub, RetryPolicy{Retries: 3}, nil).Run(t.Context(), cmd); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if resets != 2 {
+		t.Errorf("Run() called BeforeRetry %d times, want 2", resets)
+	}
+
+	stub = &stubRunner{err: stderrError("fatal: early EOF"), failures: 2}
+	cmd.BeforeRetry = func() error { return errors.New("permission denied") }
+	if _, err := NewRetryRunner(stub, RetryPolicy{Retries: 3}, nil).Run(t.Context(), cmd); err == nil {
+		t.Error("Run() expected the BeforeRetry error, got nil")
+	}
+	if stub.calls != 1 {
+		t.Errorf("Run() called the command %d times, want 1 when BeforeRetry fails", stub.calls)
+	}
+}
@@ -1,34 +1,117 @@
 package runner
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
-// Runner is an interface for executing shell commands.
-// Implementations are expected to return combined stdout+stderr output.
+// waitDelay is the time to wait for the output of the killed command,
+// in case its child processes (e.g. git-remote-https) keep the output open
+const waitDelay = 5 * time.Second
+
+// Command is a program with its arguments, executed directly (without a shell),
+// so the arguments may contain spaces and are never split or expanded
+type Command struct {
+	Args   []string  // the program and its arguments, e.g. {"git", "clone", "-q", repo, dir}
+	Dir    string    // working dir, the current dir if empty
+	Env    []string  // additional environment variables (KEY=value), added to the current environment
+	Stdin  io.Reader // stdin, empty if nil
+	Stdout io.Writer // optional writer that receives a copy of the stdout
+	Stderr io.Writer // optional writer that receives a copy of the stderr
+
+	// BeforeRetry is an optional func called before each retry of the failed command (see RetryRunner),
+	// e.g. to remove the partially cloned repo, so the retry doesn't fail on the leftovers of the failed attempt
+	BeforeRetry func() error
+}
+
+// Cmd returns the command of the program with its arguments, executed in the dir
+func Cmd(dir string, args ...string) Command {
+	return Command{Args: args, Dir: dir}
+}
+
+// String returns the command line, used in logs and errors only
+func (c Command) String() string {
+	return strings.Join(c.Args, " ")
+}
+
+// Error is the error of the failed command, with its stderr
+type Error struct {
+	Command string // command line, see Command.String
+	Stderr  string // stderr of the command, without the trailing newline
+	Err     error  // the exec error, e.g. *exec.ExitError
+}
+
+func (e *Error) Error() string {
+	if e.Stderr == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + "\n" + e.Stderr
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Runner is an interface for executing commands.
+// Implementations are expected to return the stdout, and the stderr as part of the error (see Error),
+// and to stop the command when the ctx is done.
 type Runner interface {
-	Run(command, dir string) (string, error)
+	Run(ctx context.Context, cmd Command) (string, error)
 }
 
-// ShellRunner executes shell commands via os/exec.
-// It implements the Runner interface using the system shell.
-type ShellRunner struct{}
+// ShellRunner executes commands via os/exec.
+// It implements the Runner interface.
+type ShellRunner struct {
+	timeout time.Duration
+}
 
-// New creates a new ShellRunner
-func New() *ShellRunner {
-	return &ShellRunner{}
+// New creates a new ShellRunner, each command is killed after the timeout (0 - no timeout)
+func New(timeout time.Duration) *ShellRunner {
+	return &ShellRunner{timeout: timeout}
 }
 
-// Run executes a shell command in the given directory and returns combined output
-func (r *ShellRunner) Run(command, dir string) (string, error) {
-	slice := strings.Split(command, " ")
-	cmd := exec.Command(slice[0], slice[1:]...) //nolint:gosec // that's intended
-	cmd.Dir = dir
-	out, err := cmd.CombinedOutput()
-	if out == nil {
-		return "", err
+// Run executes the command and returns its stdout without the trailing newline.
+// If the command fails, the returned *Error contains its stderr.
+// The command is killed when the ctx is done or the runner's timeout is exceeded
+func (r *ShellRunner) Run(ctx context.Context, cmd Command) (string, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, r.timeout, fmt.Errorf("timed out after %s: %w", r.timeout, context.DeadlineExceeded))
+		defer cancel()
 	}
 
-	return strings.TrimSuffix(string(out), "\n"), err
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, cmd.Args[0], cmd.Args[1:]...) //nolint:gosec // that's intended
+	c.WaitDelay = waitDelay
+	c.Dir = cmd.Dir
+	if len(cmd.Env) > 0 {
+		c.Env = append(os.Environ(), cmd.Env...)
+	}
+	c.Stdin = cmd.Stdin
+	c.Stdout = tee(&stdout, cmd.Stdout)
+	c.Stderr = tee(&stderr, cmd.Stderr)
+
+	err := c.Run()
+	out := strings.TrimSuffix(stdout.String(), "\n")
+	if err != nil {
+		if ctx.Err() != nil { // report the cancellation or timeout instead of "signal: killed"
+			err = context.Cause(ctx)
+		}
+		return out, &Error{Command: cmd.String(), Stderr: strings.TrimSuffix(stderr.String(), "\n"), Err: err}
+	}
+	return out, nil
+}
+
+// tee returns the writer that writes to the buffer and the optional writer
+func tee(buf *bytes.Buffer, w io.Writer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, w)
 }
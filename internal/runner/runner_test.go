@@ -1,14 +1,18 @@
 package runner
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestShellRunnerRun(t *testing.T) {
-	r := New()
+	r := New(0)
 
 	t.Run("returns stdout output", func(t *testing.T) {
-		out, err := r.Run("echo hello", "")
+		out, err := r.Run(t.Context(), Cmd("", "echo", "hello"))
 		if err != nil {
 			t.Fatalf("Run() error = %v", err)
 		}
@@ -18,14 +22,14 @@ func TestShellRunnerRun(t *testing.T) {
 	})
 
 	t.Run("returns error for failing command", func(t *testing.T) {
-		_, err := r.Run("false", "")
+		_, err := r.Run(t.Context(), Cmd("", "false"))
 		if err == nil {
 			t.Error("Run() expected error for 'false' command, got nil")
 		}
 	})
 
 	t.Run("runs in specified directory", func(t *testing.T) {
-		out, err := r.Run("pwd", "/tmp")
+		out, err := r.Run(t.Context(), Cmd("/tmp", "pwd"))
 		if err != nil {
 			t.Fatalf("Run() error = %v", err)
 		}
@@ -35,7 +39,7 @@ func TestShellRunnerRun(t *testing.T) {
 	})
 
 	t.Run("trims trailing newline from output", func(t *testing.T) {
-		out, err := r.Run("printf hello", "")
+		out, err := r.Run(t.Context(), Cmd("", "printf", "hello"))
 		if err != nil {
 			t.Fatalf("Run() error = %v", err)
 		}
@@ -43,4 +47,73 @@ func TestShellRunnerRun(t *testing.T) {
 			t.Errorf("Run() = %q, want %q", out, "hello")
 		}
 	})
+
+	t.Run("passes arguments with spaces as is", func(t *testing.T) {
+		out, err := r.Run(t.Context(), Cmd("", "printf", "%s|", "a b", "c  d"))
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if out != "a b|c  d|" {
+			t.Errorf("Run() = %q, want %q", out, "a b|c  d|")
+		}
+	})
+
+	t.Run("returns stderr in error", func(t *testing.T) {
+		out, err := r.Run(t.Context(), Cmd("", "sh", "-c", "echo out; echo oops >&2; exit 1"))
+		var runErr *Error
+		if !errors.As(err, &runErr) {
+			t.Fatalf("Run() error = %v, want *Error", err)
+		}
+		if runErr.Stderr != "oops" {
+			t.Errorf("Run() stderr = %q, want %q", runErr.Stderr, "oops")
+		}
+		if !strings.Contains(err.Error(), "oops") {
+			t.Errorf("Run() error = %q, want it to contain the stderr", err)
+		}
+		if out != "out" {
+			t.Errorf("Run() = %q, want only the stdout %q", out, "out")
+		}
+	})
+
+	t.Run("passes env and stdin", func(t *testing.T) {
+		cmd := Cmd("", "sh", "-c", "printf %s \"$AGRU_TEST\"; cat")
+		cmd.Env = []string{"AGRU_TEST=env:"}
+		cmd.Stdin = strings.NewReader("stdin")
+		out, err := r.Run(t.Context(), cmd)
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if out != "env:stdin" {
+			t.Errorf("Run() = %q, want %q", out, "env:stdin")
+		}
+	})
+
+	t.Run("kills the command on cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		start := time.Now()
+		_, err := r.Run(ctx, Cmd("", "sleep", "10"))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Run() took %s, want the command killed", elapsed)
+		}
+	})
+}
+
+func TestShellRunnerRunTimeout(t *testing.T) {
+	r := New(100 * time.Millisecond)
+
+	_, err := r.Run(t.Context(), Cmd("", "sleep", "10"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Run() error = %q, want it to report the timeout", err)
+	}
+
+	if _, err := r.Run(t.Context(), Cmd("", "true")); err != nil {
+		t.Errorf("Run() error = %v, want the timeout to apply to each command", err)
+	}
 }
@@ -0,0 +1,271 @@
+// Package tarball creates and extracts tar archives in-process, without the tar binary,
+// and makes sure the extracted files stay inside the target dir.
+package tarball
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+
+	// ErrUnsafePath is returned for the entries that escape the target dir
+	ErrUnsafePath = errors.New("path escapes the target dir")
+)
+
+// Extract extracts the tar archive (optionally gzip or bzip2 compressed) into the dir,
+// dropping the first strip components of the paths, like tar --strip-components does.
+// Entries that escape the dir (absolute paths, .. components, links pointing outside of it, or paths through symlinks) are rejected,
+// setuid, setgid and sticky bits are dropped, and special files (devices, fifos) are skipped.
+// All rejected entries are reported in the returned error, one per file
+func Extract(archivePath, dir string, strip int) error {
+	f, err := os.Open(archivePath) //nolint:gosec // that's intended
+	if err != nil {
+		return fmt.Errorf("opening archive: %w", err)
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return fmt.Errorf("reading archive: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("creating dir: %w", err)
+	}
+
+	var errs []error
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading archive: %w", err))
+			break
+		}
+		if err := extractEntry(tr, hdr, dir, strip); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", hdr.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Create writes the tar archive with the names (files or dirs, recursively) from the dir, with paths relative to the dir
+func Create(archivePath, dir string, names ...string) error {
+	f, err := os.Create(archivePath) //nolint:gosec // that's intended
+	if err != nil {
+		return fmt.Errorf("creating archive: %w", err)
+	}
+	tw := tar.NewWriter(f)
+	for _, name := range names {
+		if err := filepath.WalkDir(filepath.Join(dir, name), func(filePath string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return addEntry(tw, dir, filePath)
+		}); err != nil {
+			f.Close()
+			return fmt.Errorf("archiving %s: %w", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	return f.Close()
+}
+
+// decompress returns the reader of the uncompressed archive, detecting the compression by the magic bytes
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(br), nil
+	default:
+		return br, nil
+	}
+}
+
+// extractEntry writes a single archive entry into the dir
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dir string, strip int) error {
+	name, err := stripPath(hdr.Name, strip)
+	if err != nil || name == "" {
+		return err
+	}
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if err := checkParents(dir, name); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, 0o700); err != nil {
+			return err
+		}
+		return os.Chmod(target, fs.FileMode(hdr.Mode).Perm()|0o700) //nolint:gosec // the mode is masked
+	case tar.TypeReg:
+		if err := prepare(target); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) //nolint:gosec // the path is checked
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil { //nolint:gosec // the roles are expected to be small
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return os.Chmod(target, fs.FileMode(hdr.Mode).Perm()) //nolint:gosec // the mode is masked
+	case tar.TypeSymlink:
+		if path.IsAbs(hdr.Linkname) || escapes(path.Join(path.Dir(name), hdr.Linkname)) {
+			return fmt.Errorf("symlink to %s: %w", hdr.Linkname, ErrUnsafePath)
+		}
+		if err := prepare(target); err != nil {
+			return err
+		}
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeLink:
+		linkname, err := stripPath(hdr.Linkname, strip)
+		if err != nil || linkname == "" {
+			return fmt.Errorf("hard link to %s: %w", hdr.Linkname, ErrUnsafePath)
+		}
+		if err := checkParents(dir, linkname); err != nil {
+			return err
+		}
+		if err := prepare(target); err != nil {
+			return err
+		}
+		return os.Link(filepath.Join(dir, filepath.FromSlash(linkname)), target)
+	default: // pax global headers (e.g. the commit id of git archive), devices, fifos
+		return nil
+	}
+}
+
+// stripPath returns the cleaned slash-separated path without the first strip components,
+// empty if nothing is left, or an error if the path escapes the dir
+func stripPath(name string, strip int) (string, error) {
+	if path.IsAbs(name) {
+		return "", ErrUnsafePath
+	}
+	name = path.Clean(name)
+	if escapes(name) {
+		return "", ErrUnsafePath
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) <= strip {
+		return "", nil
+	}
+	name = path.Join(parts[strip:]...)
+	if name == "." {
+		return "", nil
+	}
+	return name, nil
+}
+
+// escapes checks if the cleaned relative path points outside of the dir
+func escapes(name string) bool {
+	name = path.Clean(name)
+	return name == ".." || strings.HasPrefix(name, "../")
+}
+
+// checkParents checks that the parent dirs of the path inside the dir are not symlinks,
+// so the entry can't be written outside of the dir through a symlink extracted before
+func checkParents(dir, name string) error {
+	parent := dir
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("path through symlink %s: %w", part, ErrUnsafePath)
+		}
+	}
+	return nil
+}
+
+// prepare creates the parent dirs of the target and removes the existing non-dir target,
+// so the new file is written in place of it, not through it (if it's a symlink)
+func prepare(target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case info.IsDir():
+		return fmt.Errorf("%s is a dir", target)
+	default:
+		return os.Remove(target)
+	}
+}
+
+// addEntry writes the file (dir, regular file or symlink) into the archive, with the path relative to the dir
+func addEntry(tw *tar.Writer, dir, filePath string) error {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(filePath); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, filePath)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(rel)
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uname, hdr.Gname = "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(filePath) //nolint:gosec // that's intended
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
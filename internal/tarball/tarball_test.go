@@ -0,0 +1,160 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeArchive writes the tar archive with the headers (and the contents of regular files), gzip-compressed if compress is set
+func writeArchive(t *testing.T, compress bool, headers []*tar.Header, contents map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var tw *tar.Writer
+	var gzw *gzip.Writer
+	if compress {
+		gzw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gzw)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+	for _, hdr := range headers {
+		content := contents[hdr.Name]
+		hdr.Size = int64(len(content))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gzw != nil {
+		if err := gzw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtract(t *testing.T) {
+	for name, compress := range map[string]bool{"tar": false, "tar.gz": true} {
+		t.Run(name, func(t *testing.T) {
+			archivePath := writeArchive(t, compress, []*tar.Header{
+				{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": "abc"}},
+				{Typeflag: tar.TypeDir, Name: "role-1.0.0/", Mode: 0o755},
+				{Typeflag: tar.TypeDir, Name: "role-1.0.0/tasks/", Mode: 0o755},
+				{Typeflag: tar.TypeReg, Name: "role-1.0.0/tasks/main.yml", Mode: 0o644},
+				{Typeflag: tar.TypeReg, Name: "role-1.0.0/files/run.sh", Mode: 0o4755},
+				{Typeflag: tar.TypeSymlink, Name: "role-1.0.0/files/main.yml", Linkname: "../tasks/main.yml"},
+				{Typeflag: tar.TypeLink, Name: "role-1.0.0/tasks/copy.yml", Linkname: "role-1.0.0/tasks/main.yml"},
+				{Typeflag: tar.TypeFifo, Name: "role-1.0.0/fifo"},
+			}, map[string]string{
+				"role-1.0.0/tasks/main.yml": "---\n",
+				"role-1.0.0/files/run.sh":   "#!/bin/sh\n",
+			})
+			dir := filepath.Join(t.TempDir(), "role")
+			if err := Extract(archivePath, dir, 1); err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+
+			for _, name := range []string{"tasks/main.yml", "files/main.yml", "tasks/copy.yml"} {
+				if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != "---\n" {
+					t.Errorf("Extract() %s = %q, %v, want the extracted file", name, data, err)
+				}
+			}
+			info, err := os.Stat(filepath.Join(dir, "files", "run.sh"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode() != 0o755 {
+				t.Errorf("Extract() run.sh mode = %v, want 0755 without setuid", info.Mode())
+			}
+			for _, name := range []string{"fifo", "pax_global_header"} {
+				if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("Extract() should skip %s, got: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	outside := t.TempDir()
+	archivePath := writeArchive(t, false, []*tar.Header{
+		{Typeflag: tar.TypeReg, Name: "/etc/passwd", Mode: 0o644},
+		{Typeflag: tar.TypeReg, Name: "role/../../evil.yml", Mode: 0o644},
+		{Typeflag: tar.TypeSymlink, Name: "role/abs", Linkname: outside},
+		{Typeflag: tar.TypeSymlink, Name: "role/up", Linkname: "../.."},
+		{Typeflag: tar.TypeLink, Name: "role/hard", Linkname: "../evil.yml"},
+		{Typeflag: tar.TypeSymlink, Name: "role/inside", Linkname: "tasks"},
+		{Typeflag: tar.TypeReg, Name: "role/inside/main.yml", Mode: 0o644},
+		{Typeflag: tar.TypeReg, Name: "role/tasks/main.yml", Mode: 0o644},
+	}, nil)
+	dir := filepath.Join(t.TempDir(), "role")
+
+	err := Extract(archivePath, dir, 1)
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Fatalf("Extract() error = %v, want %v", err, ErrUnsafePath)
+	}
+	for _, name := range []string{"/etc/passwd", "role/../../evil.yml", "role/abs", "role/up", "role/hard", "role/inside/main.yml"} {
+		if !strings.Contains(err.Error(), name+": ") {
+			t.Errorf("Extract() error = %q, want it to report %s", err, name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tasks", "main.yml")); err != nil {
+		t.Errorf("Extract() should extract the safe entries: %v", err)
+	}
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) != 0 {
+		t.Errorf("Extract() wrote outside of the dir: %v, %v", entries, err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "roles", "role", "tasks"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "roles", "role", "tasks", "main.yml"), []byte("---\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("tasks/main.yml", filepath.Join(src, "roles", "role", "main.yml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "skipped.txt"), []byte("skipped\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	if err := Create(archivePath, src, "roles"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	dst := t.TempDir()
+	if err := Extract(archivePath, dst, 0); err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "roles", "role", "tasks", "main.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0o640 {
+		t.Errorf("Create() main.yml mode = %v, want 0640", info.Mode())
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "roles", "role", "main.yml")); err != nil || link != "tasks/main.yml" {
+		t.Errorf("Create() symlink = %q, %v, want tasks/main.yml", link, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "skipped.txt")); !os.IsNotExist(err) {
+		t.Errorf("Create() should archive only the names, got: %v", err)
+	}
+}
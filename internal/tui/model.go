@@ -2,10 +2,14 @@
 package tui
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"charm.land/bubbles/v2/spinner"
 	"charm.land/bubbles/v2/viewport"
@@ -20,12 +24,15 @@ import (
 type Config struct {
 	RequirementsPath string
 	RolesPath        string
+	CollectionsPath  string
 	DeleteName       string
 	Limit            int
 	ListInstalled    bool
 	InstallMissing   bool
 	UpdateFile       bool
+	Frozen           bool // install the commits from the lockfile, don't write the lockfile
 	Cleanup          bool
+	Atomic           bool // restore the replaced roles if any role fails
 	Verbose          bool
 	Keep             bool // keep the TUI open after completion until 'q'
 }
@@ -46,16 +53,22 @@ type roleItem struct {
 	name       string
 	version    string
 	oldVersion string
+	requiredBy string // set for dependencies, discovered during installation
 	status     string // "pending" | "active" | "done" | "skipped" | "error"
 	err        error
 }
 
 // checkRow holds a version-check result.
 type checkRow struct {
+	file   string // requirements file the role or collection came from
 	name   string
 	oldVer string
 	newVer string // empty = up to date
-	err    error
+	// heldBack is the newest version, not selected because of the update policy
+	heldBack string
+	branch   string // branch the installed role tracks, empty if not installed from a branch
+	commits  int    // new commits on the branch, -1 = unknown
+	err      error
 }
 
 // listRow holds an installed role entry.
@@ -64,11 +77,16 @@ type listRow struct {
 	version string
 }
 
+// LogMsg is the line for the log panel (shown in verbose mode) sent from outside the model
+// with tea.Program.Send, e.g. about a retried git command
+type LogMsg string
+
 // --- internal messages ---
 
 type parsedMsg struct {
 	entries     models.File
 	installOnly models.File
+	collections models.Collections
 	err         error
 }
 
@@ -76,6 +94,7 @@ type (
 	deletedMsg     struct{ err error }
 	checkDoneMsg   struct{}
 	installDoneMsg struct{}
+	lockWrittenMsg struct{ err error }
 )
 
 // --- channel-wait commands ---
@@ -103,6 +122,8 @@ func waitForInstall(ch <-chan installer.Progress) tea.Cmd {
 // Model is the Bubble Tea model for agru's TUI.
 type Model struct {
 	cfg     Config
+	ctx     context.Context //nolint:containedctx // Bubble Tea's Update has no ctx, it stops the checks and installs
+	wg      sync.WaitGroup  // running checks and installs
 	parser  *parser.Parser
 	inst    *installer.Installer
 	state   appState
@@ -112,6 +133,7 @@ type Model struct {
 	// shared after parse
 	entries     models.File // updated in place by checkVersions
 	installOnly models.File
+	collections models.Collections // updated in place by checkVersions
 
 	// check phase (-u)
 	checkRows  []checkRow
@@ -141,13 +163,14 @@ type Model struct {
 	width, height int
 }
 
-// New creates a new TUI model.
-func New(cfg Config, p *parser.Parser, inst *installer.Installer) *Model {
+// New creates a new TUI model, the checks and installs are stopped when the ctx is done.
+func New(ctx context.Context, cfg Config, p *parser.Parser, inst *installer.Installer) *Model {
 	sp := spinner.New(spinner.WithSpinner(spinner.MiniDot))
 	sp.Style = styleCyan
 
 	return &Model{
 		cfg:     cfg,
+		ctx:     ctx,
 		parser:  p,
 		inst:    inst,
 		state:   stateInit,
@@ -163,7 +186,11 @@ func (m *Model) Init() tea.Cmd {
 		m.spinner.Tick,
 		func() tea.Msg {
 			entries, installOnly, err := m.parser.ParseFile(m.cfg.RequirementsPath)
-			return parsedMsg{entries: entries, installOnly: installOnly, err: err}
+			if err != nil {
+				return parsedMsg{err: err}
+			}
+			collections, err := m.parser.ParseCollections(m.cfg.RequirementsPath)
+			return parsedMsg{entries: entries, installOnly: installOnly, collections: collections, err: err}
 		},
 	)
 }
@@ -179,7 +206,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case tea.KeyPressMsg:
-		if msg.Code == tea.KeyEscape || msg.Text == "q" || msg.Text == "Q" {
+		if msg.Code == tea.KeyEscape || msg.Text == "q" || msg.Text == "Q" || msg.String() == "ctrl+c" {
 			return m, tea.Quit
 		}
 		if m.cfg.Verbose || m.state == stateList {
@@ -207,16 +234,22 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case parser.CheckProgress:
 		m.checkRows = append(m.checkRows, checkRow{
-			name:   msg.Name,
-			oldVer: msg.OldVer,
-			newVer: msg.NewVer,
-			err:    msg.Err,
+			file:     msg.File,
+			name:     msg.Name,
+			oldVer:   msg.OldVer,
+			newVer:   msg.NewVer,
+			heldBack: msg.HeldBack,
+			branch:   msg.Branch,
+			commits:  msg.Commits,
+			err:      msg.Err,
 		})
 		return m, waitForCheck(m.checkCh)
 
 	case checkDoneMsg:
-		if !m.cfg.InstallMissing {
-			return m.quitOrKeep()
+		if !m.cfg.InstallMissing { // the updated requirements file has to match the lockfile
+			return m, func() tea.Msg {
+				return lockWrittenMsg{err: m.writeLock()}
+			}
 		}
 		merged := m.parser.MergeFiles(m.entries, m.installOnly)
 		return m.startInstall(merged)
@@ -224,10 +257,30 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case installer.Progress:
 		return m.handleInstallProgress(&msg)
 
+	case LogMsg:
+		m.appendLog(string(msg))
+		return m, nil
+
+	case lockWrittenMsg:
+		if msg.err != nil {
+			m.state = stateError
+			m.err = msg.err
+			return m, nil
+		}
+		return m.quitOrKeep()
+
 	case installDoneMsg:
 		if len(m.instErrs) > 0 {
 			m.state = stateError
 			m.err = fmt.Errorf("%s", strings.Join(m.instErrs, "\n"))
+			if m.cfg.Atomic {
+				m.err = fmt.Errorf("%w\n\nthe roles replaced during the run are restored to their previous versions", m.err)
+			}
+			return m, nil
+		}
+		if err := m.writeLock(); err != nil {
+			m.state = stateError
+			m.err = err
 			return m, nil
 		}
 		return m.quitOrKeep()
@@ -246,6 +299,7 @@ func (m *Model) handleParsed(msg parsedMsg) (tea.Model, tea.Cmd) {
 
 	m.entries = msg.entries
 	m.installOnly = msg.installOnly
+	m.collections = msg.collections
 	merged := m.parser.MergeFiles(msg.entries, msg.installOnly)
 
 	if m.cfg.ListInstalled {
@@ -261,19 +315,71 @@ func (m *Model) handleParsed(msg parsedMsg) (tea.Model, tea.Cmd) {
 	if m.cfg.UpdateFile {
 		ch := make(chan parser.CheckProgress, 64)
 		m.checkCh = ch
-		m.checkTotal = msg.entries.RolesLen()
+		m.checkTotal = msg.entries.RolesLen() + msg.installOnly.RolesLen() + len(msg.collections)
 		m.state = stateChecking
-		go m.parser.UpdateFile(msg.entries, m.cfg.RequirementsPath, ch) //nolint:errcheck // errors delivered via channel
+		all := append(slices.Clone(msg.entries), msg.installOnly...) // included files are updated too
+		m.wg.Go(func() {
+			m.parser.UpdateFile(m.ctx, all, msg.collections, m.cfg.RequirementsPath, ch) //nolint:errcheck // errors delivered via channel
+		})
 		return m, waitForCheck(ch)
 	}
 
 	if m.cfg.InstallMissing {
+		if err := m.applyLock(merged); err != nil {
+			m.state = stateError
+			m.err = err
+			return m, nil
+		}
 		return m.startInstall(merged)
 	}
 
 	return m, tea.Quit
 }
 
+// applyLock sets the locked commits of the roles in frozen mode,
+// and fails if the requirements file and the lockfile disagree
+func (m *Model) applyLock(merged models.File) error {
+	if !m.cfg.Frozen {
+		return nil
+	}
+	lockPath := models.LockPath(m.cfg.RequirementsPath)
+	lock, err := models.ReadLock(lockPath)
+	if err != nil {
+		return err
+	}
+	if err := lock.Apply(merged); err != nil {
+		return fmt.Errorf("%s doesn't match %s:\n%w", m.cfg.RequirementsPath, lockPath, err)
+	}
+	m.inst.SetLock(lock) // the dependencies are checked when they are discovered
+	return nil
+}
+
+// writeLock writes the lockfile with the commits of the installed roles and their dependencies, unless in frozen mode.
+// The commits of the roles that are not installed yet (e.g. updated by -u with -i=false) are resolved in their remote repos.
+// If the dependencies were not resolved (e.g. nothing was installed), the ones from the previous lockfile are kept
+func (m *Model) writeLock() error {
+	if m.cfg.Frozen {
+		return nil
+	}
+	lockPath := models.LockPath(m.cfg.RequirementsPath)
+	merged := m.parser.MergeFiles(m.entries, m.installOnly)
+	lock, err := models.NewLock(merged, m.inst.FS())
+	if err != nil {
+		return err
+	}
+	if err := m.inst.LockCommits(m.ctx, merged, lock); err != nil {
+		return err
+	}
+	if deps := m.inst.Dependencies(); deps != nil {
+		if err := lock.AddDependencies(deps, m.inst.FS()); err != nil {
+			return err
+		}
+	} else if previous, err := models.ReadLock(lockPath); err == nil {
+		lock.KeepDependencies(previous)
+	}
+	return lock.Write(lockPath)
+}
+
 // handleListMode populates the list view with installed roles.
 func (m *Model) handleListMode(merged models.File) (tea.Model, tea.Cmd) {
 	installed := m.inst.GetInstalled(merged)
@@ -311,6 +417,13 @@ func (m *Model) startInstall(merged models.File) (tea.Model, tea.Cmd) {
 			status:  "pending",
 		})
 	}
+	for _, c := range m.collections {
+		m.roleItems = append(m.roleItems, roleItem{
+			name:    c.GetName(),
+			version: c.Version,
+			status:  "pending",
+		})
+	}
 
 	if len(m.roleItems) == 0 {
 		return m, tea.Quit // nothing to install
@@ -318,48 +431,82 @@ func (m *Model) startInstall(merged models.File) (tea.Model, tea.Cmd) {
 
 	if m.cfg.Verbose {
 		m.vp = viewport.New(viewport.WithWidth(m.vpWidth()), viewport.WithHeight(m.vpHeight()))
+		m.vp.SetContent(strings.Join(m.logLines, "\n"))
+		m.vp.GotoBottom()
 	}
 
 	ch := make(chan installer.Progress, 64)
 	m.installCh = ch
-	go m.inst.InstallMissing(merged, ch) //nolint:errcheck // errors delivered via channel
+	m.wg.Go(func() {
+		m.inst.InstallMissing(m.ctx, merged, m.collections, ch) //nolint:errcheck // errors delivered via channel
+	})
 	return m, waitForInstall(ch)
 }
 
+// Wait waits until the checks and installs, that are still running after the program quit, are finished
+// (stopped by the ctx passed to New), discarding their progress events
+func (m *Model) Wait() {
+	if m.checkCh != nil {
+		for range m.checkCh {
+		}
+	}
+	if m.installCh != nil {
+		for range m.installCh {
+		}
+	}
+	m.wg.Wait()
+}
+
 // handleInstallProgress updates a role item from an install progress message.
 func (m *Model) handleInstallProgress(msg *installer.Progress) (tea.Model, tea.Cmd) {
+	idx := slices.IndexFunc(m.roleItems, func(item roleItem) bool { return item.name == msg.Name })
+	if idx == -1 { // dependency, discovered during installation
+		m.roleItems = append(m.roleItems, roleItem{name: msg.Name, requiredBy: msg.RequiredBy, status: "pending"})
+		idx = len(m.roleItems) - 1
+	}
+
 	switch msg.Status {
 	case "active":
 		m.instActive++
 	case "done", "skipped":
 		m.instDone++
 	case "error":
-		m.instDone++
+		switch m.roleItems[idx].status {
+		case "done": // restoring the previous version failed in atomic mode, the role is already counted
+		case "active":
+			m.instDone++
+		default: // dependency errors are reported without starting the installation
+			m.instActive++
+			m.instDone++
+		}
 		m.instErrs = append(m.instErrs, fmt.Sprintf("%s: %v", msg.Name, msg.Err))
 	}
 
-	for i, item := range m.roleItems {
-		if item.name != msg.Name {
-			continue
-		}
-		m.roleItems[i].status = msg.Status
-		if msg.Version != "" {
-			m.roleItems[i].version = msg.Version
-		}
-		m.roleItems[i].oldVersion = msg.OldVersion
-		m.roleItems[i].err = msg.Err
-		break
+	m.roleItems[idx].status = msg.Status
+	if msg.Version != "" {
+		m.roleItems[idx].version = msg.Version
 	}
+	m.roleItems[idx].oldVersion = msg.OldVersion
+	m.roleItems[idx].err = msg.Err
 
-	if m.cfg.Verbose && msg.Log != "" {
-		m.logLines = append(m.logLines, msg.Log)
-		m.vp.SetContent(strings.Join(m.logLines, "\n"))
-		m.vp.GotoBottom()
-	}
+	m.appendLog(msg.Log)
 
 	return m, waitForInstall(m.installCh)
 }
 
+// appendLog adds the line to the log panel in verbose mode
+func (m *Model) appendLog(line string) {
+	if !m.cfg.Verbose || line == "" {
+		return
+	}
+	if m.vp.Height() == 0 { // the log panel is created before the install phase, e.g. for a retried check
+		m.vp = viewport.New(viewport.WithWidth(m.vpWidth()), viewport.WithHeight(m.vpHeight()))
+	}
+	m.logLines = append(m.logLines, line)
+	m.vp.SetContent(strings.Join(m.logLines, "\n"))
+	m.vp.GotoBottom()
+}
+
 // View renders the current state.
 func (m *Model) View() tea.View {
 	return tea.View{
@@ -441,16 +588,62 @@ func (m *Model) renderCheckSection(maxRows int) string {
 		hdr = styleGreen.Render("✓") + " Phase 1: Checking versions  " + counter
 	}
 	sb.WriteString(styleBoldCol.Render(hdr) + "\n")
-	rows := m.checkRows
-	if maxRows > 0 && len(rows) > maxRows {
-		rows = rows[len(rows)-maxRows:] // tail: always show newest entries
+	lines := m.checkLines()
+	if maxRows > 0 && len(lines) > maxRows {
+		lines = lines[len(lines)-maxRows:] // tail: always show newest entries
 	}
-	for _, row := range rows {
-		sb.WriteString(m.renderCheckRow(row) + "\n")
+	for _, line := range lines {
+		sb.WriteString(line + "\n")
 	}
 	return sb.String()
 }
 
+// checkLines renders the version-check rows grouped by the requirements file they came from,
+// the main requirements file first, followed by the versions held back by the update policy.
+// File headers are shown only when there is more than one file
+func (m *Model) checkLines() []string {
+	files := []string{m.cfg.RequirementsPath}
+	groups := map[string][]checkRow{}
+	for _, row := range m.checkRows {
+		if _, ok := groups[row.file]; !ok && row.file != m.cfg.RequirementsPath {
+			files = append(files, row.file)
+		}
+		groups[row.file] = append(groups[row.file], row)
+	}
+
+	lines := make([]string, 0, len(m.checkRows)+len(files))
+	for _, file := range files {
+		rows := groups[file]
+		if len(rows) == 0 {
+			continue
+		}
+		if len(files) > 1 {
+			lines = append(lines, " "+styleBoldDim.Render(displayPath(file)))
+		}
+		for _, row := range rows {
+			lines = append(lines, m.renderCheckRow(row))
+		}
+	}
+
+	header := false
+	for _, row := range m.checkRows {
+		if row.heldBack == "" {
+			continue
+		}
+		if !header {
+			lines = append(lines, " "+styleBoldDim.Render("Held back by update policy"))
+			header = true
+		}
+		current := row.oldVer
+		if row.newVer != "" {
+			current = row.newVer
+		}
+		lines = append(lines, "  "+styleYellow.Render("!")+"  "+row.name+"  "+
+			styleDim.Render(current)+styleYellow.Render(" ⇢ ")+styleYellow.Render(row.heldBack))
+	}
+	return lines
+}
+
 // renderInstallSection renders Phase 2 (role installs), showing only the last maxRows visible entries.
 func (m *Model) renderInstallSection(_, maxRows int) string {
 	var sb strings.Builder
@@ -496,11 +689,31 @@ func (m *Model) renderCheckRow(row checkRow) string {
 		return "  " + styleGreen.Render("✓") + "  " + row.name + "  " +
 			styleDim.Render(row.oldVer) + styleYellow.Render(" → ") + styleGreen.Render(row.newVer)
 	}
+	if row.branch != "" && row.commits != 0 {
+		commits := "new commits"
+		switch {
+		case row.commits == 1:
+			commits = "1 new commit"
+		case row.commits > 1:
+			commits = fmt.Sprintf("%d new commits", row.commits)
+		}
+		return "  " + styleGreen.Render("✓") + "  " + row.name + "  " +
+			styleGreen.Render(commits) + styleDim.Render(" on branch "+row.branch)
+	}
 	return "  " + styleDim.Render("–") + "  " + styleDim.Render(row.name+"  "+row.oldVer+"  (up to date)")
 }
 
 // renderRoleItem renders a single install-progress row.
 func (m *Model) renderRoleItem(item *roleItem) string {
+	row := m.renderRoleStatus(item)
+	if item.requiredBy != "" {
+		row += styleDim.Render("  (required by " + item.requiredBy + ")")
+	}
+	return row
+}
+
+// renderRoleStatus renders the install-progress row of a single role, depending on its status.
+func (m *Model) renderRoleStatus(item *roleItem) string {
 	ico := icon(item.status)
 	switch item.status {
 	case "active":
@@ -612,6 +825,19 @@ func (m *Model) vpHeight() int {
 	return h
 }
 
+// displayPath returns the path relative to the working dir, if it's inside it, or the path as-is
+func displayPath(file string) string {
+	wd, err := os.Getwd()
+	if err != nil || !filepath.IsAbs(file) {
+		return file
+	}
+	rel, err := filepath.Rel(wd, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return file
+	}
+	return rel
+}
+
 // padRight right-pads s to width using spaces.
 func padRight(s string, width int) string {
 	if len(s) >= width {
@@ -0,0 +1,70 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+
+	"github.com/etkecc/agru/internal/installer"
+	"github.com/etkecc/agru/internal/models"
+	"github.com/etkecc/agru/internal/parser"
+	"github.com/etkecc/agru/internal/runner"
+	"github.com/etkecc/agru/internal/versions"
+)
+
+// lsRemoteRunner returns the preset outputs of the commands, and fails the other ones
+type lsRemoteRunner map[string]string
+
+func (r lsRemoteRunner) Run(_ context.Context, cmd runner.Command) (string, error) {
+	if out, ok := r[cmd.String()]; ok {
+		return out, nil
+	}
+	return "", fmt.Errorf("unexpected command %q", cmd.String())
+}
+
+func TestUpdateOnlyWritesLock(t *testing.T) {
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles")
+	if err := os.MkdirAll(filepath.Join(rolesPath, "my-role", "meta"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	info := "install_commit: abc123\ninstall_ref_type: tag\nversion: v1.0.0\n"
+	if err := os.WriteFile(filepath.Join(rolesPath, "my-role", "meta", ".galaxy_install_info"), []byte(info), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := lsRemoteRunner{
+		"git ls-remote -q https://github.com/org/my-role.git refs/heads/v2.0.0 refs/tags/v2.0.0 refs/tags/v2.0.0^{}": "def456\trefs/tags/v2.0.0",
+	}
+	cfg := Config{RequirementsPath: filepath.Join(dir, "requirements.yml"), RolesPath: rolesPath, UpdateFile: true}
+	m := New(t.Context(), cfg, parser.New(r, nil, os.DirFS(rolesPath), versions.Policy{}, 0, nil),
+		installer.New(r, nil, rolesPath, "", 0, nil, true, false, false, nil, ""))
+	// -u has updated the role's version, but it's not installed with -i=false
+	m.entries = models.File{{Name: "my-role", Src: "git+https://github.com/org/my-role.git", Version: "v2.0.0"}}
+
+	_, cmd := m.Update(checkDoneMsg{})
+	if cmd == nil {
+		t.Fatal("Update() cmd = nil, want the lockfile to be written")
+	}
+	if _, cmd = m.Update(cmd()); m.err != nil {
+		t.Fatalf("Update() error = %v", m.err)
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Error("Update() should quit after the lockfile is written")
+	}
+
+	lock, err := models.ReadLock(models.LockPath(cfg.RequirementsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := models.File{{Name: "my-role", Src: "git+https://github.com/org/my-role.git", Version: "v2.0.0"}}
+	if err := lock.Apply(entries); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if commit, refType := entries[0].Locked(); commit != "def456" || refType != models.RefTag {
+		t.Errorf("Locked() = %q, %q, want the commit of the updated version", commit, refType)
+	}
+}
@@ -0,0 +1,202 @@
+package versions
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SchemeLoose compares any versions that start with a number, the same way git's version:refname sort does, default
+	SchemeLoose = "loose"
+	// SchemeSemver compares semantic versions (https://semver.org), e.g. v1.2.3 or 1.2.3-rc.1
+	SchemeSemver = "semver"
+	// SchemeCalver compares calendar versions, e.g. 2024.05.01 or 2024-05
+	SchemeCalver = "calver"
+	// SchemeMDAD compares versions with numeric packaging revision, e.g. v1.2.3-0, as used by MDAD-like playbooks
+	SchemeMDAD = "mdad"
+)
+
+var (
+	looseRegex  = regexp.MustCompile(`^v?[0-9]`)
+	semverRegex = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+	calverRegex = regexp.MustCompile(`^v?([0-9]{4})(?:[.-]([0-9]{1,2}))?(?:[.-]([0-9]{1,2}))?(?:[._-]([0-9]+))?$`)
+	mdadRegex   = regexp.MustCompile(`^v?([0-9]+)\.([0-9]+)\.([0-9]+)(?:-([0-9]+))?$`)
+
+	schemes = map[string]Scheme{
+		"":           looseScheme{},
+		SchemeLoose:  looseScheme{},
+		SchemeSemver: semverScheme{},
+		SchemeCalver: calverScheme{},
+		SchemeMDAD:   mdadScheme{},
+	}
+)
+
+// Scheme parses and compares versions of a specific format
+type Scheme interface {
+	// Valid checks if the version is in the scheme's format, invalid versions (e.g. "latest" tags) are ignored
+	Valid(version string) bool
+	// Compare returns -1 if a < b, 0 if a == b, and 1 if a > b
+	Compare(a, b string) int
+	// Prerelease checks if the version is a pre-release
+	Prerelease(version string) bool
+	// Parts returns the version's release parts, from the most significant one, e.g. major, minor, patch
+	Parts(version string) []string
+}
+
+// SchemeByName returns the version scheme by its name, empty name is the loose scheme
+func SchemeByName(name string) (Scheme, error) {
+	scheme, ok := schemes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown version scheme %q, expected %s, %s, %s, or %s", name, SchemeLoose, SchemeSemver, SchemeCalver, SchemeMDAD)
+	}
+	return scheme, nil
+}
+
+// looseScheme is the git's version:refname-like scheme, see Compare
+type looseScheme struct{}
+
+func (looseScheme) Valid(version string) bool      { return looseRegex.MatchString(version) }
+func (looseScheme) Compare(a, b string) int        { return Compare(a, b) }
+func (looseScheme) Prerelease(version string) bool { return IsPrerelease(version) }
+func (looseScheme) Parts(version string) []string  { return parts(version) }
+
+// semverScheme is the semantic versioning 2.0.0 scheme
+type semverScheme struct{}
+
+func (semverScheme) Valid(version string) bool { return semverRegex.MatchString(version) }
+
+func (semverScheme) Compare(a, b string) int {
+	ma, mb := semverRegex.FindStringSubmatch(a), semverRegex.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return Compare(a, b)
+	}
+	if c := compareNumbers(ma[1:4], mb[1:4]); c != 0 {
+		return c
+	}
+	return comparePrerelease(ma[4], mb[4])
+}
+
+func (semverScheme) Prerelease(version string) bool {
+	match := semverRegex.FindStringSubmatch(version)
+	return match != nil && match[4] != ""
+}
+
+func (semverScheme) Parts(version string) []string {
+	if match := semverRegex.FindStringSubmatch(version); match != nil {
+		return match[1:4]
+	}
+	return parts(version)
+}
+
+// calverScheme is the calendar versioning scheme: year, optional month, day, and micro
+type calverScheme struct{}
+
+func (calverScheme) Valid(version string) bool { return calverRegex.MatchString(version) }
+
+func (calverScheme) Compare(a, b string) int {
+	ma, mb := calverRegex.FindStringSubmatch(a), calverRegex.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return Compare(a, b)
+	}
+	return compareNumbers(ma[1:], mb[1:])
+}
+
+func (calverScheme) Prerelease(string) bool { return false }
+
+func (calverScheme) Parts(version string) []string {
+	if match := calverRegex.FindStringSubmatch(version); match != nil {
+		return match[1:4]
+	}
+	return parts(version)
+}
+
+// mdadScheme is the vX.Y.Z-N scheme, where N is the packaging revision of the upstream X.Y.Z version
+type mdadScheme struct{}
+
+func (mdadScheme) Valid(version string) bool { return mdadRegex.MatchString(version) }
+
+func (mdadScheme) Compare(a, b string) int {
+	ma, mb := mdadRegex.FindStringSubmatch(a), mdadRegex.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return Compare(a, b)
+	}
+	if c := compareNumbers(ma[1:4], mb[1:4]); c != 0 {
+		return c
+	}
+	switch { // no revision is older than any revision
+	case ma[4] == mb[4]:
+		return 0
+	case ma[4] == "":
+		return -1
+	case mb[4] == "":
+		return 1
+	default:
+		return compareChunk(ma[4], mb[4])
+	}
+}
+
+func (mdadScheme) Prerelease(string) bool { return false }
+
+func (mdadScheme) Parts(version string) []string {
+	if match := mdadRegex.FindStringSubmatch(version); match != nil {
+		return match[1:4]
+	}
+	return parts(version)
+}
+
+// compareNumbers compares the lists of numbers, missing (empty) numbers are treated as 0
+func compareNumbers(a, b []string) int {
+	for idx := 0; idx < len(a) && idx < len(b); idx++ {
+		if c := compareChunk(part(a, idx), part(b, idx)); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// comparePrerelease compares semver pre-release suffixes: a version without pre-release is higher,
+// dot-separated identifiers are compared numerically if both are numbers, lexically otherwise, numbers are lower
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	ia, ib := strings.Split(a, "."), strings.Split(b, ".")
+	for idx := 0; idx < len(ia) && idx < len(ib); idx++ {
+		na, erra := strconv.ParseUint(ia[idx], 10, 64)
+		nb, errb := strconv.ParseUint(ib[idx], 10, 64)
+		var c int
+		switch {
+		case erra == nil && errb == nil:
+			c = compareUint(na, nb)
+		case erra == nil:
+			c = -1
+		case errb == nil:
+			c = 1
+		default:
+			c = strings.Compare(ia[idx], ib[idx])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(ia)), uint64(len(ib)))
+}
+
+// compareUint compares two numbers
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,75 @@
+package versions
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSchemeCompare(t *testing.T) {
+	tests := []struct {
+		scheme   string
+		a, b     string
+		expected int
+	}{
+		{SchemeSemver, "v1.2.3", "1.2.3", 0},
+		{SchemeSemver, "1.10.0", "1.9.0", 1},
+		{SchemeSemver, "1.0.0-rc.1", "1.0.0", -1},
+		{SchemeSemver, "1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{SchemeSemver, "1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{SchemeSemver, "1.0.0-1", "1.0.0-alpha", -1},
+		{SchemeSemver, "1.0.0+build.2", "1.0.0+build.1", 0},
+		{SchemeCalver, "2024.05.01", "2024.5.1", 0},
+		{SchemeCalver, "2024.10", "2024.09.30", 1},
+		{SchemeCalver, "2024-05-01", "2023.12.31", 1},
+		{SchemeMDAD, "v1.2.3-1", "v1.2.3-0", 1},
+		{SchemeMDAD, "v1.2.3", "v1.2.3-0", -1},
+		{SchemeMDAD, "v1.2.10-0", "v1.2.9-5", 1},
+		{SchemeLoose, "1.10", "1.9", 1},
+	}
+	for _, tt := range tests {
+		scheme, err := SchemeByName(tt.scheme)
+		if err != nil {
+			t.Fatalf("SchemeByName(%q) error = %v", tt.scheme, err)
+		}
+		if got := scheme.Compare(tt.a, tt.b); got != tt.expected {
+			t.Errorf("%s.Compare(%q, %q) = %d, want %d", tt.scheme, tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestSchemeValid(t *testing.T) {
+	tests := []struct {
+		scheme   string
+		version  string
+		expected bool
+	}{
+		{SchemeLoose, "v1.2", true},
+		{SchemeLoose, "latest", false},
+		{SchemeLoose, "test-foo", false},
+		{SchemeSemver, "1.2.3-rc.1", true},
+		{SchemeSemver, "1.2", false},
+		{SchemeCalver, "2024.05.01", true},
+		{SchemeCalver, "1.2.3", false},
+		{SchemeMDAD, "v1.2.3-0", true},
+		{SchemeMDAD, "v1.2.3-rc1", false},
+	}
+	for _, tt := range tests {
+		scheme, _ := SchemeByName(tt.scheme) //nolint:errcheck // known scheme
+		if got := scheme.Valid(tt.version); got != tt.expected {
+			t.Errorf("%s.Valid(%q) = %v, want %v", tt.scheme, tt.version, got, tt.expected)
+		}
+	}
+	if _, err := SchemeByName("pep440"); err == nil {
+		t.Error("SchemeByName(pep440) expected error, got nil")
+	}
+}
+
+func TestPolicySelectTagPattern(t *testing.T) {
+	available := []string{"latest", "test-foo", "release-1.4", "release-1.10", "release-2.0-rc1", "v9.9.9"}
+	policy := Policy{TagPattern: regexp.MustCompile(`^release-(.+)$`), Update: UpdateMinor}
+
+	selected, heldBack := policy.Select("release-1.4", available)
+	if selected != "release-1.10" || heldBack != "" {
+		t.Errorf("Select() = %q, %q, want release-1.10 and nothing held back", selected, heldBack)
+	}
+}
@@ -0,0 +1,280 @@
+// Package versions compares role and collection version strings.
+package versions
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// UpdateMajor allows updates to any newer version, default
+	UpdateMajor = "major"
+	// UpdateMinor allows updates within the same major version
+	UpdateMinor = "minor"
+	// UpdatePatch allows updates within the same major and minor version
+	UpdatePatch = "patch"
+)
+
+// updateLevels maps the update policy to the number of leading version parts that must stay the same
+var updateLevels = map[string]int{
+	"":          0,
+	UpdateMajor: 0,
+	UpdateMinor: 1,
+	UpdatePatch: 2,
+}
+
+// Policy limits the versions a role or collection can be updated to
+type Policy struct {
+	Update          string // UpdateMajor (default), UpdateMinor or UpdatePatch
+	AllowPrerelease bool   // allow updates to pre-release versions
+	Pin             bool   // never update
+	// Scheme is used to parse and compare versions, the loose scheme if nil
+	Scheme Scheme
+	// TagPattern filters the candidate tags (if set). If it has a capturing group,
+	// the group's match is used as the version, e.g. ^release-(.+)$
+	TagPattern *regexp.Regexp
+}
+
+// Validate checks if the policy's update level is known
+func (p Policy) Validate() error {
+	if _, ok := updateLevels[p.Update]; !ok {
+		return fmt.Errorf("unknown update policy %q, expected %s, %s, or %s", p.Update, UpdateMajor, UpdateMinor, UpdatePatch)
+	}
+	return nil
+}
+
+// Allows checks if the policy allows updating from the current version to the candidate version.
+// Any version is allowed when the current version is unknown (empty)
+func (p Policy) Allows(current, candidate string) bool {
+	if p.Pin {
+		return false
+	}
+	scheme := p.scheme()
+	current, candidate = p.version(current), p.version(candidate)
+	if !p.AllowPrerelease && scheme.Prerelease(candidate) {
+		return false
+	}
+	if current == "" {
+		return true
+	}
+	currentParts, candidateParts := scheme.Parts(current), scheme.Parts(candidate)
+	for idx := 0; idx < updateLevels[p.Update]; idx++ {
+		if compareChunk(part(currentParts, idx), part(candidateParts, idx)) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the newest available version that is newer than the current one and is allowed by the policy,
+// and the newest available version that was held back by the policy's update level (only if it's newer than the selected one).
+// Both are empty if there is no such version
+func (p Policy) Select(current string, available []string) (selected, heldBack string) {
+	if p.Pin {
+		return "", ""
+	}
+	scheme := p.scheme()
+	allowed := make([]string, 0, len(available))
+	held := make([]string, 0)
+	for _, tag := range available {
+		version, ok := p.Match(tag)
+		if !ok || scheme.Compare(version, p.version(current)) <= 0 || (!p.AllowPrerelease && scheme.Prerelease(version)) {
+			continue
+		}
+		if p.Allows(current, tag) {
+			allowed = append(allowed, tag)
+		} else {
+			held = append(held, tag)
+		}
+	}
+	selected, heldBack = p.latest(allowed), p.latest(held)
+	if selected != "" && heldBack != "" && scheme.Compare(p.version(heldBack), p.version(selected)) <= 0 {
+		heldBack = ""
+	}
+	return selected, heldBack
+}
+
+// Match checks if the tag matches the tag pattern (if set) and is a valid version of the policy's scheme,
+// and returns the version from the tag
+func (p Policy) Match(tag string) (string, bool) {
+	if p.TagPattern != nil && !p.TagPattern.MatchString(tag) {
+		return "", false
+	}
+	version := p.version(tag)
+	return version, p.scheme().Valid(version)
+}
+
+// version returns the version from the tag, i.e. the tag pattern's first group match, or the tag itself
+func (p Policy) version(tag string) string {
+	if p.TagPattern == nil || p.TagPattern.NumSubexp() == 0 {
+		return tag
+	}
+	if match := p.TagPattern.FindStringSubmatch(tag); match != nil {
+		return match[1]
+	}
+	return tag
+}
+
+// latest returns the tag with the highest version, or an empty string if the list is empty
+func (p Policy) latest(tags []string) string {
+	scheme := p.scheme()
+	var latest string
+	for _, tag := range tags {
+		if latest == "" || scheme.Compare(p.version(tag), p.version(latest)) > 0 {
+			latest = tag
+		}
+	}
+	return latest
+}
+
+// scheme returns the policy's version scheme, the loose scheme by default
+func (p Policy) scheme() Scheme {
+	if p.Scheme == nil {
+		return looseScheme{}
+	}
+	return p.Scheme
+}
+
+// Compare compares two version strings the same way git's version:refname sort does:
+// an optional "v" prefix is ignored, digit runs are compared numerically and everything else lexically.
+// Returns -1 if a < b, 0 if a == b, and 1 if a > b.
+func Compare(a, b string) int {
+	ca := chunks(strings.TrimPrefix(a, "v"))
+	cb := chunks(strings.TrimPrefix(b, "v"))
+	for idx := 0; idx < len(ca) && idx < len(cb); idx++ {
+		if c := compareChunk(ca[idx], cb[idx]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(ca) < len(cb):
+		return -1
+	case len(ca) > len(cb):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Latest returns the highest version from the list, or an empty string if the list is empty
+func Latest(list []string) string {
+	var latest string
+	for _, version := range list {
+		if latest == "" || Compare(version, latest) > 0 {
+			latest = version
+		}
+	}
+	return latest
+}
+
+// IsPrerelease checks if the version is a pre-release, e.g. 1.0.0-rc.1 or v2.0.0-beta.
+// Numeric-only suffixes (e.g. v1.2.3-0) are used as packaging revisions in playbooks like MDAD,
+// so they are not considered pre-releases
+func IsPrerelease(version string) bool {
+	_, suffix, ok := strings.Cut(version, "-")
+	if !ok {
+		return false
+	}
+	return strings.ContainsFunc(suffix, unicode.IsLetter)
+}
+
+// IsExact checks if the constraint pins the exact version, e.g. "1.0.0" or "==1.0.0"
+func IsExact(constraint string) bool {
+	constraint = strings.TrimSpace(constraint)
+	if constraint == "" || constraint == "*" || strings.Contains(constraint, ",") {
+		return false
+	}
+	return !strings.ContainsAny(strings.TrimPrefix(constraint, "=="), "<>=!*")
+}
+
+// Satisfies checks if the version satisfies the constraint in ansible-galaxy requirements format:
+// comma-separated list of clauses, each is a version with optional operator (==, !=, >=, >, <=, <),
+// or "*" (and an empty constraint) for any version
+func Satisfies(version, constraint string) bool {
+	for _, clause := range strings.Split(constraint, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" || clause == "*" {
+			continue
+		}
+		if !satisfiesClause(version, clause) {
+			return false
+		}
+	}
+	return true
+}
+
+// satisfiesClause checks if the version satisfies a single constraint clause
+func satisfiesClause(version, clause string) bool {
+	for _, op := range []string{"==", "!=", ">=", "<=", ">", "<", "="} {
+		target, ok := strings.CutPrefix(clause, op)
+		if !ok {
+			continue
+		}
+		cmp := Compare(version, strings.TrimSpace(target))
+		switch op {
+		case "!=":
+			return cmp != 0
+		case ">=":
+			return cmp >= 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		case "<":
+			return cmp < 0
+		default:
+			return cmp == 0
+		}
+	}
+	return Compare(version, clause) == 0
+}
+
+// parts returns the dot-separated parts of the version's core, e.g. v1.2.3-rc.1 -> [1 2 3]
+func parts(version string) []string {
+	core, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	core, _, _ = strings.Cut(core, "+")
+	return strings.Split(core, ".")
+}
+
+// part returns the idx-th part, or "0" if there is no such part
+func part(list []string, idx int) string {
+	if idx < len(list) && list[idx] != "" {
+		return list[idx]
+	}
+	return "0"
+}
+
+// chunks splits a version string into alternating digit and non-digit runs
+func chunks(version string) []string {
+	var (
+		result  []string
+		current strings.Builder
+		digits  bool
+	)
+	for idx, r := range version {
+		isDigit := unicode.IsDigit(r)
+		if idx > 0 && isDigit != digits {
+			result = append(result, current.String())
+			current.Reset()
+		}
+		digits = isDigit
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		result = append(result, current.String())
+	}
+	return result
+}
+
+// compareChunk compares two chunks numerically if both are numbers, lexically otherwise
+func compareChunk(a, b string) int {
+	na, erra := strconv.ParseUint(a, 10, 64)
+	nb, errb := strconv.ParseUint(b, 10, 64)
+	if erra == nil && errb == nil {
+		return compareUint(na, nb)
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,133 @@
+package versions
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"v1.2.3-1", "v1.2.3-0", 1},
+		{"v1.2.3-0", "v1.2.3", 1},
+		{"6.1.0", "6.1", 1},
+	}
+
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.expected {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	if got := Latest([]string{"1.9.0", "1.10.0", "1.2.0"}); got != "1.10.0" {
+		t.Errorf("Latest() = %q, want 1.10.0", got)
+	}
+	if got := Latest(nil); got != "" {
+		t.Errorf("Latest(nil) = %q, want empty", got)
+	}
+}
+
+func TestIsPrerelease(t *testing.T) {
+	tests := map[string]bool{
+		"1.0.0":         false,
+		"v1.2.3-0":      false,
+		"v1.2.3-12":     false,
+		"1.0.0-rc.1":    true,
+		"v2.0.0-beta":   true,
+		"1.0.0-alpha.1": true,
+	}
+	for version, expected := range tests {
+		if got := IsPrerelease(version); got != expected {
+			t.Errorf("IsPrerelease(%q) = %v, want %v", version, got, expected)
+		}
+	}
+}
+
+func TestIsExact(t *testing.T) {
+	tests := map[string]bool{
+		"1.0.0":           true,
+		"==1.0.0":         true,
+		"":                false,
+		"*":               false,
+		">=1.0.0":         false,
+		">=1.0.0,<2.0.0":  false,
+		"!=1.0.0":         false,
+		"1.0.0,!=1.0.1":   false,
+		" 1.0.0 ":         true,
+		"<=1.0.0":         false,
+		"=1.0.0":          false,
+		"1.0.*":           false,
+		"v1.2.3-0":        true,
+		"1.0.0-beta.1":    true,
+		"==1.0.0-alpha.2": true,
+	}
+	for constraint, expected := range tests {
+		if got := IsExact(constraint); got != expected {
+			t.Errorf("IsExact(%q) = %v, want %v", constraint, got, expected)
+		}
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	tests := []struct {
+		version, constraint string
+		expected            bool
+	}{
+		{"1.0.0", "", true},
+		{"1.0.0", "*", true},
+		{"1.0.0", "1.0.0", true},
+		{"1.0.1", "1.0.0", false},
+		{"1.0.0", "==1.0.0", true},
+		{"1.0.0", "!=1.0.0", false},
+		{"1.5.0", ">=1.0.0,<2.0.0", true},
+		{"2.0.0", ">=1.0.0,<2.0.0", false},
+		{"1.0.0", ">1.0.0", false},
+		{"1.0.0", "<=1.0.0", true},
+	}
+	for _, tt := range tests {
+		if got := Satisfies(tt.version, tt.constraint); got != tt.expected {
+			t.Errorf("Satisfies(%q, %q) = %v, want %v", tt.version, tt.constraint, got, tt.expected)
+		}
+	}
+}
+
+func TestPolicySelect(t *testing.T) {
+	available := []string{"v1.2.3", "v1.2.4", "v1.3.0", "v1.4.0-rc.1", "v2.0.0", "v3.0.0-beta", "v1.2.5-1"}
+	tests := []struct {
+		policy   Policy
+		current  string
+		selected string
+		heldBack string
+	}{
+		{Policy{}, "v1.2.3", "v2.0.0", ""},
+		{Policy{Update: UpdateMajor}, "v2.0.0", "", ""},
+		{Policy{Update: UpdateMinor}, "v1.2.3", "v1.3.0", "v2.0.0"},
+		{Policy{Update: UpdatePatch}, "v1.2.3", "v1.2.5-1", "v2.0.0"},
+		{Policy{Update: UpdateMinor, AllowPrerelease: true}, "v1.2.3", "v1.4.0-rc.1", "v3.0.0-beta"},
+		{Policy{AllowPrerelease: true}, "v1.2.3", "v3.0.0-beta", ""},
+		{Policy{Pin: true}, "v1.2.3", "", ""},
+		{Policy{Update: UpdateMinor}, "", "v2.0.0", ""},
+	}
+	for _, tt := range tests {
+		selected, heldBack := tt.policy.Select(tt.current, available)
+		if selected != tt.selected || heldBack != tt.heldBack {
+			t.Errorf("%+v.Select(%q) = %q, %q, want %q, %q", tt.policy, tt.current, selected, heldBack, tt.selected, tt.heldBack)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, update := range []string{"", UpdateMajor, UpdateMinor, UpdatePatch} {
+		if err := (Policy{Update: update}).Validate(); err != nil {
+			t.Errorf("Validate(%q) error = %v", update, err)
+		}
+	}
+	if err := (Policy{Update: "latest"}).Validate(); err == nil {
+		t.Error("Validate(latest) expected error, got nil")
+	}
+}